@@ -0,0 +1,527 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	"github.com/jordan-wright/email"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Appeals
+
+A muted or banned user may appeal each mute or
+ban once. A mute is identified by its key in
+DBMUTES, and a ban by the time it was created.
+
+DBAPPEALS key=itob(NextSequence) sub-bucket:
+user=username
+kind="mute" or "ban"
+sanction=identifier of the mute or ban
+text=text of the appeal
+time=time.MarshalText()
+status="open", "accepted" or "rejected"
+response=answer given by the moderator
+answeredby=username of the moderator
+answered=time.MarshalText()
+*/
+
+const (
+	AppealMute = "mute"
+	AppealBan  = "ban"
+)
+
+const (
+	AppealOpen     = "open"
+	AppealAccepted = "accepted"
+	AppealRejected = "rejected"
+)
+
+/*
+A banned user is logged out, so they enter
+their password to appeal. The appeal may then
+be written from the same certificate for this
+long.
+*/
+const AppealSessionDuration = 15 * time.Minute
+
+var (
+	ErrAppealNotFound  = errors.New("Appeal not found.")
+	ErrAppealAnswered  = errors.New("This appeal has already been answered.")
+	ErrAlreadyAppealed = errors.New("You have already appealed this sanction.")
+	ErrNotSanctioned   = errors.New("You are not muted or banned.")
+	ErrAppealLogin     = errors.New("Login unsuccessful")
+	ErrNotBanned       = errors.New("This account is not banned. Log in to appeal a mute.")
+	ErrNoAppealSession = errors.New("Please enter your username and password again.")
+	ErrEmptyAppeal     = errors.New("The appeal may not be empty.")
+)
+
+type Appeal struct {
+	ID         string
+	User       string
+	Kind       string
+	Sanction   string
+	Text       string
+	Time       time.Time
+	Status     string
+	Response   string
+	AnsweredBy string
+	Answered   time.Time
+}
+
+func (a Appeal) String() string {
+	s := fmt.Sprintf("%s: %s appealed their %s on %s: %s", a.ID, a.User, a.Kind, a.Time.UTC().Format(time.RFC1123), a.Text)
+	if a.Status != AppealOpen {
+		s += fmt.Sprintf(" (%s by %s", a.Status, a.AnsweredBy)
+		if a.Response != "" {
+			s += fmt.Sprintf(": %s", a.Response)
+		}
+		s += ")"
+	}
+	return s
+}
+
+func AppealCapability(kind string) Capability {
+	if kind == AppealBan {
+		return CapBan
+	}
+	return CapMute
+}
+
+func currentSanctionTx(tx *bolt.Tx, username string) (kind, sanction string, ok bool) {
+	/*
+		The ban on this user, or otherwise the
+		mute, which may currently be appealed.
+	*/
+	if ban, banned := IsUserBannedTx(tx, username); banned {
+		return AppealBan, ban.Created.Format(time.RFC3339Nano), true
+	}
+	user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+	if user == nil {
+		return
+	}
+	if isMuted, _ := userMutedStatus(user); !isMuted {
+		return
+	}
+	if history := tx.Bucket(DBMUTES).Bucket([]byte(username)); history != nil {
+		if k, _ := history.Cursor().Last(); k != nil {
+			return AppealMute, string(k), true
+		}
+	}
+	// muted before mutes were recorded
+	return AppealMute, string(user.Get([]byte("muted"))), true
+}
+
+func readAppeal(id []byte, bucket *bolt.Bucket) (a Appeal, err error) {
+	a.ID = string(id)
+	a.User = string(bucket.Get([]byte("user")))
+	a.Kind = string(bucket.Get([]byte("kind")))
+	a.Sanction = string(bucket.Get([]byte("sanction")))
+	a.Text = string(bucket.Get([]byte("text")))
+	a.Status = string(bucket.Get([]byte("status")))
+	a.Response = string(bucket.Get([]byte("response")))
+	a.AnsweredBy = string(bucket.Get([]byte("answeredby")))
+	if err = a.Time.UnmarshalText(bucket.Get([]byte("time"))); err != nil {
+		return
+	}
+	if answered := bucket.Get([]byte("answered")); answered != nil {
+		err = a.Answered.UnmarshalText(answered)
+	}
+	return
+}
+
+func listAppealsTx(tx *bolt.Tx, visible func(Appeal) bool) (appeals []Appeal, err error) {
+	/*
+		Newest first.
+	*/
+	bucket := tx.Bucket(DBAPPEALS)
+	c := bucket.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		if v != nil {
+			continue
+		}
+		a, err := readAppeal(k, bucket.Bucket(k))
+		if err != nil {
+			return nil, err
+		}
+		if visible(a) {
+			appeals = append(appeals, a)
+		}
+	}
+	return
+}
+
+func ListAppeals(all bool, visible func(Appeal) bool) (appeals []Appeal, err error) {
+	/*
+		Answered appeals are only included
+		if all is true.
+	*/
+	err = db.View(func(tx *bolt.Tx) error {
+		appeals, err = listAppealsTx(tx, func(a Appeal) bool {
+			return (all || a.Status == AppealOpen) && visible(a)
+		})
+		return err
+	})
+	return
+}
+
+func GetAppeal(id string) (a Appeal, err error) {
+	key, ok := sequenceKey(id)
+	if !ok {
+		return a, ErrAppealNotFound
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		appeal := tx.Bucket(DBAPPEALS).Bucket(key)
+		if appeal == nil {
+			return ErrAppealNotFound
+		}
+		a, err = readAppeal(key, appeal)
+		return err
+	})
+	return
+}
+
+func SubmitAppeal(username, text string) (id string, err error) {
+	if strings.TrimSpace(text) == "" {
+		return "", ErrEmptyAppeal
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		kind, sanction, ok := currentSanctionTx(tx, username)
+		if !ok {
+			return ErrNotSanctioned
+		}
+		previous, err := listAppealsTx(tx, func(a Appeal) bool {
+			return a.User == username && a.Kind == kind && a.Sanction == sanction
+		})
+		if err != nil {
+			return err
+		}
+		if len(previous) != 0 {
+			return ErrAlreadyAppealed
+		}
+
+		appeals := tx.Bucket(DBAPPEALS)
+		seq, err := appeals.NextSequence()
+		if err != nil {
+			return err
+		}
+		appeal, err := appeals.CreateBucket(itob(seq))
+		if err != nil {
+			return err
+		}
+		now, err := time.Now().MarshalText()
+		if err != nil {
+			return err
+		}
+		id = string(itob(seq))
+		appeal.Put([]byte("user"), []byte(username))
+		appeal.Put([]byte("kind"), []byte(kind))
+		appeal.Put([]byte("sanction"), []byte(sanction))
+		appeal.Put([]byte("text"), []byte(text))
+		appeal.Put([]byte("time"), now)
+		return appeal.Put([]byte("status"), []byte(AppealOpen))
+	})
+	return
+}
+
+func AnswerAppeal(id string, accept bool, by, response string) (a Appeal, lifted bool, err error) {
+	/*
+		Accepting an appeal lifts the mute or
+		ban, unless it has already ended or
+		been replaced by another one.
+	*/
+	key, ok := sequenceKey(id)
+	if !ok {
+		return a, false, ErrAppealNotFound
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		appeal := tx.Bucket(DBAPPEALS).Bucket(key)
+		if appeal == nil {
+			return ErrAppealNotFound
+		}
+		var err error
+		if a, err = readAppeal(key, appeal); err != nil {
+			return err
+		}
+		if a.Status != AppealOpen {
+			return ErrAppealAnswered
+		}
+
+		a.Status = AppealRejected
+		if accept {
+			a.Status = AppealAccepted
+			if kind, sanction, ok := currentSanctionTx(tx, a.User); ok && kind == a.Kind && sanction == a.Sanction {
+				lifted = true
+				switch a.Kind {
+				case AppealMute:
+					err = unmuteUserTx(tx, a.User, by)
+				case AppealBan:
+					err = tx.Bucket(DBBANS).Bucket([]byte(BanUser)).DeleteBucket([]byte(a.User))
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		a.Response = response
+		a.AnsweredBy = by
+		a.Answered = time.Now()
+		answered, err := a.Answered.MarshalText()
+		if err != nil {
+			return err
+		}
+		appeal.Put([]byte("status"), []byte(a.Status))
+		appeal.Put([]byte("response"), []byte(response))
+		appeal.Put([]byte("answeredby"), []byte(by))
+		return appeal.Put([]byte("answered"), answered)
+	})
+	return
+}
+
+func appealOutcomeMessage(a Appeal) string {
+	msg := fmt.Sprintf("Your appeal of your %s has been %s.", a.Kind, a.Status)
+	if a.Response != "" {
+		msg += fmt.Sprintf(" Response: %s", a.Response)
+	}
+	return msg
+}
+
+func SendAppealOutcome(a Appeal) {
+	/*
+		Email the user the answer to their
+		appeal. Called in a new goroutine.
+	*/
+	if !Configuration.Smtp.Enabled {
+		return
+	}
+	var address string
+	if err := db.View(func(tx *bolt.Tx) error {
+		if user := tx.Bucket(DBUSERS).Bucket([]byte(a.User)); user != nil {
+			address = string(user.Get([]byte("email")))
+		}
+		return nil
+	}); err != nil || address == "" {
+		return
+	}
+	em := email.NewEmail()
+	em.From = Configuration.Smtp.From
+	em.To = []string{address}
+	em.Subject = fmt.Sprintf("%s: your appeal has been %s", Configuration.ForumName, a.Status)
+	em.Text = []byte(fmt.Sprintf("%s\n\ngemini://%s/appeal/", appealOutcomeMessage(a), Configuration.Hostname))
+	if err := sendEmail(em); err != nil {
+		log.Printf("Error while sending appeal outcome to %s: %s\n", address, err.Error())
+	}
+}
+
+type appealSession struct {
+	Username string
+	Expires  time.Time
+}
+
+var (
+	appealSessions      = map[string]appealSession{}
+	appealSessionsMutex sync.Mutex
+)
+
+func beginAppealSession(fp []byte, username string) {
+	appealSessionsMutex.Lock()
+	defer appealSessionsMutex.Unlock()
+	// forget sessions which have expired
+	now := time.Now()
+	for key, session := range appealSessions {
+		if now.After(session.Expires) {
+			delete(appealSessions, key)
+		}
+	}
+	appealSessions[string(fp)] = appealSession{
+		Username: username,
+		Expires:  time.Now().Add(AppealSessionDuration),
+	}
+}
+
+func getAppealSession(fp []byte) string {
+	appealSessionsMutex.Lock()
+	defer appealSessionsMutex.Unlock()
+	session, ok := appealSessions[string(fp)]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(session.Expires) {
+		delete(appealSessions, string(fp))
+		return ""
+	}
+	return session.Username
+}
+
+func checkBannedUserPassword(username, password string) (string, error) {
+	/*
+		Returns the registered name of a banned
+		user whose password is correct. The
+		password is checked first, so that
+		others can not find out whether an
+		account exists or is banned.
+	*/
+	var hash []byte
+	var banned bool
+	if err := db.View(func(tx *bolt.Tx) error {
+		if registered, ok := LookupUsernameTx(tx, username); ok {
+			username = registered
+		}
+		if user := tx.Bucket(DBUSERS).Bucket([]byte(username)); user != nil {
+			hash = append([]byte{}, user.Get([]byte("password"))...)
+		}
+		_, banned = IsUserBannedTx(tx, username)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	if hash == nil || CheckPassword(hash, password) != nil {
+		return "", ErrAppealLogin
+	}
+	if !banned {
+		return "", ErrNotBanned
+	}
+	return username, nil
+}
+
+func AppealHandler(u *url.URL, c *tls.Conn) gemini.Response {
+	/*
+		/appeal/ (state of the user's appeals)
+		/appeal/new/?text
+		/appeal/login/<username>/?password (banned users)
+	*/
+	fp := GetFingerprint(c)
+	if fp == nil {
+		return CertRequired
+	}
+	username, priv, _, _ := GetUsernameFromFP(fp)
+	if username == "" {
+		username = getAppealSession(fp)
+	}
+
+	parts := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
+	switch {
+	case len(parts) == 1 && username == "":
+		if u.RawQuery == "" {
+			return gemini.Input.Response("Username of the banned account")
+		}
+		name, err := url.QueryUnescape(u.RawQuery)
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		return gemini.RedirectTemporary.Response(fmt.Sprintf("/appeal/login/%s/", url.PathEscape(name)))
+	case len(parts) == 1:
+		return AppealPage(username)
+	case len(parts) == 2 && parts[1] == "new":
+		if username == "" {
+			return gemini.BadRequest.Error(ErrNoAppealSession)
+		}
+		if u.RawQuery == "" {
+			return gemini.Input.Response("Your appeal")
+		}
+		text, err := url.QueryUnescape(u.RawQuery)
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		id, err := SubmitAppeal(username, text)
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		LogConsoleCommand(username, priv, []string{"appeal", id}, AuditTarget{User: username}, "", gemini.Success)
+		return gemini.RedirectTemporary.Response("/appeal/")
+	case len(parts) == 3 && parts[1] == "login":
+		if u.RawQuery == "" {
+			return gemini.SensitiveInput.Response("Password")
+		}
+		name, err := url.PathUnescape(parts[2])
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		pass, err := url.QueryUnescape(u.RawQuery)
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		name, err = checkBannedUserPassword(name, pass)
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		beginAppealSession(fp, name)
+		return gemini.RedirectTemporary.Response("/appeal/")
+	}
+	return gemini.BadRequest.Response("Bad request")
+}
+
+func AppealPage(username string) gemini.Response {
+	var sanction string
+	var canAppeal bool
+	var appeals []Appeal
+	var settings UserSettings
+	if err := db.View(func(tx *bolt.Tx) error {
+		settings = getUserSettingsTx(tx, username)
+		kind, id, ok := currentSanctionTx(tx, username)
+		if ok {
+			switch kind {
+			case AppealBan:
+				ban, _ := IsUserBannedTx(tx, username)
+				sanction = ban.Message(settings)
+			case AppealMute:
+				_, status := userMutedStatus(tx.Bucket(DBUSERS).Bucket([]byte(username)))
+				sanction = status.Message()
+			}
+		}
+		var err error
+		appeals, err = listAppealsTx(tx, func(a Appeal) bool {
+			return a.User == username
+		})
+		if err != nil {
+			return err
+		}
+		canAppeal = ok
+		for _, a := range appeals {
+			if a.Kind == kind && a.Sanction == id {
+				canAppeal = false
+			}
+		}
+		return nil
+	}); err != nil {
+		return gemini.TemporaryFailure.Error(err)
+	}
+
+	lines := gemini.Lines{}
+	lines.Header(1, "Appeals")
+	if sanction == "" {
+		lines.Line(ErrNotSanctioned.Error())
+	} else {
+		lines.Line(sanction)
+	}
+	if canAppeal {
+		lines.LinkDesc("/appeal/new/", "Write an appeal")
+	} else if sanction != "" {
+		lines.Line(ErrAlreadyAppealed.Error())
+	}
+	for _, a := range appeals {
+		lines.Header(2, fmt.Sprintf("Appeal of %s on %s", a.Kind, settings.FormatPostTime(a.Time)))
+		lines.Quote(a.Text)
+		if a.Status == AppealOpen {
+			lines.Line("This appeal has not been answered yet.")
+		} else {
+			lines.Line(appealOutcomeMessage(a))
+		}
+	}
+	lines.Line("")
+	lines.LinkDesc("/", "Home")
+	return gemini.ResponseFormat{
+		Status: gemini.Success,
+		Mime:   "text/gemini",
+		Lines:  lines,
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestAppeals(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+		Roles: map[string][]Capability{
+			"banner": {CapConsole, CapBan},
+			"locker": {CapConsole, CapLock},
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestAppeals.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 3)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/carol/carol%40example.net/?password", Cert: 3, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/carol/?password", Cert: 3, Response: []byte("30 /\r\n")},
+	)
+
+	/*
+		Appeal of a mute
+	*/
+	ConsoleCommand("alice", Admin, "mute bob 3 spamming links")
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/appeal/new/", Cert: 2, Response: []byte("10 Your appeal\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/new/?it%20was%20a%20joke", Cert: 2, Response: []byte("30 /appeal/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/new/?please", Cert: 2, Response: []byte("59 You have already appealed this sanction.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/new/?hello", Cert: 3, Response: []byte("59 You are not muted or banned.\r\n")},
+	)
+
+	appeals, status := ConsoleCommand("alice", Admin, "appeals")
+	if status != gemini.Success || !strings.HasPrefix(appeals, "0000000000000001: bob appealed their mute on ") || !strings.HasSuffix(appeals, ": it was a joke") {
+		t.Errorf("Recieved %d %q", status, appeals)
+	}
+	if entries, err := ReadAuditLog(AuditFilter{Command: "appeal"}, 0); err != nil || len(entries) != 1 || entries[0].String() != "bob/User:appeal 0000000000000001" || entries[0].Target.User != "bob" {
+		t.Errorf("Recieved %v %v", entries, err)
+	}
+
+	if response, status := ConsoleCommand("alice", Admin, "answer 1 reject it was not"); status != gemini.Success || response != "Appeal has been rejected." {
+		t.Errorf("Recieved %d %q", status, response)
+	}
+	if response, status := ConsoleCommand("alice", Admin, "answer 1 accept"); status != gemini.BadRequest || response != ErrAppealAnswered.Error() {
+		t.Errorf("Recieved %d %q", status, response)
+	}
+	if appeals, _ := ConsoleCommand("alice", Admin, "appeals"); appeals != "There are no open appeals." {
+		t.Errorf("Recieved %q", appeals)
+	}
+	page := strings.Join(AppealPage("bob").(gemini.ResponseFormat).Lines, "\n")
+	for _, line := range []string{
+		"Reason: spamming links\nYou have already appealed this sanction.",
+		"> it was a joke\nYour appeal of your mute has been rejected. Response: it was not",
+	} {
+		if !strings.Contains(page, line) {
+			t.Errorf("%q not in %q", line, page)
+		}
+	}
+
+	/*
+		A banned user is logged out, so enters
+		their password to appeal.
+	*/
+	ConsoleCommand("alice", Admin, "ban bob")
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/appeal/", Cert: 2, Response: []byte("10 Username of the banned account\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/?bob", Cert: 2, Response: []byte("30 /appeal/login/bob/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/login/bob/", Cert: 2, Response: []byte("11 Password\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/login/bob/?wrong", Cert: 2, Response: []byte("59 Login unsuccessful\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/new/?hello", Cert: 2, Response: []byte("59 Please enter your username and password again.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/login/bob/?password", Cert: 2, Response: []byte("30 /appeal/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/new/?sorry", Cert: 2, Response: []byte("30 /appeal/\r\n")},
+		// the ban is only revealed after the password
+		gemtest.Input{URL: "gemini://localhost/appeal/login/carol/?wrong", Cert: 3, Response: []byte("59 Login unsuccessful\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/login/nobody/?password", Cert: 3, Response: []byte("59 Login unsuccessful\r\n")},
+		gemtest.Input{URL: "gemini://localhost/appeal/login/carol/?password", Cert: 3, Response: []byte("59 This account is not banned. Log in to appeal a mute.\r\n")},
+	)
+
+	/*
+		A user who may ban, but not mute,
+		answers appeals of bans
+	*/
+	if err := AssignRole("carol", "locker", GlobalScope); err != nil {
+		t.Fatal(err.Error())
+	}
+	if response, status := ConsoleCommand("carol", User, "appeals"); status != gemini.CertificateNotAuthorised {
+		t.Errorf("Recieved %d %q", status, response)
+	}
+	if err := AssignRole("carol", "banner", GlobalScope); err != nil {
+		t.Fatal(err.Error())
+	}
+	if appeals, _ := ConsoleCommand("carol", User, "appeals"); !strings.HasPrefix(appeals, "0000000000000002: bob appealed their ban on ") {
+		t.Errorf("Recieved %q", appeals)
+	}
+	if response, status := ConsoleCommand("carol", User, "answer 2 accept welcome back"); status != gemini.Success || response != "Appeal has been accepted, and the ban has been lifted." {
+		t.Errorf("Recieved %d %q", status, response)
+	}
+	if all, _ := ConsoleCommand("alice", Admin, "appeals all"); !strings.Contains(all, ": sorry (accepted by carol: welcome back)\n0000000000000001: ") {
+		t.Errorf("Recieved %q", all)
+	}
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+	)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Console audit log
+
+DBCONSOLELOG key=time.RFC3339Nano value=JSON encoded AuditEntry
+
+Entries written before the log was structured are
+plain "user/priviledge:command" strings. They are
+read into an AuditEntry with only the actor,
+priviledge and command set.
+*/
+
+var ErrInvalidAuditDate = errors.New("Invalid date: use YYYY-MM-DD or RFC3339.")
+
+type AuditTarget struct {
+	User   string `json:"user,omitempty"`
+	Thread string `json:"thread,omitempty"`
+	Post   string `json:"post,omitempty"`
+}
+
+type AuditEntry struct {
+	Time       time.Time     `json:"time"`
+	Actor      string        `json:"actor"`
+	Priviledge string        `json:"priviledge"`
+	Command    string        `json:"command"`
+	Arguments  []string      `json:"arguments,omitempty"`
+	Target     AuditTarget   `json:"target"`
+	Status     gemini.Status `json:"status,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+func (a AuditEntry) String() string {
+	s := fmt.Sprintf("%s/%s:%s", a.Actor, a.Priviledge, strings.Join(append([]string{a.Command}, a.Arguments...), " "))
+	if a.Error != "" {
+		s += fmt.Sprintf(" (%d %s)", a.Status, a.Error)
+	}
+	return s
+}
+
+func LogConsoleCommand(user string, priv UserPriviledge, fields []string, target AuditTarget, response string, status gemini.Status) {
+	entry := AuditEntry{
+		Time:       time.Now(),
+		Actor:      user,
+		Priviledge: priv.String(),
+		Target:     target,
+		Status:     status,
+	}
+	if len(fields) > 0 {
+		entry.Command = fields[0]
+		entry.Arguments = fields[1:]
+	}
+	if status != gemini.Success {
+		entry.Error = response
+	}
+	val, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Println("Error during logging console command:", err.Error())
+		return
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		logs := tx.Bucket(DBCONSOLELOG)
+		key := []byte(entry.Time.Format(time.RFC3339Nano))
+		return logs.Put(key, val)
+	}); err != nil {
+		fmt.Println("Error during logging console command:", err.Error())
+	}
+}
+
+func auditTarget(fields []string) (target AuditTarget) {
+	/*
+		The user, thread or post affected by
+		a console command. This is found
+		before the command runs, because
+		some commands (approvepost, rejectpost)
+		remove what the arguments refer to.
+	*/
+	if len(fields) < 2 {
+		return
+	}
+	switch fields[0] {
+	case "lock", "unlock", "move":
+		target.Thread = fields[1]
+	case "mute", "unmute", "mutes", "ban", "unban", "promote", "demote", "rename", "role", "unrole", "roles", "approve", "reject", "user", "purge", "unpurge", "reset2fa":
+		target.User = fields[1]
+	case "resolve":
+		if r, err := GetReport(fields[1]); err == nil {
+			target = AuditTarget{User: r.Author, Thread: r.Thread, Post: r.Post}
+		}
+	case "answer":
+		if a, err := GetAppeal(fields[1]); err == nil {
+			target.User = a.User
+		}
+	case "approvepost", "rejectpost":
+		if h, err := GetHeldPost(fields[1]); err == nil {
+			target = AuditTarget{User: h.User, Thread: h.Thread}
+		}
+	}
+	return
+}
+
+func readAuditEntry(k, v []byte) (a AuditEntry, err error) {
+	if len(v) > 0 && v[0] == '{' {
+		err = json.Unmarshal(v, &a)
+		return
+	}
+	/*
+		Unstructured entry
+	*/
+	if err = a.Time.UnmarshalText(k); err != nil {
+		return
+	}
+	who, command, _ := strings.Cut(string(v), ":")
+	a.Actor, a.Priviledge, _ = strings.Cut(who, "/")
+	if fields := strings.Fields(command); len(fields) > 0 {
+		a.Command = fields[0]
+		a.Arguments = fields[1:]
+	}
+	return
+}
+
+type AuditFilter struct {
+	Actor   string
+	Command string
+	Target  string // username, thread ID or post ID
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f AuditFilter) Match(a AuditEntry) bool {
+	if f.Actor != "" && a.Actor != f.Actor {
+		return false
+	}
+	if f.Command != "" && a.Command != f.Command {
+		return false
+	}
+	if f.Target != "" && a.Target.User != f.Target && a.Target.Thread != f.Target && a.Target.Post != f.Target {
+		return false
+	}
+	if !f.Since.IsZero() && a.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !a.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+func ParseAuditDate(s string, endOfDay bool) (time.Time, error) {
+	/*
+		A date alone covers the whole day, so
+		for an end of a range it means the
+		start of the following day.
+	*/
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return t, ErrInvalidAuditDate
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func ReadAuditLog(filter AuditFilter, limit int) (entries []AuditEntry, err error) {
+	/*
+		Newest first. limit <= 0 returns
+		every matching entry.
+	*/
+	err = db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(DBCONSOLELOG).Cursor()
+		for k, v := c.Last(); k != nil && (limit <= 0 || len(entries) < limit); k, v = c.Prev() {
+			a, err := readAuditEntry(k, v)
+			if err != nil {
+				return err
+			}
+			if filter.Match(a) {
+				entries = append(entries, a)
+			}
+		}
+		return nil
+	})
+	return
+}
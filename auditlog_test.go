@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestAuditLog(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+			"bob":   Mod,
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestAuditLog.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	/*
+		Unstructured entry from before
+	*/
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(DBCONSOLELOG).Put([]byte("2020-01-02T03:04:05Z"), []byte("alice/Admin:lock 0000000000000001"))
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	OnNewThread("firstsub", "alice", "first", "hello")
+	for _, c := range []struct {
+		User    string
+		Priv    UserPriviledge
+		Command string
+		Status  gemini.Status
+	}{
+		{"bob", Mod, "lock 0000000000000001", gemini.Success},
+		{"bob", Mod, "mute charlie 3", gemini.BadRequest},
+		{"alice", Admin, "log hello world", gemini.Success},
+	} {
+		if _, status := ConsoleCommand(c.User, c.Priv, c.Command); status != c.Status {
+			t.Fatalf("%s: expected status %d, recieved %d", c.Command, c.Status, status)
+		}
+	}
+
+	for _, c := range []struct {
+		Command  string
+		Status   gemini.Status
+		Response string
+	}{
+		{"read notime", gemini.Success, "alice/Admin:log hello world\nbob/Mod:mute charlie 3 (59 User not found)\nbob/Mod:lock 0000000000000001\nalice/Admin:lock 0000000000000001"},
+		{"read 1 notime actor=bob", gemini.Success, "bob/Mod:mute charlie 3 (59 User not found)"},
+		{"read notime command=lock", gemini.Success, "bob/Mod:lock 0000000000000001\nalice/Admin:lock 0000000000000001"},
+		{"read notime target=charlie", gemini.Success, "bob/Mod:mute charlie 3 (59 User not found)"},
+		{"read all notime until=2020-01-02", gemini.Success, "alice/Admin:lock 0000000000000001"},
+		{"read all notime since=2020-01-03 command=lock", gemini.Success, "bob/Mod:lock 0000000000000001"},
+		{"read notime since=yesterday", gemini.BadRequest, ErrInvalidAuditDate.Error()},
+		{"read notime sort=time", gemini.BadRequest, `Usage: read [number of commands/"all"] ["notime"] ["json"] [actor=<username>] [command=<command>] [target=<username/thread ID/post ID>] [since=<date>] [until=<date>]`},
+	} {
+		response, status := ConsoleCommand("alice", Admin, c.Command)
+		if status != c.Status || response != c.Response {
+			t.Errorf("%s: expected %d %q, recieved %d %q", c.Command, c.Status, c.Response, status, response)
+		}
+	}
+
+	/*
+		Export as JSON lines
+	*/
+	response, status := ConsoleCommand("alice", Admin, "read all json actor=bob")
+	if status != gemini.Success {
+		t.Fatalf("Recieved %d %q", status, response)
+	}
+	lines := strings.Split(response, "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, recieved %q", response)
+	}
+	var entry AuditEntry
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatal(err.Error())
+	}
+	if entry.Actor != "bob" || entry.Priviledge != "Mod" || entry.Command != "lock" || entry.Target.Thread != "0000000000000001" || entry.Status != gemini.Success || entry.Error != "" {
+		t.Errorf("Incorrect entry: %+v", entry)
+	}
+}
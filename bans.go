@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Bans
+
+Unlike a mute, a banned user can not log in or
+use the forum at all. DBBANS contains a sub-bucket
+for each kind of ban (BanUser, BanIP, BanCert).
+In each, key=username/CIDR/fingerprint and the
+value is a sub-bucket:
+by=username of moderator
+created=time.Now().MarshalText()
+expires=time.MarshalText() ("" = never)
+reason=reason given by the moderator
+*/
+
+const (
+	BanUser = "user"
+	BanIP   = "ip"
+	BanCert = "cert"
+)
+
+var (
+	ErrBanNotFound     = errors.New("Ban not found.")
+	ErrInvalidCIDR     = errors.New("Invalid IP address or CIDR range.")
+	ErrBanNotLower     = errors.New("You may only ban users with a lower priviledge than yourself.")
+	ErrInvalidBanDays  = errors.New("Invalid field: number of days must be a number > 0.")
+	ErrInvalidBanKind  = errors.New("Invalid ban type")
+	ErrCertFPMalformed = errors.New("Certificate fingerprint should be the base64 encoded SHA-256 hash of the certificate.")
+	ErrBanRangeTooWide = errors.New("Only administrators may ban ranges wider than a single client network.")
+)
+
+type Ban struct {
+	Kind    string
+	Target  string
+	By      string
+	Created time.Time
+	Expires time.Time // zero = never
+	Reason  string
+}
+
+func (b Ban) Expired() bool {
+	return !b.Expires.IsZero() && time.Now().After(b.Expires)
+}
+
+// used in the console
+var banListSettings = UserSettings{TimeZone: "UTC", Timestamps: TimestampsAbsolute}
+
+func (b Ban) Until(settings UserSettings) string {
+	if b.Expires.IsZero() {
+		return "permanently"
+	}
+	return fmt.Sprintf("until %s", settings.FormatFutureTime(b.Expires))
+}
+
+func (b Ban) String() string {
+	reason := b.Reason
+	if reason == "" {
+		reason = "no reason given"
+	}
+	return fmt.Sprintf("%s %s - by %s, %s (%s)", b.Kind, b.Target, b.By, b.Until(banListSettings), reason)
+}
+
+/*
+Message shown to a banned user or connection,
+in their time zone.
+*/
+func (b Ban) Message(settings UserSettings) string {
+	msg := fmt.Sprintf("You have been banned %s.", b.Until(settings))
+	if b.Kind == BanUser {
+		msg += " You may appeal at /appeal/."
+	}
+	if b.Reason != "" {
+		msg += fmt.Sprintf(" Reason: %s", b.Reason)
+	}
+	return msg
+}
+
+func MayBan(actor UserPriviledge, target string) bool {
+	/*
+		Moderators and admins may only be banned
+		by someone with a higher priviledge.
+		Admins may ban anyone, and users given
+		the "ban" capability by a role may ban
+		other users.
+	*/
+	targetPriv := LookupUserPriviledge(target)
+	return actor == Admin || !targetPriv.Is(Mod) || targetPriv < actor
+}
+
+func readBan(kind string, target []byte, bucket *bolt.Bucket) (b Ban, err error) {
+	b.Kind = kind
+	b.Target = string(target)
+	b.By = string(bucket.Get([]byte("by")))
+	b.Reason = string(bucket.Get([]byte("reason")))
+	if err = b.Created.UnmarshalText(bucket.Get([]byte("created"))); err != nil {
+		return
+	}
+	if expires := bucket.Get([]byte("expires")); len(expires) != 0 {
+		err = b.Expires.UnmarshalText(expires)
+	}
+	return
+}
+
+func getBanTx(tx *bolt.Tx, kind, target string) (b Ban, banned bool) {
+	/*
+		Returns the ban on this target, if it
+		exists and has not expired.
+	*/
+	all := tx.Bucket(DBBANS)
+	if all == nil {
+		return
+	}
+	kindBucket := all.Bucket([]byte(kind))
+	if kindBucket == nil {
+		return
+	}
+	bucket := kindBucket.Bucket([]byte(target))
+	if bucket == nil {
+		return
+	}
+	b, err := readBan(kind, []byte(target), bucket)
+	if err != nil {
+		log.Println(err.Error())
+		return b, false
+	}
+	return b, !b.Expired()
+}
+
+func IsUserBannedTx(tx *bolt.Tx, username string) (Ban, bool) {
+	return getBanTx(tx, BanUser, username)
+}
+
+func GetConnectionBan(ip string, fp []byte) (b Ban, banned bool) {
+	/*
+		Check whether this IP address or client
+		certificate is banned.
+	*/
+	addr := net.ParseIP(ip)
+	if err := db.View(func(tx *bolt.Tx) error {
+		if fp != nil {
+			if b, banned = getBanTx(tx, BanCert, string(fp)); banned {
+				return nil
+			}
+		}
+		all := tx.Bucket(DBBANS)
+		if all == nil || addr == nil {
+			return nil
+		}
+		ips := all.Bucket([]byte(BanIP))
+		if ips == nil {
+			return nil
+		}
+		return ips.ForEach(func(k, v []byte) error {
+			if banned {
+				return nil
+			}
+			_, network, err := net.ParseCIDR(string(k))
+			if err != nil || !network.Contains(addr) {
+				return nil
+			}
+			b, banned = getBanTx(tx, BanIP, string(k))
+			return nil
+		})
+	}); err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+func BannedResponse(b Ban, settings UserSettings) gemini.Response {
+	return gemini.PermanentFailure.Response(b.Message(settings))
+}
+
+const (
+	DefaultIPv4Prefix = 32
+	DefaultIPv6Prefix = 64
+)
+
+var ErrInvalidPrefixLength = errors.New("Invalid prefix length (IPv4Prefix must be 1-32 and IPv6Prefix 1-128)")
+
+func ValidatePrefixLengths(v4, v6 int) error {
+	if v4 < 0 || v4 > 32 || v6 < 0 || v6 > 128 {
+		return ErrInvalidPrefixLength
+	}
+	return nil
+}
+
+func ClientPrefixLength(addr net.IP) int {
+	/*
+		Clients are grouped by network, because
+		an IPv6 client usually has a whole /64
+		of addresses to choose from.
+	*/
+	if addr.To4() != nil {
+		if Configuration != nil && Configuration.IPv4Prefix > 0 {
+			return Configuration.IPv4Prefix
+		}
+		return DefaultIPv4Prefix
+	}
+	if Configuration != nil && Configuration.IPv6Prefix > 0 {
+		return Configuration.IPv6Prefix
+	}
+	return DefaultIPv6Prefix
+}
+
+func ClientNetwork(ip string) string {
+	/*
+		The network of this client address,
+		used to count requests. Returns ip if
+		it is not a valid address.
+	*/
+	network, err := NormalizeCIDR(ip)
+	if err != nil {
+		return ip
+	}
+	return network
+}
+
+func NormalizeCIDR(s string) (string, error) {
+	/*
+		Accepts a CIDR range or a single address.
+		A single address is widened to the
+		network it is grouped in (see
+		ClientPrefixLength).
+	*/
+	if !strings.Contains(s, "/") {
+		addr := net.ParseIP(s)
+		if addr == nil {
+			return "", ErrInvalidCIDR
+		}
+		if v4 := addr.To4(); v4 != nil {
+			s = fmt.Sprintf("%s/%d", v4, ClientPrefixLength(addr))
+		} else {
+			s = fmt.Sprintf("%s/%d", addr, ClientPrefixLength(addr))
+		}
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", ErrInvalidCIDR
+	}
+	return network.String(), nil
+}
+
+func IsWideCIDR(cidr string) bool {
+	/*
+		Whether this range contains more than
+		one client network (see
+		ClientPrefixLength).
+	*/
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, _ := network.Mask.Size()
+	return ones < ClientPrefixLength(network.IP)
+}
+
+func AddBan(kind, target, by string, days int, reason string) error {
+	/*
+		days = 0 for a permanent ban
+	*/
+	if days < 0 {
+		return ErrInvalidBanDays
+	}
+	switch kind {
+	case BanUser, BanCert:
+	case BanIP:
+		var err error
+		if target, err = NormalizeCIDR(target); err != nil {
+			return err
+		}
+	default:
+		return ErrInvalidBanKind
+	}
+	if kind == BanCert && len(target) != 44 {
+		return ErrCertFPMalformed
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return addBanTx(tx, kind, target, by, days, reason)
+	})
+}
+
+func addBanTx(tx *bolt.Tx, kind, target, by string, days int, reason string) error {
+	if kind == BanUser {
+		if tx.Bucket(DBUSERS).Bucket([]byte(target)) == nil {
+			return ErrUserNotFound
+		}
+		if err := revokeCertificatesTx(tx, target); err != nil {
+			return err
+		}
+	}
+
+	kindBucket, err := tx.Bucket(DBBANS).CreateBucketIfNotExists([]byte(kind))
+	if err != nil {
+		return err
+	}
+	if kindBucket.Bucket([]byte(target)) != nil {
+		// replace previous ban
+		if err := kindBucket.DeleteBucket([]byte(target)); err != nil {
+			return err
+		}
+	}
+	bucket, err := kindBucket.CreateBucket([]byte(target))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	nowBytes, err := now.MarshalText()
+	if err != nil {
+		return err
+	}
+	var expires []byte
+	if days > 0 {
+		if expires, err = now.Add(time.Hour * 24 * time.Duration(days)).MarshalText(); err != nil {
+			return err
+		}
+	}
+	bucket.Put([]byte("by"), []byte(by))
+	bucket.Put([]byte("created"), nowBytes)
+	bucket.Put([]byte("expires"), expires)
+	return bucket.Put([]byte("reason"), []byte(reason))
+}
+
+func RemoveBan(kind, target string) error {
+	if kind == BanIP {
+		var err error
+		if target, err = NormalizeCIDR(target); err != nil {
+			return err
+		}
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		kindBucket := tx.Bucket(DBBANS).Bucket([]byte(kind))
+		if kindBucket == nil || kindBucket.Bucket([]byte(target)) == nil {
+			return ErrBanNotFound
+		}
+		return kindBucket.DeleteBucket([]byte(target))
+	})
+}
+
+func ListBans() (bans []Ban, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		all := tx.Bucket(DBBANS)
+		for _, kind := range []string{BanUser, BanIP, BanCert} {
+			kindBucket := all.Bucket([]byte(kind))
+			if kindBucket == nil {
+				continue
+			}
+			if err := kindBucket.ForEach(func(k, v []byte) error {
+				b, err := readBan(kind, k, kindBucket.Bucket(k))
+				if err != nil {
+					return err
+				}
+				if !b.Expired() {
+					bans = append(bans, b)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func revokeCertificatesTx(tx *bolt.Tx, username string) error {
+	/*
+		Log out every certificate of this user.
+	*/
+	fps := tx.Bucket(DBFP)
+	var remove [][]byte
+	if err := fps.ForEach(func(k, v []byte) error {
+		if string(v) == username {
+			fp := make([]byte, len(k))
+			copy(fp, k)
+			remove = append(remove, fp)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, fp := range remove {
+		if err := fps.Delete(fp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	"github.com/google/go-cmp/cmp"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBans(t *testing.T) {
+	Configuration = &ConfigStr{
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+			"carol": Mod,
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestBans.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 4)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/carol/carol%40example.net/?password", Cert: 3, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/carol/?password", Cert: 3, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/settings/", Cert: 2, Response: []byte("20 text/gemini\r\n# Settings for bob\r\n\r\n## Display\r\n=> /settings/timezone/ Time zone: UTC\r\n=> /settings/timestamps/ Timestamps: relative\r\n=> /settings/postsperpage/ Posts per page: all\r\n=> /settings/threadsort/ Thread order: activity\r\n## Email notifications\r\n=> /settings/notifyreplies/ Replies to my threads: off\r\n=> /settings/notifymentions/ Mentions of @bob: off\r\n## Security\r\n=> /settings/2fa/ Two-factor authentication: off\r\n## Ignored users\r\n=> /settings/ignore/ Ignore a user\r\n## Posting reminder\r\nNot seen yet\r\n\r\n=> / Go to home.\r\n")},
+
+		/*
+			Moderators may not ban other moderators or admins
+		*/
+		gemtest.Input{URL: "gemini://localhost/console/?ban%20alice", Cert: 3, Response: []byte("59 You may only ban users with a lower priviledge than yourself.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?ban%20nobody", Cert: 3, Response: []byte("59 User not found\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?ban%20bob%200", Cert: 3, Response: []byte("59 Invalid field: number of days must be a number > 0.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?ban%20bob%20permanent%20spamming%20links", Cert: 3, Response: []byte("20 text/plain\r\nBan has been added.")},
+		gemtest.Input{URL: "gemini://localhost/console/?bans", Cert: 1, Response: []byte("20 text/plain\r\nuser bob - by carol, permanently (spamming links)")},
+
+		/*
+			bob was logged out and may not log in again
+		*/
+		gemtest.Input{URL: "gemini://localhost/settings/", Cert: 2, Response: []byte("61 Unauthorized\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("59 You have been banned permanently. You may appeal at /appeal/. Reason: spamming links\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?unban%20bob", Cert: 1, Response: []byte("20 text/plain\r\nBan has been removed.")},
+		gemtest.Input{URL: "gemini://localhost/console/?unban%20bob", Cert: 1, Response: []byte("59 Ban not found.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+
+		gemtest.Input{URL: "gemini://localhost/console/?banip%20not-an-address", Cert: 1, Response: []byte("59 Invalid IP address or CIDR range.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?banip%20127.0.0.0%2F8", Cert: 3, Response: []byte("59 " + ErrBanRangeTooWide.Error() + "\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?banip%20127.0.0.0%2F8", Cert: 1, Response: []byte("20 text/plain\r\nBan has been added.")},
+		gemtest.Input{URL: "gemini://localhost/", Cert: 0, Response: []byte("50 You have been banned permanently.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/", Cert: 2, Response: []byte("50 You have been banned permanently.\r\n")},
+
+		// moderators and admins are not locked out
+		gemtest.Input{URL: "gemini://localhost/console/?help", Cert: 1, Response: []byte("20 text/plain\r\n" + ConsoleHelp("alice", Admin))},
+	)
+
+	/*
+		A certificate logged in to an admin
+		can not be banned by a moderator
+	*/
+	var aliceFP string
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(DBFP).ForEach(func(k, v []byte) error {
+			if string(v) == "alice" {
+				aliceFP = string(k)
+			}
+			return nil
+		})
+	})
+	if response, status := ConsoleCommand("carol", Mod, "bancert "+aliceFP); status != gemini.BadRequest || response != ErrBanNotLower.Error() {
+		t.Errorf("Recieved %d %q", status, response)
+	}
+
+	if resp, status := DoCommand("unbanip 127.0.0.1/8"); status != gemini.Success {
+		t.Fatalf("unbanip: %s", resp)
+	}
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/console/?bans", Cert: 1, Response: []byte("20 text/plain\r\n")},
+	)
+}
+
+func TestParseBanArguments(t *testing.T) {
+	for _, c := range []struct {
+		Fields []string
+		Days   int
+		Reason string
+		Err    error
+	}{
+		{nil, 0, "", nil},
+		{[]string{"permanent"}, 0, "", nil},
+		{[]string{"7", "spam"}, 7, "spam", nil},
+		{[]string{"spam", "and", "abuse"}, 0, "spam and abuse", nil},
+		{[]string{"-1"}, 0, "", ErrInvalidBanDays},
+	} {
+		days, reason, err := parseBanArguments(c.Fields)
+		if days != c.Days || reason != c.Reason || !errors.Is(err, c.Err) {
+			t.Errorf("%q: recieved %d %q %v", c.Fields, days, reason, err)
+		}
+	}
+}
+
+func TestNormalizeCIDR(t *testing.T) {
+	Configuration = &ConfigStr{}
+	var out []string
+	for _, in := range []string{"192.0.2.7", "192.0.2.7/24", "2001:db8::1", "2001:db8::1/48"} {
+		n, err := NormalizeCIDR(in)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		out = append(out, n)
+	}
+	if expected := []string{"192.0.2.7/32", "192.0.2.0/24", "2001:db8::/64", "2001:db8::/48"}; !cmp.Equal(out, expected) {
+		t.Error(cmp.Diff(expected, out))
+	}
+}
+
+func TestClientNetwork(t *testing.T) {
+	Configuration = &ConfigStr{}
+	for ip, expected := range map[string]string{
+		"192.0.2.7":              "192.0.2.7/32",
+		"::ffff:192.0.2.7":       "192.0.2.7/32",
+		"2001:db8:1:2:3:4:5:6":   "2001:db8:1:2::/64",
+		"2001:db8:1:2:ffff::abc": "2001:db8:1:2::/64",
+		"not-an-address":         "not-an-address",
+	} {
+		if network := ClientNetwork(ip); network != expected {
+			t.Errorf("%s: expected %s, recieved %s", ip, expected, network)
+		}
+	}
+
+	Configuration = &ConfigStr{IPv4Prefix: 24, IPv6Prefix: 48}
+	for ip, expected := range map[string]string{
+		"192.0.2.7":            "192.0.2.0/24",
+		"2001:db8:1:2:3:4:5:6": "2001:db8:1::/48",
+	} {
+		if network := ClientNetwork(ip); network != expected {
+			t.Errorf("%s: expected %s, recieved %s", ip, expected, network)
+		}
+	}
+	if err := ValidatePrefixLengths(33, 64); err != ErrInvalidPrefixLength {
+		t.Errorf("Expected ErrInvalidPrefixLength, recieved %v", err)
+	}
+}
+
+func TestMayBan(t *testing.T) {
+	Configuration = &ConfigStr{
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+			"bob":   Mod,
+			"carol": Mod,
+		},
+	}
+	var err error
+	var testDBpath string = ".testing/TestMayBan.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, c := range []struct {
+		Actor    UserPriviledge
+		Target   string
+		Expected bool
+	}{
+		{User, "dave", true}, // a user with the "ban" capability from a role
+		{User, "bob", false},
+		{Mod, "dave", true},
+		{Mod, "carol", false},
+		{Mod, "alice", false},
+		{Admin, "bob", true},
+		{Admin, "alice", true},
+	} {
+		if MayBan(c.Actor, c.Target) != c.Expected {
+			t.Errorf("MayBan(%s, %q) != %v", c.Actor, c.Target, c.Expected)
+		}
+	}
+}
+
+func TestBanMessage(t *testing.T) {
+	b := Ban{Kind: BanUser, Expires: time.Date(2040, time.January, 2, 12, 0, 0, 0, time.UTC), Reason: "spam"}
+	settings := UserSettings{TimeZone: "Europe/Berlin", Timestamps: TimestampsAbsolute}
+	if msg := b.Message(settings); msg != "You have been banned until Mon, 02 Jan 2040 13:00:00 CET. You may appeal at /appeal/. Reason: spam" {
+		t.Errorf("Recieved %q", msg)
+	}
+	if s := b.String(); s != "user  - by , until Mon, 02 Jan 2040 12:00:00 UTC (spam)" {
+		t.Errorf("Recieved %q", s)
+	}
+}
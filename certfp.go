@@ -32,7 +32,7 @@ func CertFP(cert *x509.Certificate) []byte {
 }
 
 func GetFingerprint(c *tls.Conn) []byte {
-	if c == nil {
+	if c == nil || c.NetConn() == nil {
 		// failsafe if this is called during testing
 		return nil
 	}
@@ -65,12 +65,12 @@ func GetUsernameFromFP(fp []byte) (username string, priv UserPriviledge, isMuted
 			return nil
 		}
 
-		isMuted, mutedStatus = IsUserCurrentlyMuted(user.Get([]byte("muted")))
+		isMuted, mutedStatus = userMutedStatus(user)
+		priv = GetUserPriviledgeTx(tx, username)
 		return nil
 	}); err != nil {
 		log.Println(err.Error())
 	}
-	priv = Configuration.Priviledges[username]
 	return
 }
 
@@ -86,7 +86,7 @@ func IsUserCurrentlyMuted(mutedValue []byte) (bool, MutedStatus) {
 	}
 
 	if bytes.Equal(mutedValue, PERMANENTLYMUTED) {
-		return true, MutedStatus{true, 0}
+		return true, MutedStatus{IsPermanent: true}
 	}
 
 	/*
@@ -101,12 +101,13 @@ func IsUserCurrentlyMuted(mutedValue []byte) (bool, MutedStatus) {
 	remaining := time.Until(unmuteTime)
 
 	// all ok
-	return remaining > 0, MutedStatus{false, remaining}
+	return remaining > 0, MutedStatus{Remaining: remaining}
 }
 
 type MutedStatus struct {
 	IsPermanent bool
 	Remaining   time.Duration
+	Reason      string
 }
 
 func (m MutedStatus) String() string {
@@ -116,3 +117,14 @@ func (m MutedStatus) String() string {
 	rem := durafmt.ParseShort(m.Remaining)
 	return fmt.Sprintf("temporarily muted (%s)", rem)
 }
+
+/*
+Message shown to a muted user.
+*/
+func (m MutedStatus) Message() string {
+	msg := fmt.Sprintf("You are currently %s.", m)
+	if m.Reason != "" {
+		msg += fmt.Sprintf(" Reason: %s", m.Reason)
+	}
+	return msg
+}
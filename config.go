@@ -43,6 +43,71 @@ type ConfigStrSmtp struct {
 	Pass    string
 }
 
+type ConfigVerification struct {
+	CodeExpiry      time.Duration // in hours (default 48)
+	PurgeUnverified time.Duration // in hours (0 = never purge)
+}
+
+type ConfigRegistration struct {
+	Mode     string   // "open", "closed", "invite", "approval"
+	Reserved []string // usernames which may not be registered (nor names that look like them)
+}
+
+type ConfigProofOfWork struct {
+	Enabled        bool
+	Difficulty     int // leading zero bits (default 20)
+	MaxDifficulty  int // default 28
+	SpikeThreshold int // registrations per hour before the difficulty rises (0 = never)
+}
+
+type ConfigTwoFactor struct {
+	RequireForMods bool // moderators and admins must enable 2FA to use the console
+}
+
+type ConfigPassword struct {
+	Algorithm  string // "argon2id" (default) or "bcrypt"
+	BcryptCost int    // default 12
+	Time       uint32 // Argon2id passes (default 3)
+	Memory     uint32 // Argon2id memory in KiB (default 65536)
+	Threads    uint8  // Argon2id parallelism (default 4)
+}
+
+type ConfigRateLimitClass struct {
+	Requests int64
+	Window   time.Duration // in seconds
+}
+
+type ConfigRateLimit struct {
+	Trusted []string                        // usernames which are not rate limited
+	Class   map[string]ConfigRateLimitClass // read, search, post, register, login, report, appeal
+}
+
+type ConfigReports struct {
+	HideThreshold int // hide a post pending review after this many users report it (0 = never)
+}
+
+type ConfigHold struct {
+	FirstPosts int           // hold a user's posts until this many have been approved (0 = never)
+	Hours      time.Duration // hold posts made this many hours after registering (0 = never)
+}
+
+type ConfigPurge struct {
+	UndoHours time.Duration // purged threads and posts are deleted after this many hours (default 72)
+}
+
+type ConfigFilterRule struct {
+	Name      string
+	Type      string   // "regex", "words", "links", "caps", "repeat" (see filter.go)
+	Pattern   string   // regex
+	Words     []string // words
+	Max       int      // links: most links allowed, repeat: most times a character may repeat (default 10)
+	Ratio     float64  // caps: fraction of letters which may be capitals (default 0.7)
+	MinLength int      // caps: fewest letters to check (default 10)
+	Target    string   // "text", "title" or "" (both)
+	Action    string   // "reject", "hold", "flag"
+	Message   string   // shown when a post is rejected
+}
+
 type ConfigAdminStr struct {
 	Email []string // to: addresses for reports (not reported)
 }
@@ -53,17 +118,30 @@ type ConfigStr struct {
 	OnionAddress     string
 	Listen           string
 	Priviledges      map[string]UserPriviledge
-	Cert             string // Note: filenames
+	Roles            map[string][]Capability // custom roles (see roles.go)
+	Cert             string                  // Note: filenames
 	Key              string
 	Database         string // note: filename
 	Keywords         string // filename path to bleve
 	Backup           ConfigBackup
-	LimitConnections int64
+	LimitConnections int64         // default for RateLimit.Class.read
 	LimitWindow      time.Duration // in seconds
-	Log              string        // filename
+	IPv4Prefix       int           // clients are grouped by network for rate limits and bans (default 32)
+	IPv6Prefix       int           // default 64
+	RateLimit        ConfigRateLimit
+	Log              string // filename
 	Page             map[string]string
 	Admin            ConfigAdminStr
+	Reports          ConfigReports
+	Hold             ConfigHold
+	Purge            ConfigPurge
+	Filter           []ConfigFilterRule
 	Smtp             ConfigStrSmtp
+	Verification     ConfigVerification
+	Registration     ConfigRegistration
+	ProofOfWork      ConfigProofOfWork
+	TwoFactor        ConfigTwoFactor
+	Password         ConfigPassword
 	Forum            []Forum
 }
 
@@ -112,6 +190,30 @@ func LoadConfig(path string) error {
 		}
 	}
 
+	if err := ValidateRoles(Configuration.Roles); err != nil {
+		return err
+	}
+
+	if err := ValidateRegistrationMode(Configuration.Registration.Mode); err != nil {
+		return err
+	}
+
+	if err := ValidatePrefixLengths(Configuration.IPv4Prefix, Configuration.IPv6Prefix); err != nil {
+		return err
+	}
+
+	if err := ValidateRateLimits(Configuration.RateLimit.Class); err != nil {
+		return err
+	}
+
+	if err := ValidatePasswordPolicy(Configuration.Password); err != nil {
+		return err
+	}
+
+	if err := SetFilterRules(Configuration.Filter); err != nil {
+		return err
+	}
+
 	/*
 		Initialize backup recievers
 	*/
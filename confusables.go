@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+/*
+Characters which look like another character,
+mapped to the character they are confused with.
+This is the part of the Unicode confusables
+table (UTS #39) which applies to characters
+allowed in usernames (letters, numbers and
+underscore).
+*/
+var confusables = map[rune]string{
+	// ASCII
+	'0': "o", '1': "l", 'I': "l", 'm': "rn",
+
+	// Latin
+	'ı': "i", 'ɑ': "a", 'ɡ': "g", 'ɩ': "i", 'ʟ': "l", 'ǀ': "l", 'ℓ': "l", 'ꓲ': "l",
+	'ɒ': "a", 'ƅ': "b", 'ɦ': "h", 'ʋ': "u", 'ɴ': "n", 'ʀ': "r", 'ʏ': "y",
+	'ᴀ': "a", 'ᴄ': "c", 'ᴅ': "d", 'ᴇ': "e", 'ᴊ': "j", 'ᴋ': "k", 'ᴍ': "rn", 'ᴏ': "o",
+	'ᴘ': "p", 'ᴛ': "t", 'ᴜ': "u", 'ᴠ': "v", 'ᴡ': "w", 'ᴢ': "z",
+
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'к': "k", 'м': "rn", 'н': "h", 'о': "o", 'р': "p",
+	'с': "c", 'т': "t", 'у': "y", 'х': "x", 'ѕ': "s", 'і': "i", 'ј': "j", 'ԁ': "d",
+	'ԛ': "q", 'ԝ': "w", 'һ': "h", 'ӏ': "l", 'ү': "y", 'ь': "b", 'ѵ': "v",
+	'А': "a", 'В': "b", 'Е': "e", 'К': "k", 'М': "rn", 'Н': "h", 'О': "o", 'Р': "p",
+	'С': "c", 'Т': "t", 'Х': "x", 'Ѕ': "s", 'І': "l", 'Ј': "j", 'Ү': "y", 'Ԛ': "q",
+	'Ԝ': "w", 'Ӏ': "l", 'Ь': "b", 'Һ': "h", 'Ѵ': "v",
+
+	// Greek
+	'Α': "a", 'Β': "b", 'Ε': "e", 'Ζ': "z", 'Η': "h", 'Ι': "l", 'Κ': "k", 'Μ': "rn",
+	'Ν': "n", 'Ο': "o", 'Ρ': "p", 'Τ': "t", 'Υ': "y", 'Χ': "x", 'α': "a", 'ι': "i",
+	'ν': "v", 'ο': "o", 'ρ': "p", 'χ': "x", 'γ': "y",
+
+	// Armenian
+	'օ': "o", 'ս': "u", 'ց': "g", 'հ': "h", 'ո': "n", 'զ': "q", 'ա': "w", 'Տ': "s",
+	'Օ': "o", 'Ս': "u", 'Լ': "l",
+
+	// Cherokee
+	'Ꭺ': "a", 'Ᏼ': "b", 'Ꮯ': "c", 'Ꭼ': "e", 'Ꮋ': "h", 'Ꮶ': "k", 'Ꮇ': "rn", 'Ꮲ': "p",
+	'Ꮪ': "s", 'Ꭲ': "t", 'Ꮃ': "w", 'Ꮓ': "z",
+}
+
+func confusableRune(r rune) string {
+	if s, ok := confusables[r]; ok {
+		return s
+	}
+	switch {
+	case r >= 0xFF10 && r <= 0xFF19:
+		// fullwidth digits
+		return confusableRune('0' + r - 0xFF10)
+	case r >= 0xFF21 && r <= 0xFF3A:
+		// fullwidth capital letters
+		return confusableRune('A' + r - 0xFF21)
+	case r >= 0xFF41 && r <= 0xFF5A:
+		// fullwidth small letters
+		return confusableRune('a' + r - 0xFF41)
+	case r >= 0x1D400 && r <= 0x1D6A3:
+		// mathematical alphanumeric letters (bold, italic, script...)
+		if offset := (r - 0x1D400) % 52; offset < 26 {
+			return confusableRune('A' + offset)
+		} else {
+			return confusableRune('a' + offset - 26)
+		}
+	case r >= 0x1D7CE && r <= 0x1D7FF:
+		// mathematical digits
+		return confusableRune('0' + (r-0x1D7CE)%10)
+	}
+	if lower := unicode.ToLower(r); lower != r {
+		return confusableRune(lower)
+	}
+	return string(r)
+}
+
+func Skeleton(username string) string {
+	/*
+		Two usernames with the same skeleton
+		look alike. Letters are compared in lower
+		case, after confusable capitals (such as
+		"I" for "l") have been mapped. Names which
+		only differ by case are found with the
+		username index (see usernames.go).
+	*/
+	var b strings.Builder
+	for _, r := range username {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			// combining marks and invisible characters
+			continue
+		}
+		b.WriteString(confusableRune(r))
+	}
+	return b.String()
+}
@@ -1,33 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
-	"time"
 
 	"codeberg.org/FiskFan1999/gemini"
 	bolt "go.etcd.io/bbolt"
 )
 
-func LogConsoleCommand(user string, priv UserPriviledge, command string) {
-	if err := db.Update(func(tx *bolt.Tx) error {
-		logs := tx.Bucket(DBCONSOLELOG)
-		key := []byte(time.Now().Format(time.RFC3339Nano))
-		val := []byte(fmt.Sprintf("%s/%s:%s", user, priv, command))
-		return logs.Put(key, val)
-	}); err != nil {
-		fmt.Println("Error during logging console command:", err.Error())
-	}
-}
-
 var ErrNotImplementedYet = errors.New("Not implemented yet")
 var ErrUserNotFound = errors.New("User not found")
 
+const CommandUnauthorized = "You are not authorized to use this command."
+
 func DoCommand(command string) (string, gemini.Status) {
 	/*
 		ConsoleCommand wrapper
@@ -36,178 +26,93 @@ func DoCommand(command string) (string, gemini.Status) {
 	return a, b
 }
 
-func ConsoleCommand(user string, priv UserPriviledge, command string) (string, gemini.Status) {
+func ConsoleCommand(user string, priv UserPriviledge, command string) (response string, status gemini.Status) {
+	fields := strings.Fields(command)
 
 	/*
-		Log this command in the database.
+		Log this command in the database,
+		along with its result.
 	*/
-	defer LogConsoleCommand(user, priv, command)
+	target := auditTarget(fields)
+	defer func() {
+		LogConsoleCommand(user, priv, fields, target, response, status)
+	}()
 
-	fields := strings.Fields(command)
 	if len(fields) == 0 {
 		return "Please enter a command", gemini.BadRequest
 	}
 
-	switch fields[0] {
-	case "lock":
-		/*
-			lock <thread ID>
-		*/
-		if len(fields) != 2 {
-			return "lock <thread ID>", gemini.BadRequest
-		}
-
-		if err := MuteOrUnmuteThread([]byte(fields[1]), []byte("1")); err != nil {
-			return err.Error(), gemini.TemporaryFailure
-		}
-
-		return "thread has been locked.", gemini.Success
-
-	case "unlock":
-		/*
-			lock <thread ID>
-		*/
-		if len(fields) != 2 {
-			return "unlock <thread ID>", gemini.BadRequest
-		}
-
-		if err := MuteOrUnmuteThread([]byte(fields[1]), []byte("0")); err != nil {
-			return err.Error(), gemini.TemporaryFailure
-		}
-
-		return "thread has been unlocked.", gemini.Success
-
-	case "mute":
-		/*
-			The number amount is in DAYS
-		*/
-		if len(fields) < 3 {
-			return "mute <username> <\"permanent\"/#days>", gemini.BadRequest
-		}
-		/*
-			Don't allow user to write new threads or posts
-		*/
-		if err := db.Update(func(tx *bolt.Tx) error {
-			allusers := tx.Bucket(DBUSERS)
-			user := allusers.Bucket([]byte(fields[1]))
-			if user == nil {
-				// name not found
-				return ErrUserNotFound
-			}
-
-			if fields[2] == "permanent" {
-				user.Put([]byte("muted"), []byte("permanent"))
-			} else {
-				/*
-					Attempt to convert fields[2] into
-					an integer, then find the time
-					that many days from now, and
-					write that to the database in
-					RFC3339 form
-				*/
-				days, err := strconv.Atoi(fields[2])
-				if err != nil {
-					return errors.New("Invalid field: not \"permanent\" or number of days.")
-				}
-				if days <= 0 {
-					return errors.New("You may not specify a number of days <= 0.")
-				}
-				whenToUnban := time.Now().Add(time.Hour * 24 * time.Duration(days))
-				fmt.Println(whenToUnban)
-				user.Put([]byte("muted"), []byte(whenToUnban.Format(time.RFC3339)))
-			}
+	c, ok := LookupConsoleCommand(fields[0])
+	if !ok {
+		return "Unknown command. Enter \"help\" for a list of commands.", gemini.BadRequest
+	}
+	if !c.Allowed(user, priv) {
+		return CommandUnauthorized, gemini.CertificateNotAuthorised
+	}
+	r := ConsoleRequest{User: user, Priv: priv, Command: c.Name, Args: fields[1:]}
+	if err := c.CheckArguments(r.Args); err != nil {
+		return usageResponse(r)
+	}
+	return c.Handler(r)
+}
 
-			return nil
-		}); err != nil {
-			return err.Error(), gemini.BadRequest
-		}
-		return "User has been muted.", gemini.Success
+func banCommandKind(command string) string {
+	switch command {
+	case "banip":
+		return BanIP
+	case "bancert":
+		return BanCert
+	}
+	return BanUser
+}
 
-	case "unmute":
-		if len(fields) < 2 {
-			return "unmute <username>", gemini.BadRequest
-		}
-		if err := db.Update(func(tx *bolt.Tx) error {
-			allusers := tx.Bucket(DBUSERS)
-			user := allusers.Bucket([]byte(fields[1]))
-			if user == nil {
-				// name not found
-				return ErrUserNotFound
-			}
-			user.Put([]byte("muted"), []byte(""))
-			return nil
-		}); err != nil {
-			return err.Error(), gemini.BadRequest
-		}
-		return "User has been unmuted.", gemini.Success
-	case "read":
-		/*
-			Read the console command log
-		*/
-		dontShowTime := false
-		numCommands := 32
-		allCommands := false
-		if len(fields) > 1 {
-			if fields[1] == "all" {
-				allCommands = true
-			}
-			nc, err := strconv.Atoi(fields[1])
-			if err == nil {
-				numCommands = nc
-			}
-		}
-		if fields[len(fields)-1] == "notime" {
-			dontShowTime = true
-		}
-		var commands []string
-		if err := db.View(func(tx *bolt.Tx) error {
-			logs := tx.Bucket(DBCONSOLELOG)
-			c := logs.Cursor()
-			k, v := c.Last()
-			for i := 0; i < numCommands || allCommands; i++ {
-				if k == nil {
-					break
-				}
-				s := fmt.Sprintf("%s - %s", k, v)
-				if dontShowTime {
-					s = string(v)
-				}
-
-				commands = append(commands, s) // log only
-				k, v = c.Prev()
+func parseBanArguments(fields []string) (days int, reason string, err error) {
+	/*
+		[days/"permanent"] [reason]
+	*/
+	if len(fields) != 0 {
+		if fields[0] == "permanent" {
+			fields = fields[1:]
+		} else if n, convErr := strconv.Atoi(fields[0]); convErr == nil {
+			if n <= 0 {
+				return 0, "", ErrInvalidBanDays
 			}
-			return nil
-		}); err != nil {
-			return err.Error(), gemini.TemporaryFailure
-		}
-
-		plain := strings.Join(commands, "\n")
-		return plain, gemini.Success
-
-	case "help":
-		helpContents, err := os.ReadFile("consolehelp.txt")
-		if err != nil {
-			return err.Error(), gemini.TemporaryFailure
+			days = n
+			fields = fields[1:]
 		}
-		return string(helpContents), gemini.Success
-
-	case "log":
-		/*
-			Basic command to write anything into the log.
-		*/
-		return "Logged.", gemini.Success
 	}
+	reason = strings.Join(fields, " ")
+	return
+}
 
-	return "Unknown command", gemini.BadRequest
+func GetSubforumOfThread(id []byte) (subforum string) {
+	if err := db.View(func(tx *bolt.Tx) error {
+		subforum = string(tx.Bucket(DBTHREADTOSF).Get(id))
+		return nil
+	}); err != nil {
+		fmt.Println("Error during GetSubforumOfThread:", err.Error())
+	}
+	return
 }
 
-func MuteOrUnmuteThread(id []byte, status []byte) error {
+func MuteOrUnmuteThread(id []byte, status []byte, reason string) error {
+	/*
+		The reason is shown to users viewing
+		the thread, and is removed on unlock.
+	*/
 	return db.Update(func(tx *bolt.Tx) error {
 		allThreads := tx.Bucket(DBALLTHREADS)
 		thread := allThreads.Bucket(id)
 		if thread == nil {
 			return errors.New("Thread not found.")
 		}
+		if bytes.Equal(status, []byte("0")) {
+			if err := thread.Delete([]byte("lockreason")); err != nil {
+				return err
+			}
+		} else if err := thread.Put([]byte("lockreason"), []byte(reason)); err != nil {
+			return err
+		}
 		return thread.Put([]byte("locked"), status)
 	})
 }
@@ -221,17 +126,28 @@ func ConsoleHandler(u *url.URL, c *tls.Conn) gemini.Response {
 		// no certificate
 		return gemini.ClientCertificateRequired.Response("Client certificate required")
 	}
-	if !priv.Is(Mod) {
+	if !AuthorizeAnyScope(user, priv, CapConsole) {
 		/*
 			Not a moderator
 		*/
 		return gemini.CertificateNotAuthorised.Response("Unauthorized")
 	}
+	if TwoFactorRequired(user, priv) && !HasTwoFactor(user) {
+		return gemini.CertificateNotAuthorised.Error(ErrTwoFactorRequired)
+	}
 
 	/*
 		We know it is a moderator or administrator. continue.
 	*/
 
+	pathspl := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
+	if len(pathspl) == 2 && pathspl[1] == "reports" {
+		if !AuthorizeAnyScope(user, priv, CapReadReports) {
+			return gemini.CertificateNotAuthorised.Response(CommandUnauthorized)
+		}
+		return ReportsPage(user, priv)
+	}
+
 	if u.RawQuery == "" {
 		return gemini.Input.Response("Enter command")
 	}
@@ -16,6 +16,9 @@ func TestConsole(t *testing.T) {
 			"bob":     Mod,
 			"charlie": User,
 		},
+		Roles: map[string][]Capability{
+			"locker": {CapConsole, CapLock},
+		},
 		Forum: []Forum{
 			Forum{"first", []Subforum{Subforum{Name: "second", ID: "second"}}},
 		},
@@ -40,7 +43,7 @@ func TestConsole(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	serv := gemtest.Testd(t, handler, 3)
+	serv := gemtest.Testd(t, handler, 4)
 	defer serv.Stop()
 
 	serv.Check(
@@ -68,15 +71,15 @@ func TestConsole(t *testing.T) {
 
 		// permanent mute
 		gemtest.Input{URL: "gemini://localhost/console/?mute%20charlie%20permanent", Cert: 1, Response: []byte("20 text/plain\r\nUser has been muted.")},
-		gemtest.Input{URL: "gemini://localhost/", Cert: 3, Response: []byte("20 text/gemini\r\n# \r\n\r\nCurrently logged in as charlie.\r\nNote: you are currently permanently muted.\r\n=> /logout/ Log out\r\n=>  /register Register an account\r\n=>  /search/ Search\r\n\r\n## first\r\n=> /f/second/ second\r\n\r\n# Source code\r\nlarigot is open-source software. You may download the source code from the following link.\r\n=> https://github.com/ObieSource/larigot\r\n")},
+		gemtest.Input{URL: "gemini://localhost/", Cert: 3, Response: []byte("20 text/gemini\r\n# \r\n\r\nCurrently logged in as charlie.\r\nNote: you are currently permanently muted.\r\n=> /appeal/ Appeal\r\n=> /logout/ Log out\r\n=> /settings/ Settings\r\n=>  /register Register an account\r\n=>  /search/ Search\r\n\r\n## first\r\n=> /f/second/ second\r\n\r\n# Source code\r\nlarigot is open-source software. You may download the source code from the following link.\r\n=> https://github.com/ObieSource/larigot\r\n")},
 		// test creating new threads or posts while muted
 		// muted user
-		gemtest.Input{URL: "gemini://localhost/new/thread/second/another/?one", Cert: 3, Response: []byte("59 You are currently muted\r\n")},
+		gemtest.Input{URL: "gemini://localhost/new/thread/second/another/?one", Cert: 3, Response: []byte("59 You are currently permanently muted.\r\n")},
 		// unmuted user
 		gemtest.Input{URL: "gemini://localhost/new/thread/second/hello/?hi", Cert: 1, Response: []byte("20 text/gemini\r\nWelcome to larigot!\r\nThank you for making a post on our bulletin board! We would like to remind you that any content that you post can be viewed by the entire internet. Please be mindful of what content you share, and refrain from revealing any private information.\r\nThis page will only be displayed once. Please refresh the page or click on the following link to continue to writing your post.\r\n=> /new/thread/second/hello/?hi\r\n")},
 		gemtest.Input{URL: "gemini://localhost/new/thread/second/hello/?hi", Cert: 1, Response: []byte("30 /f/second/\r\n")},
 		// muted user
-		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?hello%21", Cert: 3, Response: []byte("59 You are currently muted\r\n")},
+		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?hello%21", Cert: 3, Response: []byte("59 You are currently permanently muted.\r\n")},
 		// unmuted user
 		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?hello%21", Cert: 1, Response: []byte("30 /thread/0000000000000001/\r\n")},
 
@@ -89,6 +92,43 @@ func TestConsole(t *testing.T) {
 
 		gemtest.Input{URL: "gemini://localhost/console/?read%20notime", Cert: 1, Response: []byte("20 text/plain\r\nalice/Admin:unmute charlie\nalice/Admin:mute charlie permanent\nbob/Mod:log hello world\nalice/Admin:log hello world")},
 		gemtest.Input{URL: "gemini://localhost/console/?read%201%20notime", Cert: 1, Response: []byte("20 text/plain\r\nalice/Admin:read notime")},
+
+		/*
+			Priviledges stored in the database
+		*/
+		gemtest.Input{URL: "gemini://localhost/register/dave/dave%40example.net/?password", Cert: 4, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/dave/?password", Cert: 4, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 4, Response: []byte("61 Unauthorized\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?promote%20dave%20mod", Cert: 2, Response: []byte("61 You are not authorized to use this command.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?promote%20dave%20wizard", Cert: 1, Response: []byte("59 Invalid priviledge level. Allowed values: user/mod/admin\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?promote%20dave%20mod", Cert: 1, Response: []byte("20 text/plain\r\nUser priviledge has been set to Mod.")},
+		gemtest.Input{URL: "gemini://localhost/console/?promote%20dave%20mod", Cert: 1, Response: []byte("59 User already has this priviledge level or higher\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 4, Response: []byte("10 Enter command\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?demote%20dave%20admin", Cert: 1, Response: []byte("59 User already has this priviledge level or lower\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?demote%20dave%20user", Cert: 1, Response: []byte("20 text/plain\r\nUser priviledge has been set to User.")},
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 4, Response: []byte("61 Unauthorized\r\n")},
+		// configuration file overrides the database
+		gemtest.Input{URL: "gemini://localhost/console/?promote%20charlie%20mod", Cert: 1, Response: []byte("20 text/plain\r\nUser priviledge has been set to Mod. Note: the configuration file overrides this user's priviledge (User).")},
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 3, Response: []byte("61 Unauthorized\r\n")},
+
+		/*
+			Roles
+		*/
+		gemtest.Input{URL: "gemini://localhost/console/?role%20dave%20locker", Cert: 2, Response: []byte("61 You are not authorized to use this command.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?role%20dave%20wizard", Cert: 1, Response: []byte("59 Role not found\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?role%20dave%20locker%20nowhere", Cert: 1, Response: []byte("59 Subforum not found\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?role%20dave%20locker", Cert: 1, Response: []byte("20 text/plain\r\nRole has been given.")},
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 4, Response: []byte("10 Enter command\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?lock%200000000000000001", Cert: 4, Response: []byte("20 text/plain\r\nthread has been locked.")},
+		gemtest.Input{URL: "gemini://localhost/console/?mute%20charlie%201", Cert: 4, Response: []byte("61 You are not authorized to use this command.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?unrole%20dave%20locker", Cert: 1, Response: []byte("20 text/plain\r\nRole has been removed.")},
+		gemtest.Input{URL: "gemini://localhost/console/?unrole%20dave%20locker", Cert: 1, Response: []byte("59 User does not have this role\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 4, Response: []byte("61 Unauthorized\r\n")},
+		// role for only one subforum
+		gemtest.Input{URL: "gemini://localhost/console/?role%20dave%20locker%20second", Cert: 1, Response: []byte("20 text/plain\r\nRole has been given.")},
+		gemtest.Input{URL: "gemini://localhost/console/?roles%20dave", Cert: 1, Response: []byte("20 text/plain\r\npriviledge: User\nsecond: locker")},
+		gemtest.Input{URL: "gemini://localhost/console/?unlock%200000000000000001", Cert: 4, Response: []byte("20 text/plain\r\nthread has been unlocked.")},
+		gemtest.Input{URL: "gemini://localhost/console/?roles", Cert: 1, Response: []byte("20 text/plain\r\nadmin: console lock mute ban move archive reports users register restricted\nlocker: console lock\nmod: console lock mute ban move archive reports register")},
 	)
 
 }
@@ -0,0 +1,1041 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+)
+
+/*
+Operator console commands
+
+Every command is declared in ConsoleCommands
+with its arguments, who may use it, its help
+text and its handler. ConsoleCommand checks the
+priviledge, capability and number of arguments
+before calling the handler, so handlers only
+check what depends on the value of an argument
+(such as the subforum of a thread).
+*/
+
+var ErrConsoleUsage = errors.New("Invalid arguments")
+
+type ConsoleArgument struct {
+	Name     string   // shown in the help and usage messages
+	Values   []string // if set, the only allowed values
+	Optional bool
+	Rest     bool // the last argument may be more than one word
+}
+
+func (a ConsoleArgument) String() string {
+	name := a.Name
+	if len(a.Values) != 0 {
+		quoted := make([]string, len(a.Values))
+		for i, v := range a.Values {
+			quoted[i] = strconv.Quote(v)
+		}
+		name = strings.Join(quoted, "/")
+	}
+	if a.Optional {
+		return fmt.Sprintf("[%s]", name)
+	}
+	return fmt.Sprintf("<%s>", name)
+}
+
+type ConsoleRequest struct {
+	User    string
+	Priv    UserPriviledge
+	Command string
+	Args    []string
+}
+
+type ConsoleCommandSpec struct {
+	Name         string
+	Arguments    []ConsoleArgument
+	Priviledge   UserPriviledge // minimum priviledge level, even with a role
+	Capability   Capability     // empty if any operator may use the command
+	OrCapability Capability     // may be used with this capability instead
+	Scoped       bool           // the capability may be given for a subforum, which the handler checks
+	Help         string
+	Handler      func(r ConsoleRequest) (string, gemini.Status)
+}
+
+func (c ConsoleCommandSpec) Usage() string {
+	words := []string{c.Name}
+	for _, a := range c.Arguments {
+		words = append(words, a.String())
+	}
+	return strings.Join(words, " ")
+}
+
+func (c ConsoleCommandSpec) Allowed(user string, priv UserPriviledge) bool {
+	if !priv.Is(c.Priviledge) {
+		return false
+	}
+	if c.Capability == "" {
+		return true
+	}
+	for _, cap := range []Capability{c.Capability, c.OrCapability} {
+		switch {
+		case cap == "":
+			continue
+		case c.Scoped:
+			if AuthorizeAnyScope(user, priv, cap) {
+				return true
+			}
+		case Authorize(user, priv, cap, ""):
+			return true
+		}
+	}
+	return false
+}
+
+func (c ConsoleCommandSpec) CheckArguments(args []string) error {
+	var required int
+	for _, a := range c.Arguments {
+		if !a.Optional {
+			required++
+		}
+	}
+	rest := len(c.Arguments) != 0 && c.Arguments[len(c.Arguments)-1].Rest
+	if len(args) < required || (len(args) > len(c.Arguments) && !rest) {
+		return ErrConsoleUsage
+	}
+	for i, arg := range args {
+		if i >= len(c.Arguments) || len(c.Arguments[i].Values) == 0 {
+			continue
+		}
+		allowed := false
+		for _, v := range c.Arguments[i].Values {
+			allowed = allowed || arg == v
+		}
+		if !allowed {
+			return ErrConsoleUsage
+		}
+	}
+	return nil
+}
+
+var ConsoleCommands []ConsoleCommandSpec
+
+func usageResponse(r ConsoleRequest) (string, gemini.Status) {
+	c, _ := LookupConsoleCommand(r.Command)
+	return fmt.Sprintf("Usage: %s", c.Usage()), gemini.BadRequest
+}
+
+func LookupConsoleCommand(name string) (ConsoleCommandSpec, bool) {
+	for _, c := range ConsoleCommands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return ConsoleCommandSpec{}, false
+}
+
+func ConsoleHelp(user string, priv UserPriviledge) string {
+	/*
+		Only the commands that this user
+		may use, in alphabetical order.
+	*/
+	commands := append([]ConsoleCommandSpec{}, ConsoleCommands...)
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	lines := []string{"OPERATOR CONSOLE COMMANDS"}
+	for _, c := range commands {
+		if c.Allowed(user, priv) {
+			lines = append(lines, "", c.Usage(), c.Help)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	argUsername   = ConsoleArgument{Name: "username"}
+	argThreadID   = ConsoleArgument{Name: "thread ID"}
+	argHeldPostID = ConsoleArgument{Name: "held post ID"}
+	argDays       = ConsoleArgument{Name: "number of days", Optional: true}
+	argReason     = ConsoleArgument{Name: "reason", Optional: true, Rest: true}
+	argLevel      = ConsoleArgument{Name: "user/mod/admin"}
+	argRole       = ConsoleArgument{Name: "role"}
+	argSubforumID = ConsoleArgument{Name: "subforum ID", Optional: true}
+)
+
+func init() {
+	ConsoleCommands = []ConsoleCommandSpec{
+		{
+			Name:       "lock",
+			Arguments:  []ConsoleArgument{argThreadID, argReason},
+			Capability: CapLock,
+			Scoped:     true,
+			Help:       "Lock a thread, optionally with a reason shown to its readers",
+			Handler:    lockCommand,
+		},
+		{
+			Name:       "unlock",
+			Arguments:  []ConsoleArgument{argThreadID},
+			Capability: CapLock,
+			Scoped:     true,
+			Help:       "Unlock a thread",
+			Handler:    lockCommand,
+		},
+		{
+			Name:       "move",
+			Arguments:  []ConsoleArgument{argThreadID, {Name: "subforum ID"}},
+			Capability: CapMove,
+			Scoped:     true,
+			Help:       "Move a thread to another subforum (requires the \"move\" capability in both subforums)",
+			Handler:    moveCommand,
+		},
+		{
+			Name:       "mute",
+			Arguments:  []ConsoleArgument{argUsername, {Name: `"permanent"/number of days`}, argReason},
+			Capability: CapMute,
+			Help:       "Permanently or temporarily mute a user. The reason is shown to the user",
+			Handler:    muteCommand,
+		},
+		{
+			Name:       "mutes",
+			Arguments:  []ConsoleArgument{argUsername},
+			Capability: CapMute,
+			Help:       "List every mute given to a user",
+			Handler:    mutesCommand,
+		},
+		{
+			Name:       "unmute",
+			Arguments:  []ConsoleArgument{argUsername},
+			Capability: CapMute,
+			Help:       "Immediately unmute a user",
+			Handler:    unmuteCommand,
+		},
+		{
+			Name:       "ban",
+			Arguments:  []ConsoleArgument{argUsername, argDays, argReason},
+			Capability: CapBan,
+			Help:       "Ban a user (permanently unless a number of days is given) and log out all of their certificates",
+			Handler:    banCommand,
+		},
+		{
+			Name:       "banip",
+			Arguments:  []ConsoleArgument{{Name: "IP address or CIDR range"}, argDays, argReason},
+			Capability: CapBan,
+			Help:       "Ban a range of addresses, or the network of one address (set by ipv4Prefix and ipv6Prefix in the configuration file)",
+			Handler:    banCommand,
+		},
+		{
+			Name:       "bancert",
+			Arguments:  []ConsoleArgument{{Name: "certificate fingerprint"}, argDays, argReason},
+			Capability: CapBan,
+			Help:       "Ban a client certificate",
+			Handler:    banCommand,
+		},
+		{
+			Name:       "unban",
+			Arguments:  []ConsoleArgument{argUsername},
+			Capability: CapBan,
+			Help:       "Remove the ban on a user",
+			Handler:    unbanCommand,
+		},
+		{
+			Name:       "unbanip",
+			Arguments:  []ConsoleArgument{{Name: "IP address or CIDR range"}},
+			Capability: CapBan,
+			Help:       "Remove the ban on an IP address or range of addresses",
+			Handler:    unbanCommand,
+		},
+		{
+			Name:       "unbancert",
+			Arguments:  []ConsoleArgument{{Name: "certificate fingerprint"}},
+			Capability: CapBan,
+			Help:       "Remove the ban on a client certificate",
+			Handler:    unbanCommand,
+		},
+		{
+			Name:       "purge",
+			Arguments:  []ConsoleArgument{argUsername, {Values: []string{"dryrun"}, Optional: true}},
+			Capability: CapBan,
+			Help:       "Archive every thread and post by a user, remove them from search and ban the account (dryrun: only show what would be purged). Everything is deleted once the undo window (Purge.UndoHours in the configuration file) has passed (requires the \"archive\" capability)",
+			Handler:    purgeCommand,
+		},
+		{
+			Name:       "unpurge",
+			Arguments:  []ConsoleArgument{argUsername},
+			Capability: CapBan,
+			Help:       "Restore the threads and posts of a purged user, and remove the ban given by the purge (requires the \"archive\" capability)",
+			Handler:    unpurgeCommand,
+		},
+		{
+			Name:       "purges",
+			Capability: CapBan,
+			Help:       "List the purged users whose threads and posts have not been deleted yet",
+			Handler:    purgesCommand,
+		},
+		{
+			Name:       "bans",
+			Capability: CapBan,
+			Help:       "List all bans",
+			Handler:    bansCommand,
+		},
+		{
+			Name:       "reset2fa",
+			Arguments:  []ConsoleArgument{argUsername},
+			Priviledge: Admin,
+			Capability: CapManageUsers,
+			Help:       "Turn off two-factor authentication for a user who lost their authenticator and recovery codes. Their next login only needs the password",
+			Handler:    reset2faCommand,
+		},
+		{
+			Name:       "promote",
+			Arguments:  []ConsoleArgument{argUsername, argLevel},
+			Priviledge: Admin,
+			Capability: CapManageUsers,
+			Help:       "Raise the priviledge level of a user",
+			Handler:    promoteCommand,
+		},
+		{
+			Name:       "demote",
+			Arguments:  []ConsoleArgument{argUsername, argLevel},
+			Priviledge: Admin,
+			Capability: CapManageUsers,
+			Help:       "Lower the priviledge level of a user",
+			Handler:    promoteCommand,
+		},
+		{
+			Name:       "rename",
+			Arguments:  []ConsoleArgument{{Name: "old username"}, {Name: "new username"}},
+			Capability: CapManageUsers,
+			Help:       "Change the name of a user, including all of their threads and posts",
+			Handler:    renameCommand,
+		},
+		{
+			Name:       "role",
+			Arguments:  []ConsoleArgument{argUsername, argRole, argSubforumID},
+			Priviledge: Admin,
+			Capability: CapManageUsers,
+			Help:       "Give a role to a user, for every subforum or only one subforum",
+			Handler:    roleCommand,
+		},
+		{
+			Name:       "unrole",
+			Arguments:  []ConsoleArgument{argUsername, argRole, argSubforumID},
+			Priviledge: Admin,
+			Capability: CapManageUsers,
+			Help:       "Remove a role from a user",
+			Handler:    roleCommand,
+		},
+		{
+			Name:       "roles",
+			Arguments:  []ConsoleArgument{{Name: "username", Optional: true}},
+			Priviledge: Mod,
+			Help:       "List all roles, or the roles given to a user",
+			Handler:    rolesCommand,
+		},
+		{
+			Name:       "user",
+			Arguments:  []ConsoleArgument{argUsername},
+			Priviledge: Mod,
+			Help:       "Show an account's priviledge, email address, verification, mute and ban status, number of certificates, threads and posts",
+			Handler:    userCommand,
+		},
+		{
+			Name:       "stats",
+			Priviledge: Mod,
+			Help:       "Show the number of users, threads and posts (in total and per subforum), the size of the database and search index, and recent activity",
+			Handler:    statsCommand,
+		},
+		{
+			Name:       "invite",
+			Arguments:  []ConsoleArgument{{Name: "number of uses", Optional: true}, argDays},
+			Capability: CapRegistrations,
+			Help:       "Create an invite code (default: one use, never expires)",
+			Handler:    inviteCommand,
+		},
+		{
+			Name:       "invites",
+			Capability: CapRegistrations,
+			Help:       "List all invite codes and how many times they were used",
+			Handler:    invitesCommand,
+		},
+		{
+			Name:       "revoke",
+			Arguments:  []ConsoleArgument{{Name: "invite code"}},
+			Capability: CapRegistrations,
+			Help:       "Delete an invite code",
+			Handler:    revokeCommand,
+		},
+		{
+			Name:       "pending",
+			Capability: CapRegistrations,
+			Help:       "List the accounts awaiting approval",
+			Handler:    pendingCommand,
+		},
+		{
+			Name:       "approve",
+			Arguments:  []ConsoleArgument{argUsername},
+			Capability: CapRegistrations,
+			Help:       "Approve an account which is awaiting approval",
+			Handler:    approveCommand,
+		},
+		{
+			Name:       "reject",
+			Arguments:  []ConsoleArgument{argUsername},
+			Capability: CapRegistrations,
+			Help:       "Remove an account which is awaiting approval",
+			Handler:    approveCommand,
+		},
+		{
+			Name:       "filters",
+			Arguments:  []ConsoleArgument{{Values: []string{"reload"}, Optional: true}},
+			Capability: CapReadReports,
+			Scoped:     true,
+			Help:       "List the content filter rules and how many posts each has matched, or load the rules again from the configuration file (administrators only)",
+			Handler:    filtersCommand,
+		},
+		{
+			Name:       "held",
+			Capability: CapReadReports,
+			Scoped:     true,
+			Help:       "List the posts awaiting approval, by new accounts or held by a content filter rule",
+			Handler:    heldCommand,
+		},
+		{
+			Name:       "approvepost",
+			Arguments:  []ConsoleArgument{argHeldPostID},
+			Capability: CapReadReports,
+			Scoped:     true,
+			Help:       "Approve a post which is awaiting approval, and write it into its thread (see held)",
+			Handler:    approvePostCommand,
+		},
+		{
+			Name:       "rejectpost",
+			Arguments:  []ConsoleArgument{argHeldPostID},
+			Capability: CapReadReports,
+			Scoped:     true,
+			Help:       "Remove a post which is awaiting approval",
+			Handler:    approvePostCommand,
+		},
+		{
+			Name:       "reports",
+			Arguments:  []ConsoleArgument{{Values: []string{"all"}, Optional: true}},
+			Capability: CapReadReports,
+			Scoped:     true,
+			Help:       "List the open reports (or every report), which can also be read at /console/reports/",
+			Handler:    reportsCommand,
+		},
+		{
+			Name:       "resolve",
+			Arguments:  []ConsoleArgument{{Name: "report ID"}, {Name: "dismiss/accept/restore/archive/lock/mute"}},
+			Capability: CapReadReports,
+			Scoped:     true,
+			Help:       "Close a report: dismiss allows the user to report the post again, restore shows a hidden post and archive removes it (closing every report on the post), lock locks the thread, and mute permanently mutes the author of the post",
+			Handler:    resolveCommand,
+		},
+		{
+			Name:         "appeals",
+			Arguments:    []ConsoleArgument{{Values: []string{"all"}, Optional: true}},
+			Capability:   CapMute,
+			OrCapability: CapBan,
+			Help:         "List the open appeals of mutes and bans (or every appeal). Appeals of bans are only shown to users who may ban",
+			Handler:      appealsCommand,
+		},
+		{
+			Name:         "answer",
+			Arguments:    []ConsoleArgument{{Name: "appeal ID"}, {Values: []string{"accept", "reject"}}, {Name: "response", Optional: true, Rest: true}},
+			Capability:   CapMute,
+			OrCapability: CapBan,
+			Help:         "Answer an appeal. Accepting it lifts the mute or ban. The user is shown the response, and emailed if email is enabled",
+			Handler:      answerCommand,
+		},
+		{
+			Name: "read",
+			Arguments: []ConsoleArgument{
+				{Name: `number of commands/"all"`, Optional: true},
+				{Name: `"notime"`, Optional: true},
+				{Name: `"json"`, Optional: true},
+				{Name: "actor=<username>", Optional: true},
+				{Name: "command=<command>", Optional: true},
+				{Name: "target=<username/thread ID/post ID>", Optional: true},
+				{Name: "since=<date>", Optional: true},
+				{Name: "until=<date>", Optional: true},
+			},
+			Priviledge: Admin,
+			Help:       "Read the previously used operator console commands, newest first, with their targets and any errors. The arguments may be given in any order. Dates are YYYY-MM-DD or RFC3339, and json writes one JSON object per line for review outside of the forum",
+			Handler:    readCommand,
+		},
+		{
+			Name:    "help",
+			Help:    "Display this help message",
+			Handler: helpCommand,
+		},
+		{
+			Name:       "log",
+			Arguments:  []ConsoleArgument{{Name: "message", Rest: true}},
+			Capability: CapConsole,
+			Scoped:     true,
+			Help:       "Write a message into the command log",
+			Handler:    logCommand,
+		},
+	}
+}
+
+func lockCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		lock <thread ID> [reason]
+		unlock <thread ID>
+	*/
+	if !Authorize(r.User, r.Priv, CapLock, GetSubforumOfThread([]byte(r.Args[0]))) {
+		return CommandUnauthorized, gemini.CertificateNotAuthorised
+	}
+	if r.Command == "unlock" {
+		if err := MuteOrUnmuteThread([]byte(r.Args[0]), []byte("0"), ""); err != nil {
+			return err.Error(), gemini.TemporaryFailure
+		}
+		return "thread has been unlocked.", gemini.Success
+	}
+
+	if err := MuteOrUnmuteThread([]byte(r.Args[0]), []byte("1"), strings.Join(r.Args[1:], " ")); err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+
+	return "thread has been locked.", gemini.Success
+}
+
+func moveCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		move <thread ID> <subforum ID>
+	*/
+	if !Authorize(r.User, r.Priv, CapMove, GetSubforumOfThread([]byte(r.Args[0]))) || !Authorize(r.User, r.Priv, CapMove, r.Args[1]) {
+		return CommandUnauthorized, gemini.CertificateNotAuthorised
+	}
+	if err := MoveThread(r.Args[0], r.Args[1]); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "Thread has been moved.", gemini.Success
+}
+
+func muteCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		mute <username> <"permanent"/days> [reason]
+
+		Don't allow user to write new threads or posts
+	*/
+	var expires time.Time
+	if r.Args[1] != "permanent" {
+		/*
+			Attempt to convert the argument
+			into an integer, then find the time
+			that many days from now.
+		*/
+		days, err := strconv.Atoi(r.Args[1])
+		if err != nil {
+			return "Invalid field: not \"permanent\" or number of days.", gemini.BadRequest
+		}
+		if days <= 0 {
+			return "You may not specify a number of days <= 0.", gemini.BadRequest
+		}
+		expires = time.Now().Add(time.Hour * 24 * time.Duration(days))
+	}
+	if err := MuteUser(r.Args[0], r.User, expires, strings.Join(r.Args[2:], " ")); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "User has been muted.", gemini.Success
+}
+
+func unmuteCommand(r ConsoleRequest) (string, gemini.Status) {
+	if err := UnmuteUser(r.Args[0], r.User); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "User has been unmuted.", gemini.Success
+}
+
+func mutesCommand(r ConsoleRequest) (string, gemini.Status) {
+	mutes, err := GetMuteHistory(r.Args[0])
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	if len(mutes) == 0 {
+		return "This user has never been muted.", gemini.Success
+	}
+	var lines []string
+	for _, m := range mutes {
+		lines = append(lines, m.String())
+	}
+	return strings.Join(lines, "\n"), gemini.Success
+}
+
+func banCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		ban <username> [days] [reason]
+		banip <IP address or CIDR> [days] [reason]
+		bancert <certificate fingerprint> [days] [reason]
+	*/
+	kind := banCommandKind(r.Command)
+	days, reason, err := parseBanArguments(r.Args[1:])
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	switch kind {
+	case BanUser:
+		if !MayBan(r.Priv, r.Args[0]) {
+			return ErrBanNotLower.Error(), gemini.BadRequest
+		}
+	case BanIP:
+		if network, err := NormalizeCIDR(r.Args[0]); err == nil && IsWideCIDR(network) && r.Priv != Admin {
+			return ErrBanRangeTooWide.Error(), gemini.BadRequest
+		}
+	case BanCert:
+		// the certificate may be logged in to an account
+		if owner, _, _, _ := GetUsernameFromFP([]byte(r.Args[0])); owner != "" && !MayBan(r.Priv, owner) {
+			return ErrBanNotLower.Error(), gemini.BadRequest
+		}
+	}
+	if err := AddBan(kind, r.Args[0], r.User, days, reason); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "Ban has been added.", gemini.Success
+}
+
+func unbanCommand(r ConsoleRequest) (string, gemini.Status) {
+	kind := banCommandKind(strings.TrimPrefix(r.Command, "un"))
+	if err := RemoveBan(kind, r.Args[0]); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "Ban has been removed.", gemini.Success
+}
+
+func bansCommand(r ConsoleRequest) (string, gemini.Status) {
+	bans, err := ListBans()
+	if err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+	var lines []string
+	for _, b := range bans {
+		lines = append(lines, b.String())
+	}
+	return strings.Join(lines, "\n"), gemini.Success
+}
+
+func purgeCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		purge <username> ["dryrun"]
+	*/
+	if !Authorize(r.User, r.Priv, CapArchive, "") {
+		return CommandUnauthorized, gemini.CertificateNotAuthorised
+	}
+	if !MayBan(r.Priv, r.Args[0]) {
+		return ErrBanNotLower.Error(), gemini.BadRequest
+	}
+	summary, err := PurgeUser(r.Args[0], r.User, len(r.Args) == 2)
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return summary.String(), gemini.Success
+}
+
+func unpurgeCommand(r ConsoleRequest) (string, gemini.Status) {
+	// the same capabilities as purge
+	if !Authorize(r.User, r.Priv, CapArchive, "") {
+		return CommandUnauthorized, gemini.CertificateNotAuthorised
+	}
+	if err := UnpurgeUser(r.Args[0]); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "The user's threads and posts have been restored.", gemini.Success
+}
+
+func purgesCommand(r ConsoleRequest) (string, gemini.Status) {
+	purges, err := ListPurges()
+	if err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+	if len(purges) == 0 {
+		return "There are no purges that can be undone.", gemini.Success
+	}
+	var lines []string
+	for _, p := range purges {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n"), gemini.Success
+}
+
+func reset2faCommand(r ConsoleRequest) (string, gemini.Status) {
+	if err := ResetTwoFactor(r.Args[0]); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "Two-factor authentication has been reset.", gemini.Success
+}
+
+func promoteCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		promote <username> <level>
+		demote <username> <level>
+	*/
+	level, err := ParseUserPriviledge(r.Args[1])
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	if err := ChangeUserPriviledge(r.Args[0], level, r.Command == "promote"); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	response := fmt.Sprintf("User priviledge has been set to %s.", level)
+	if configPriv, ok := Configuration.Priviledges[r.Args[0]]; ok {
+		response += fmt.Sprintf(" Note: the configuration file overrides this user's priviledge (%s).", configPriv)
+	}
+	return response, gemini.Success
+}
+
+func renameCommand(r ConsoleRequest) (string, gemini.Status) {
+	if err := RenameUser(r.Args[0], r.Args[1]); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "User has been renamed.", gemini.Success
+}
+
+func roleCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		role <username> <role> [subforum ID]
+		unrole <username> <role> [subforum ID]
+	*/
+	scope := GlobalScope
+	if len(r.Args) == 3 {
+		scope = r.Args[2]
+	}
+	if r.Command == "role" {
+		if err := AssignRole(r.Args[0], r.Args[1], scope); err != nil {
+			return err.Error(), gemini.BadRequest
+		}
+		return "Role has been given.", gemini.Success
+	}
+	if err := RemoveRole(r.Args[0], r.Args[1], scope); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "Role has been removed.", gemini.Success
+}
+
+func rolesCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		roles: list all roles
+		roles <username>: list roles given to this user
+	*/
+	if len(r.Args) == 0 {
+		return DescribeRoles(), gemini.Success
+	}
+	described, err := DescribeUserRoles(r.Args[0])
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return described, gemini.Success
+}
+
+func userCommand(r ConsoleRequest) (string, gemini.Status) {
+	info, err := GetUserInfo(r.Args[0])
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return info.String(), gemini.Success
+}
+
+func statsCommand(r ConsoleRequest) (string, gemini.Status) {
+	stats, err := GetForumStats()
+	if err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+	return stats.String(), gemini.Success
+}
+
+func inviteCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		invite [number of uses] [number of days]
+	*/
+	uses, days := 1, 0
+	var err error
+	if len(r.Args) > 0 {
+		if uses, err = strconv.Atoi(r.Args[0]); err != nil {
+			return ErrInvalidInviteCommand.Error(), gemini.BadRequest
+		}
+	}
+	if len(r.Args) > 1 {
+		if days, err = strconv.Atoi(r.Args[1]); err != nil || days == 0 {
+			return ErrInvalidInviteCommand.Error(), gemini.BadRequest
+		}
+	}
+	code, err := CreateInvite(r.User, uses, days)
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return fmt.Sprintf("Invite code: %s", code), gemini.Success
+}
+
+func invitesCommand(r ConsoleRequest) (string, gemini.Status) {
+	invites, err := ListInvites()
+	if err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+	var lines []string
+	for _, i := range invites {
+		lines = append(lines, i.String())
+	}
+	return strings.Join(lines, "\n"), gemini.Success
+}
+
+func revokeCommand(r ConsoleRequest) (string, gemini.Status) {
+	if err := RevokeInvite(r.Args[0]); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "Invite code has been revoked.", gemini.Success
+}
+
+func pendingCommand(r ConsoleRequest) (string, gemini.Status) {
+	pending, err := ListPendingUsers()
+	if err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+	var lines []string
+	for _, p := range pending {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n"), gemini.Success
+}
+
+func approveCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		approve <username>
+		reject <username>
+	*/
+	if r.Command == "approve" {
+		if err := ApprovePendingUser(r.Args[0]); err != nil {
+			return err.Error(), gemini.BadRequest
+		}
+		return "User has been approved.", gemini.Success
+	}
+	if err := RejectPendingUser(r.Args[0]); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "User has been rejected and removed.", gemini.Success
+}
+
+func filtersCommand(r ConsoleRequest) (string, gemini.Status) {
+	if len(r.Args) == 1 {
+		// the rules apply to every subforum
+		if !r.Priv.Is(Admin) {
+			return CommandUnauthorized, gemini.CertificateNotAuthorised
+		}
+		n, err := ReloadFilterRules()
+		if err != nil {
+			return err.Error(), gemini.BadRequest
+		}
+		return fmt.Sprintf("%d filter rules have been loaded.", n), gemini.Success
+	}
+	stats := FilterRuleStats()
+	if len(stats) == 0 {
+		return "There are no filter rules.", gemini.Success
+	}
+	return strings.Join(stats, "\n"), gemini.Success
+}
+
+func heldCommand(r ConsoleRequest) (string, gemini.Status) {
+	held, err := ListHeldPosts(func(h HeldPost) bool {
+		return Authorize(r.User, r.Priv, CapReadReports, h.SubforumID())
+	})
+	if err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+	if len(held) == 0 {
+		return "There are no posts awaiting approval.", gemini.Success
+	}
+	var lines []string
+	for _, h := range held {
+		lines = append(lines, h.String())
+	}
+	return strings.Join(lines, "\n"), gemini.Success
+}
+
+func approvePostCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		approvepost <held post ID>
+		rejectpost <held post ID>
+	*/
+	h, err := GetHeldPost(r.Args[0])
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	if !Authorize(r.User, r.Priv, CapReadReports, h.SubforumID()) {
+		return CommandUnauthorized, gemini.CertificateNotAuthorised
+	}
+	if r.Command == "approvepost" {
+		if _, err := ApproveHeldPost(r.Args[0]); err != nil {
+			return err.Error(), gemini.BadRequest
+		}
+		return "Post has been approved.", gemini.Success
+	}
+	if err := RejectHeldPost(r.Args[0]); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "Post has been rejected and removed.", gemini.Success
+}
+
+func reportsCommand(r ConsoleRequest) (string, gemini.Status) {
+	reports, err := ListReports(len(r.Args) == 1, func(report Report) bool {
+		return Authorize(r.User, r.Priv, CapReadReports, GetSubforumOfThread([]byte(report.Thread)))
+	})
+	if err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+	if len(reports) == 0 {
+		return "There are no open reports.", gemini.Success
+	}
+	var lines []string
+	for _, report := range reports {
+		lines = append(lines, report.String())
+	}
+	return strings.Join(lines, "\n"), gemini.Success
+}
+
+func resolveCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		resolve <report ID> <dismiss/accept/restore/archive/lock/mute>
+	*/
+	cap, err := ReportActionCapability(r.Args[1])
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	report, err := GetReport(r.Args[0])
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	subforum := GetSubforumOfThread([]byte(report.Thread))
+	if !Authorize(r.User, r.Priv, CapReadReports, subforum) || !Authorize(r.User, r.Priv, cap, ReportActionScope(r.Args[1], subforum)) {
+		return CommandUnauthorized, gemini.CertificateNotAuthorised
+	}
+	if err := ResolveReport(r.Args[0], r.Args[1], r.User); err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	return "Report has been resolved.", gemini.Success
+}
+
+func appealsCommand(r ConsoleRequest) (string, gemini.Status) {
+	appeals, err := ListAppeals(len(r.Args) == 1, func(a Appeal) bool {
+		return Authorize(r.User, r.Priv, AppealCapability(a.Kind), "")
+	})
+	if err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+	if len(appeals) == 0 {
+		return "There are no open appeals.", gemini.Success
+	}
+	var lines []string
+	for _, a := range appeals {
+		lines = append(lines, a.String())
+	}
+	return strings.Join(lines, "\n"), gemini.Success
+}
+
+func answerCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		answer <appeal ID> <accept/reject> [response]
+	*/
+	appeal, err := GetAppeal(r.Args[0])
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	if !Authorize(r.User, r.Priv, AppealCapability(appeal.Kind), "") {
+		return CommandUnauthorized, gemini.CertificateNotAuthorised
+	}
+	appeal, lifted, err := AnswerAppeal(r.Args[0], r.Args[1] == "accept", r.User, strings.Join(r.Args[2:], " "))
+	if err != nil {
+		return err.Error(), gemini.BadRequest
+	}
+	go SendAppealOutcome(appeal)
+	switch {
+	case lifted:
+		return fmt.Sprintf("Appeal has been accepted, and the %s has been lifted.", appeal.Kind), gemini.Success
+	case appeal.Status == AppealAccepted:
+		return fmt.Sprintf("Appeal has been accepted. The %s had already ended.", appeal.Kind), gemini.Success
+	}
+	return "Appeal has been rejected.", gemini.Success
+}
+
+func readCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		Read the console command log
+	*/
+	dontShowTime := false
+	asJSON := false
+	numCommands := 32
+	var filter AuditFilter
+	for _, field := range r.Args {
+		key, value, isFilter := strings.Cut(field, "=")
+		var err error
+		switch {
+		case field == "all":
+			numCommands = 0
+		case field == "notime":
+			dontShowTime = true
+		case field == "json":
+			asJSON = true
+		case !isFilter:
+			if numCommands, err = strconv.Atoi(field); err != nil || numCommands <= 0 {
+				return usageResponse(r)
+			}
+		case key == "actor":
+			filter.Actor = value
+		case key == "command":
+			filter.Command = value
+		case key == "target":
+			filter.Target = value
+		case key == "since":
+			filter.Since, err = ParseAuditDate(value, false)
+		case key == "until":
+			filter.Until, err = ParseAuditDate(value, true)
+		default:
+			return usageResponse(r)
+		}
+		if err != nil {
+			return err.Error(), gemini.BadRequest
+		}
+	}
+	entries, err := ReadAuditLog(filter, numCommands)
+	if err != nil {
+		return err.Error(), gemini.TemporaryFailure
+	}
+	var commands []string
+	for _, a := range entries {
+		var s string
+		if asJSON {
+			/*
+				JSON lines, for review
+				outside of the forum
+			*/
+			line, err := json.Marshal(a)
+			if err != nil {
+				return err.Error(), gemini.TemporaryFailure
+			}
+			s = string(line)
+		} else if dontShowTime {
+			s = a.String()
+		} else {
+			s = fmt.Sprintf("%s - %s", a.Time.Format(time.RFC3339Nano), a)
+		}
+		commands = append(commands, s)
+	}
+
+	plain := strings.Join(commands, "\n")
+	return plain, gemini.Success
+}
+
+func helpCommand(r ConsoleRequest) (string, gemini.Status) {
+	return ConsoleHelp(r.User, r.Priv), gemini.Success
+}
+
+func logCommand(r ConsoleRequest) (string, gemini.Status) {
+	/*
+		Basic command to write anything into the log.
+	*/
+	return "Logged.", gemini.Success
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestConsoleCommandRegistry(t *testing.T) {
+	names := map[string]bool{}
+	for _, c := range ConsoleCommands {
+		if names[c.Name] {
+			t.Errorf("%s: declared twice", c.Name)
+		}
+		names[c.Name] = true
+		if c.Help == "" || c.Handler == nil {
+			t.Errorf("%s: missing help text or handler", c.Name)
+		}
+		for _, cap := range []Capability{c.Capability, c.OrCapability} {
+			if cap != "" && !IsCapability(cap) {
+				t.Errorf("%s: unknown capability %q", c.Name, cap)
+			}
+		}
+		if c.OrCapability != "" && c.Capability == "" {
+			t.Errorf("%s: OrCapability without Capability", c.Name)
+		}
+		for i, a := range c.Arguments {
+			if a.Rest && i != len(c.Arguments)-1 {
+				t.Errorf("%s: only the last argument may take the rest of the command", c.Name)
+			}
+			if i > 0 && c.Arguments[i-1].Optional && !a.Optional {
+				t.Errorf("%s: required argument after an optional argument", c.Name)
+			}
+		}
+	}
+}
+
+func TestConsoleCommandAccess(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+			"bob":   Mod,
+		},
+		Roles: map[string][]Capability{
+			"locker": {CapConsole, CapLock},
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestConsoleCommandAccess.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket(DBUSERS).CreateBucket([]byte("carol"))
+		return err
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := AssignRole("carol", "locker", "firstsub"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, c := range []struct {
+		User     string
+		Priv     UserPriviledge
+		Command  string
+		Status   gemini.Status
+		Response string
+	}{
+		{"alice", Admin, "fly away", gemini.BadRequest, "Unknown command. Enter \"help\" for a list of commands."},
+		{"alice", Admin, "lock", gemini.BadRequest, "Usage: lock <thread ID> [reason]"},
+		{"alice", Admin, "unlock 1 2", gemini.BadRequest, "Usage: unlock <thread ID>"},
+		{"alice", Admin, "reports some", gemini.BadRequest, "Usage: reports [\"all\"]"},
+		{"alice", Admin, "ban", gemini.BadRequest, "Usage: ban <username> [number of days] [reason]"},
+		{"alice", Admin, "log", gemini.BadRequest, "Usage: log <message>"},
+		{"alice", Admin, "log many words here", gemini.Success, "Logged."},
+
+		/*
+			Administrators only, even though
+			mods have a console
+		*/
+		{"bob", Mod, "read", gemini.CertificateNotAuthorised, CommandUnauthorized},
+		{"bob", Mod, "role carol locker", gemini.CertificateNotAuthorised, CommandUnauthorized},
+		{"bob", Mod, "promote", gemini.CertificateNotAuthorised, CommandUnauthorized},
+		{"bob", Mod, "reports", gemini.Success, "There are no open reports."},
+		{"bob", Mod, "filters reload", gemini.CertificateNotAuthorised, CommandUnauthorized},
+
+		/*
+			Capabilities given by a role
+		*/
+		{"carol", User, "ban bob", gemini.CertificateNotAuthorised, CommandUnauthorized},
+		{"carol", User, "lock", gemini.BadRequest, "Usage: lock <thread ID> [reason]"},
+		{"carol", User, "stats", gemini.CertificateNotAuthorised, CommandUnauthorized},
+		{"carol", User, "roles", gemini.CertificateNotAuthorised, CommandUnauthorized},
+		{"carol", User, "log hello", gemini.Success, "Logged."},
+	} {
+		response, status := ConsoleCommand(c.User, c.Priv, c.Command)
+		if status != c.Status || response != c.Response {
+			t.Errorf("%s %q: expected %d %q, recieved %d %q", c.User, c.Command, c.Status, c.Response, status, response)
+		}
+	}
+
+	/*
+		Help lists only the commands
+		that the user may use
+	*/
+	if help := ConsoleHelp("carol", User); help != "OPERATOR CONSOLE COMMANDS\n\nhelp\nDisplay this help message\n\nlock <thread ID> [reason]\nLock a thread, optionally with a reason shown to its readers\n\nlog <message>\nWrite a message into the command log\n\nunlock <thread ID>\nUnlock a thread" {
+		t.Errorf("Recieved %q", help)
+	}
+	help := ConsoleHelp("bob", Mod)
+	if !strings.Contains(help, "\nban <username> [number of days] [reason]\n") || !strings.Contains(help, "\nstats\n") || strings.Contains(help, "\nread ") || strings.Contains(help, "\npromote ") {
+		t.Errorf("Recieved %q", help)
+	}
+	help = ConsoleHelp("alice", Admin)
+	if !strings.Contains(help, "\nread [number of commands/\"all\"] [\"notime\"]") || !strings.Contains(help, "\npromote <username> <user/mod/admin>\n") {
+		t.Errorf("Recieved %q", help)
+	}
+}
@@ -20,13 +20,28 @@ var (
 	DBTHREADTOSF  = []byte("threadtosubforum") // For looking thread id -> subforum id
 	DBUSERTHREADS = []byte("userthreads")      // for search
 	DBALLPOSTS    = []byte("posts")
-	DBUSERPOSTS   = []byte("userposts") // for search
-	DBCONSOLELOG  = []byte("console")   // log console commands
+	DBUSERPOSTS   = []byte("userposts")  // for search
+	DBCONSOLELOG  = []byte("console")    // log console commands
+	DBROLES       = []byte("roles")      // username -> scope (subforum or "*") -> role names
+	DBINVITES     = []byte("invites")    // invite code -> sub-bucket (see registration.go)
+	DBPENDING     = []byte("pending")    // key=username val=time of registration, awaiting approval
+	DBSETTINGS    = []byte("settings")   // username -> setting name -> value (see settings.go)
+	DBIGNORES     = []byte("ignores")    // username -> ignored username -> "1"
+	DBBANS        = []byte("bans")       // user/ip/cert -> target -> ban (see bans.go)
+	DBUSERNAMES   = []byte("usernames")  // key=lowercase username val=username
+	DBSKELETONS   = []byte("skeletons")  // key=Skeleton(username) val=username
+	DBRENAMES     = []byte("renames")    // key=previous username val=current username
+	DBRATELIMITS  = []byte("ratelimits") // rate limit counters (see ratelimit.go)
+	DBREPORTS     = []byte("reports")    // report ID -> sub-bucket (see report.go)
+	DBHELD        = []byte("held")       // held post ID -> sub-bucket (see held.go)
+	DBPURGES      = []byte("purges")     // username -> sub-bucket, until the undo window passes (see purge.go)
+	DBMUTES       = []byte("mutes")      // username -> history of mutes (see mutes.go)
+	DBAPPEALS     = []byte("appeals")    // appeal ID -> sub-bucket (see appeals.go)
 )
 
 func dbCreateBuckets() error {
 	return db.Update(func(tx *bolt.Tx) error {
-		for _, b := range [][]byte{DBUSERS, DBVALIDATION, DBFP, DBSUBFORUMS, DBALLTHREADS, DBUSERTHREADS, DBALLPOSTS, DBUSERPOSTS, DBTHREADTOSF, DBCONSOLELOG} {
+		for _, b := range [][]byte{DBUSERS, DBVALIDATION, DBFP, DBSUBFORUMS, DBALLTHREADS, DBUSERTHREADS, DBALLPOSTS, DBUSERPOSTS, DBTHREADTOSF, DBCONSOLELOG, DBROLES, DBINVITES, DBPENDING, DBSETTINGS, DBIGNORES, DBBANS, DBUSERNAMES, DBSKELETONS, DBRENAMES, DBRATELIMITS, DBREPORTS, DBHELD, DBPURGES, DBMUTES, DBAPPEALS} {
 			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
 				return err
 			}
@@ -59,4 +74,9 @@ func initDatabase() {
 		os.Exit(3)
 	}
 
+	if err := buildUsernameIndex(); err != nil {
+		log.Println(err.Error())
+		os.Exit(3)
+	}
+
 }
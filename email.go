@@ -10,14 +10,11 @@ import (
 	"strings"
 	"text/template"
 
-	"github.com/coinpaprika/ratelimiter"
 	"github.com/jordan-wright/email"
 )
 
 var emailAuth smtp.Auth
 
-var emailReportLimiter *ratelimiter.RateLimiter
-
 func InitEmailAuth() {
 	emailAuth = smtp.PlainAuth("", Configuration.Smtp.User, Configuration.Smtp.Pass, Configuration.Smtp.Address)
 }
@@ -32,26 +29,11 @@ type ReportReason struct {
 	Hostname   string
 }
 
-var reportRateLimited = errors.New("Rate limited")
-
 func SendEmailOnReport(post Post, username string, reason string, c *tls.Conn) error {
 	if !Configuration.Smtp.Enabled {
 		return errors.New("SMTP is not enabled on this instance.")
 	}
 
-	if emailReportLimiter != nil {
-		stat, err := emailReportLimiter.Check(username)
-		if err != nil {
-			return err
-		}
-		if stat.IsLimited {
-			return reportRateLimited
-		}
-		if err := emailReportLimiter.Inc(username); err != nil {
-			return err
-		}
-	}
-
 	var report ReportReason
 	report.ID = string(post.ID)
 	report.Post = post
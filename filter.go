@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Content filter
+
+Rules from the [[Filter]] sections of the
+configuration file are checked against the text
+of new posts and the titles of new threads. When
+a rule matches, the post is rejected with the
+rule's message, held until a moderator approves
+it (see held.go), or flagged (reported in the
+moderation queue but still shown). If more than one rule matches,
+the strongest action is used.
+
+The rules can be reloaded from the configuration
+file with the "filters reload" console command.
+Hit counts are kept in memory, and are kept
+across reloads for rules with the same name.
+*/
+
+const (
+	FilterRegex  = "regex"  // Pattern is a regular expression
+	FilterWords  = "words"  // any of Words, as a whole word (case insensitive)
+	FilterLinks  = "links"  // more than Max links
+	FilterCaps   = "caps"   // more than Ratio of the letters are capitals
+	FilterRepeat = "repeat" // a character repeated more than Max times in a row
+)
+
+const (
+	FilterFlag   = "flag"
+	FilterHold   = "hold"
+	FilterReject = "reject"
+)
+
+const (
+	FilterText  = "text"
+	FilterTitle = "title"
+)
+
+const (
+	FilterReporter       = "*filter*" // reporter of flagged posts
+	FilterDefaultMessage = "This post was rejected by a content filter."
+	FilterDefaultRatio   = 0.7
+	FilterDefaultLength  = 10 // letters, for caps rules
+	FilterDefaultRepeat  = 10
+)
+
+var (
+	ErrFilterName   = errors.New("Filter rules must have a unique name")
+	ErrFilterType   = errors.New("Invalid filter rule type (must be regex, words, links, caps or repeat)")
+	ErrFilterAction = errors.New("Invalid filter rule action (must be reject, hold or flag)")
+	ErrFilterTarget = errors.New("Invalid filter rule target (must be text, title or empty for both)")
+	ErrFilterWords  = errors.New("Filter rules of type words need a list of words")
+)
+
+var (
+	filterMutex sync.RWMutex
+	filterRules []filterRule
+	filterHits  = map[string]int64{}
+
+	filterLinkRegex = regexp.MustCompile(`(?i)\b[a-z][a-z0-9+.-]*://`)
+)
+
+type filterRule struct {
+	ConfigFilterRule
+	re *regexp.Regexp
+}
+
+/*
+FilterMatch is returned by ValidateThreadTitle
+when a rule matches, so that OnNewThread can hold
+or flag the thread.
+*/
+type FilterMatch struct {
+	Rule    string
+	Action  string
+	Message string
+}
+
+func (m *FilterMatch) Error() string {
+	return m.Message
+}
+
+func filterActionStrength(action string) int {
+	switch action {
+	case FilterFlag:
+		return 1
+	case FilterHold:
+		return 2
+	case FilterReject:
+		return 3
+	}
+	return 0
+}
+
+func strongerFilterMatch(a, b *FilterMatch) *FilterMatch {
+	if a == nil || (b != nil && filterActionStrength(b.Action) > filterActionStrength(a.Action)) {
+		return b
+	}
+	return a
+}
+
+func compileFilterRules(rules []ConfigFilterRule) ([]filterRule, error) {
+	var compiled []filterRule
+	names := map[string]bool{}
+	for _, r := range rules {
+		if r.Name == "" || names[r.Name] {
+			return nil, fmt.Errorf("%w: %q", ErrFilterName, r.Name)
+		}
+		names[r.Name] = true
+		if filterActionStrength(r.Action) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrFilterAction, r.Name)
+		}
+		if r.Target != "" && r.Target != FilterText && r.Target != FilterTitle {
+			return nil, fmt.Errorf("%w: %s", ErrFilterTarget, r.Name)
+		}
+		c := filterRule{ConfigFilterRule: r}
+		switch r.Type {
+		case FilterRegex:
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", r.Name, err)
+			}
+			c.re = re
+		case FilterWords:
+			if len(r.Words) == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrFilterWords, r.Name)
+			}
+			var quoted []string
+			for _, w := range r.Words {
+				quoted = append(quoted, regexp.QuoteMeta(w))
+			}
+			c.re = regexp.MustCompile(fmt.Sprintf(`(?i)\b(%s)\b`, strings.Join(quoted, "|")))
+		case FilterLinks:
+		case FilterRepeat:
+			if c.Max <= 0 {
+				c.Max = FilterDefaultRepeat
+			}
+		case FilterCaps:
+			if c.Ratio <= 0 {
+				c.Ratio = FilterDefaultRatio
+			}
+			if c.MinLength <= 0 {
+				c.MinLength = FilterDefaultLength
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrFilterType, r.Name)
+		}
+		if c.Message == "" {
+			c.Message = FilterDefaultMessage
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func SetFilterRules(rules []ConfigFilterRule) error {
+	compiled, err := compileFilterRules(rules)
+	if err != nil {
+		return err
+	}
+	filterMutex.Lock()
+	defer filterMutex.Unlock()
+	filterRules = compiled
+	hits := map[string]int64{}
+	for _, r := range compiled {
+		hits[r.Name] = filterHits[r.Name]
+	}
+	filterHits = hits
+	return nil
+}
+
+func ReloadFilterRules() (int, error) {
+	/*
+		Only the [[Filter]] sections are read
+		again. Other changes to the configuration
+		file need a restart.
+	*/
+	f, err := os.ReadFile(ConfigurationPath)
+	if err != nil {
+		return 0, err
+	}
+	var c ConfigStr
+	if err := toml.Unmarshal(f, &c); err != nil {
+		return 0, err
+	}
+	if err := SetFilterRules(c.Filter); err != nil {
+		return 0, err
+	}
+	return len(c.Filter), nil
+}
+
+func (r filterRule) matches(s string) bool {
+	switch r.Type {
+	case FilterRegex, FilterWords:
+		return r.re != nil && r.re.MatchString(s)
+	case FilterLinks:
+		return len(filterLinkRegex.FindAllStringIndex(s, -1)) > r.Max
+	case FilterCaps:
+		var letters, upper int
+		for _, char := range s {
+			if unicode.IsLetter(char) {
+				letters++
+				if unicode.IsUpper(char) {
+					upper++
+				}
+			}
+		}
+		return letters >= r.MinLength && float64(upper) > r.Ratio*float64(letters)
+	case FilterRepeat:
+		var last rune
+		var count int
+		for _, char := range s {
+			if char == last {
+				count++
+			} else {
+				last, count = char, 1
+			}
+			if count > r.Max && !unicode.IsSpace(char) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func CheckFilter(target, s string) (match *FilterMatch) {
+	/*
+		Returns nil if no rule matches. Every
+		matching rule counts a hit.
+	*/
+	filterMutex.Lock()
+	defer filterMutex.Unlock()
+	for _, r := range filterRules {
+		if r.Target != "" && r.Target != target {
+			continue
+		}
+		if !r.matches(s) {
+			continue
+		}
+		filterHits[r.Name]++
+		match = strongerFilterMatch(match, &FilterMatch{Rule: r.Name, Action: r.Action, Message: r.Message})
+	}
+	return
+}
+
+func FilterRuleStats() []string {
+	filterMutex.RLock()
+	defer filterMutex.RUnlock()
+	var lines []string
+	for _, r := range filterRules {
+		target := r.Target
+		if target == "" {
+			target = "text and title"
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s, %s, %s): %d hits", r.Name, r.Type, r.Action, target, filterHits[r.Name]))
+	}
+	return lines
+}
+
+func flagPostTx(tx *bolt.Tx, postID []byte, match *FilterMatch) error {
+	/*
+		Add a report on a new post to the
+		moderation queue.
+	*/
+	if match == nil || match.Action != FilterFlag {
+		return nil
+	}
+	post := tx.Bucket(DBALLPOSTS).Bucket(postID)
+	if post == nil {
+		return errors.New("post == nil")
+	}
+	return addReportTx(tx, post, string(postID), FilterReporter, fmt.Sprintf("Filter rule %q", match.Rule))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestFilterRules(t *testing.T) {
+	defer SetFilterRules(nil)
+	for _, c := range []struct {
+		Rules []ConfigFilterRule
+		Err   error
+	}{
+		{[]ConfigFilterRule{{Name: "a", Type: FilterLinks, Action: FilterHold}, {Name: "a", Type: FilterCaps, Action: FilterFlag}}, ErrFilterName},
+		{[]ConfigFilterRule{{Name: "a", Type: "length", Action: FilterHold}}, ErrFilterType},
+		{[]ConfigFilterRule{{Name: "a", Type: FilterLinks, Action: "delete"}}, ErrFilterAction},
+		{[]ConfigFilterRule{{Name: "a", Type: FilterLinks, Action: FilterHold, Target: "username"}}, ErrFilterTarget},
+		{[]ConfigFilterRule{{Name: "a", Type: FilterWords, Action: FilterHold}}, ErrFilterWords},
+	} {
+		if err := SetFilterRules(c.Rules); !errors.Is(err, c.Err) {
+			t.Errorf("%+v: expected %v, recieved %v", c.Rules, c.Err, err)
+		}
+	}
+
+	if err := SetFilterRules([]ConfigFilterRule{
+		{Name: "spam", Type: FilterRegex, Pattern: `(?i)buy now`, Action: FilterReject, Message: "No spam."},
+		{Name: "words", Type: FilterWords, Words: []string{"darn", "heck"}, Action: FilterHold},
+		{Name: "links", Type: FilterLinks, Max: 1, Action: FilterHold, Target: FilterText},
+		{Name: "caps", Type: FilterCaps, Action: FilterFlag},
+		{Name: "repeat", Type: FilterRepeat, Max: 4, Action: FilterFlag, Target: FilterTitle},
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, c := range []struct {
+		Target string
+		Text   string
+		Rule   string
+	}{
+		{FilterText, "hello there", ""},
+		{FilterText, "please BUY NOW", "spam"},
+		{FilterText, "oh Heck.", "words"},
+		{FilterText, "checking", ""},
+		{FilterText, "gemini://a.example/ and https://b.example/", "links"},
+		{FilterTitle, "gemini://a.example/ and https://b.example/", ""},
+		{FilterText, "gemini://a.example/ only one", ""},
+		{FilterText, "THIS IS A VERY LOUD POST", "caps"},
+		{FilterText, "OK", ""},
+		{FilterTitle, "hellooooo", "repeat"},
+		{FilterTitle, "helloooo", ""},
+		{FilterText, "hellooooo", ""},
+
+		// strongest action
+		{FilterText, "HECK, BUY NOW", "spam"},
+		{FilterText, "HECK, I LOVE THIS FORUM", "words"},
+	} {
+		match := CheckFilter(c.Target, c.Text)
+		if (match == nil && c.Rule != "") || (match != nil && match.Rule != c.Rule) {
+			t.Errorf("%s %q: expected %q, recieved %+v", c.Target, c.Text, c.Rule, match)
+		}
+	}
+	expected := []string{
+		"spam (regex, reject, text and title): 2 hits",
+		"words (words, hold, text and title): 3 hits",
+		"links (links, hold, text): 1 hits",
+		"caps (caps, flag, text and title): 3 hits",
+		"repeat (repeat, flag, title): 1 hits",
+	}
+	if stats := strings.Join(FilterRuleStats(), "\n"); stats != strings.Join(expected, "\n") {
+		t.Errorf("Recieved %q", stats)
+	}
+
+	/*
+		Hit counts are kept when reloading
+	*/
+	ConfigurationPath = ".testing/TestFilterRules.toml"
+	defer os.Remove(ConfigurationPath)
+	if err := os.WriteFile(ConfigurationPath, []byte("[[Filter]]\nname=\"spam\"\ntype=\"regex\"\npattern=\"spam\"\naction=\"flag\"\n"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if n, err := ReloadFilterRules(); n != 1 || err != nil {
+		t.Fatalf("Expected 1 rule, recieved %d %v", n, err)
+	}
+	if stats := strings.Join(FilterRuleStats(), "\n"); stats != "spam (regex, flag, text and title): 2 hits" {
+		t.Errorf("Recieved %q", stats)
+	}
+}
+
+func TestFilterNewPosts(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+	}
+	if err := SetFilterRules([]ConfigFilterRule{
+		{Name: "spam", Type: FilterWords, Words: []string{"spam"}, Action: FilterReject, Message: "No spam."},
+		{Name: "links", Type: FilterLinks, Action: FilterHold},
+		{Name: "caps", Type: FilterCaps, Action: FilterFlag},
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer SetFilterRules(nil)
+
+	var err error
+	var testDBpath string = ".testing/TestFilterNewPosts.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 2)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+	)
+	UpdateForPostNudge("bob")
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/new/thread/firstsub/spam%20here/?hello", Cert: 2, Response: []byte("59 No spam.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/new/thread/firstsub/first/?hello", Cert: 2, Response: []byte("30 /f/firstsub/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?some%20spam", Cert: 2, Response: []byte("59 No spam.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?see%20gemini%3A%2F%2Fexample.net%2F", Cert: 2, Response: HeldPostResponse("/thread/0000000000000001/").Bytes()},
+		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?THIS%20IS%20SO%20GOOD", Cert: 2, Response: []byte("30 /thread/0000000000000001/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?filters", Cert: 1, Response: []byte("20 text/plain\r\nspam (words, reject, text and title): 2 hits\nlinks (links, hold, text and title): 1 hits\ncaps (caps, flag, text and title): 1 hits")},
+		gemtest.Input{URL: "gemini://localhost/console/?filters", Cert: 2, Response: []byte("61 Unauthorized\r\n")},
+	)
+
+	/*
+		Flagged posts are in the moderation
+		queue, and held posts wait for approval
+	*/
+	reports, err := ListReports(false, func(Report) bool { return true })
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(reports) != 1 || reports[0].Post != "0000000000000002" || reports[0].Reporter != FilterReporter || reports[0].Reason != `Filter rule "caps"` {
+		t.Errorf("Incorrect reports: %+v", reports)
+	}
+	held, err := ListHeldPosts(func(HeldPost) bool { return true })
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(held) != 1 || held[0].Thread != "0000000000000001" || held[0].User != "bob" || held[0].Reason != `filter rule "links"` {
+		t.Errorf("Incorrect held posts: %+v", held)
+	}
+}
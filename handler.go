@@ -2,7 +2,6 @@ package main
 
 import (
 	"crypto/tls"
-	"fmt"
 	"log"
 	"net"
 	"net/url"
@@ -10,7 +9,6 @@ import (
 	"sync"
 
 	"codeberg.org/FiskFan1999/gemini"
-	"github.com/coinpaprika/ratelimiter"
 )
 
 /*
@@ -33,47 +31,29 @@ var InternalError = gemini.ResponseFormat{
 	Status: gemini.TemporaryFailure, Mime: "Internal error", Lines: nil,
 }
 
-var (
-	limitStore *ratelimiter.MapLimitStore
-	limiter    *ratelimiter.RateLimiter
-)
-
 var (
 	rateLimiterWarning sync.Once
 )
 
 func handler(u *url.URL, c *tls.Conn) gemini.Response {
 
-	// rate limiting check
 	ipport := c.RemoteAddr().String() // client's ip address
 	ip, _, err := net.SplitHostPort(ipport)
 	if err != nil {
 		log.Println(err.Error())
 		return InternalError
 	}
-	if limiter != nil {
-		stat, err := limiter.Check(ip)
-		if err != nil {
-			log.Println(err.Error())
-			return InternalError
-		}
-		if stat.IsLimited {
-			// rate limited
-			// meta = number of seconds to wait (integer rounded up)
-			wait := stat.LimitDuration.Seconds()
-			log.Println(wait)
-			return gemini.ResponseFormat{
-				Status: gemini.SlowDown,
-				Mime:   fmt.Sprintf("%d", int(wait)+1),
-				Lines:  nil,
-			}
-		} else {
-			// register hit for rate limiting.
-			err := limiter.Inc(ip)
-			if err != nil {
-				log.Println(err.Error())
-				return InternalError
-			}
+	fp := GetFingerprint(c)
+	var username string
+	var priv UserPriviledge
+	if fp != nil {
+		username, priv, _, _ = GetUsernameFromFP(fp)
+	}
+
+	// rate limiting check
+	if rateLimiters != nil {
+		if limited := CheckRateLimit(RouteRateClass(u), ip, username, priv); limited != nil {
+			return limited
 		}
 	} else {
 		rateLimiterWarning.Do(func() {
@@ -81,6 +61,15 @@ func handler(u *url.URL, c *tls.Conn) gemini.Response {
 		})
 	}
 
+	/*
+		Moderators and admins are not affected by
+		IP address and certificate bans, so that
+		they can not be locked out of the console.
+	*/
+	if ban, banned := GetConnectionBan(ip, fp); banned && !priv.Is(Mod) {
+		return BannedResponse(ban, GetUserSettings(username))
+	}
+
 	path := u.EscapedPath()
 
 	var resp gemini.Response
@@ -96,8 +85,16 @@ func handler(u *url.URL, c *tls.Conn) gemini.Response {
 		resp = ConsoleHandler(u, c)
 	} else if strings.HasPrefix(path, "/verify/") {
 		resp = VerifyUserHandler(u, c)
+	} else if strings.HasPrefix(path, "/resend/") {
+		resp = ResendVerificationHandler(u, c)
+	} else if strings.HasPrefix(path, "/settings/") {
+		resp = SettingsHandler(u, c)
+	} else if strings.HasPrefix(path, "/user/") {
+		resp = UserProfileHandler(u, c)
 	} else if strings.HasPrefix(path, "/report/") {
 		resp = ReportHandler(u, c)
+	} else if strings.HasPrefix(path, "/appeal/") {
+		resp = AppealHandler(u, c)
 	} else if strings.HasPrefix(path, "/f/") {
 		resp = SubforumIndexHandler(u, c)
 	} else if strings.HasPrefix(path, "/thread/") {
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Posts held for approval
+
+Posts by new accounts (see ConfigHold) and posts
+matching a content filter rule with the "hold"
+action are not written into the thread. They are
+kept here until a moderator approves them (which
+writes them like any other new post) or rejects
+them.
+
+DBHELD key=itob(NextSequence) sub-bucket:
+user=username
+thread=thread ID (for replies)
+subforum=subforum ID and title=thread title (for new threads)
+text=text of the post
+time=time.MarshalText() of when it was written
+reason=why the post was held
+*/
+
+const HoldNewAccount = "new account"
+
+var (
+	ErrHeldPostNotFound = errors.New("Held post not found.")
+	ErrHeldAuthorMuted  = errors.New("The author of this post is now muted.")
+	ErrHeldAuthorBanned = errors.New("The author of this post is now banned.")
+)
+
+type HeldPost struct {
+	ID       string
+	User     string
+	Thread   string
+	Subforum string
+	Title    string
+	Text     string
+	Time     time.Time
+	Reason   string
+}
+
+func (h HeldPost) String() string {
+	where := fmt.Sprintf("reply to thread %s", h.Thread)
+	if h.Thread == "" {
+		where = fmt.Sprintf("new thread %q in %s", h.Title, h.Subforum)
+	}
+	return fmt.Sprintf("%s: %s by %s on %s (%s): %s", h.ID, where, h.User, h.Time.UTC().Format(time.RFC1123), h.Reason, h.Text)
+}
+
+func (h HeldPost) SubforumID() string {
+	if h.Thread != "" {
+		return GetSubforumOfThread([]byte(h.Thread))
+	}
+	return h.Subforum
+}
+
+func IsNewAccount(username string) (isNew bool) {
+	/*
+		Whether the posts of this user are held
+		because of the hold policy.
+	*/
+	policy := Configuration.Hold
+	if policy.FirstPosts <= 0 && policy.Hours <= 0 {
+		return false
+	}
+	if LookupUserPriviledge(username).Is(Mod) {
+		return false
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil {
+			return nil
+		}
+		/*
+			Accounts created before the registration
+			time was stored are not new, but may
+			still have too few posts.
+		*/
+		if registeredText := user.Get([]byte("registered")); policy.Hours > 0 && len(registeredText) != 0 {
+			var registered time.Time
+			if err := registered.UnmarshalText(registeredText); err != nil {
+				return err
+			}
+			if time.Since(registered) < policy.Hours*time.Hour {
+				isNew = true
+				return nil
+			}
+		}
+		if policy.FirstPosts > 0 {
+			var count int
+			if posts := tx.Bucket(DBUSERPOSTS).Bucket([]byte(username)); posts != nil {
+				c := posts.Cursor()
+				for k, _ := c.First(); k != nil && count < policy.FirstPosts; k, _ = c.Next() {
+					count++
+				}
+			}
+			isNew = count < policy.FirstPosts
+		}
+		return nil
+	}); err != nil {
+		fmt.Println("Error during IsNewAccount:", err.Error())
+	}
+	return
+}
+
+func HoldReason(username string, match *FilterMatch) string {
+	/*
+		Empty if the post should not be held.
+	*/
+	if match != nil && match.Action == FilterHold {
+		return fmt.Sprintf("filter rule %q", match.Rule)
+	}
+	if IsNewAccount(username) {
+		return HoldNewAccount
+	}
+	return ""
+}
+
+func HeldPostResponse(back string) gemini.Response {
+	return gemini.ResponseFormat{
+		Status: gemini.Success,
+		Mime:   "text/gemini",
+		Lines: gemini.Lines{
+			"Thank you. Your post is awaiting review, and will be shown after a moderator approves it.",
+			fmt.Sprintf("%s%s Go back.", gemini.Link, back),
+		},
+	}
+}
+
+func holdPostTx(tx *bolt.Tx, h HeldPost) error {
+	held := tx.Bucket(DBHELD)
+	seq, err := held.NextSequence()
+	if err != nil {
+		return err
+	}
+	post, err := held.CreateBucket(itob(seq))
+	if err != nil {
+		return err
+	}
+	now, err := time.Now().MarshalText()
+	if err != nil {
+		return err
+	}
+	post.Put([]byte("user"), []byte(h.User))
+	if h.Thread != "" {
+		post.Put([]byte("thread"), []byte(h.Thread))
+	} else {
+		post.Put([]byte("subforum"), []byte(h.Subforum))
+		post.Put([]byte("title"), []byte(h.Title))
+	}
+	post.Put([]byte("text"), []byte(h.Text))
+	post.Put([]byte("time"), now)
+	return post.Put([]byte("reason"), []byte(h.Reason))
+}
+
+func readHeldPost(id []byte, post *bolt.Bucket) (h HeldPost, err error) {
+	h.ID = string(id)
+	h.User = string(post.Get([]byte("user")))
+	h.Thread = string(post.Get([]byte("thread")))
+	h.Subforum = string(post.Get([]byte("subforum")))
+	h.Title = string(post.Get([]byte("title")))
+	h.Text = string(post.Get([]byte("text")))
+	h.Reason = string(post.Get([]byte("reason")))
+	err = h.Time.UnmarshalText(post.Get([]byte("time")))
+	return
+}
+
+func ListHeldPosts(visible func(HeldPost) bool) (posts []HeldPost, err error) {
+	/*
+		Oldest first
+	*/
+	err = db.View(func(tx *bolt.Tx) error {
+		held := tx.Bucket(DBHELD)
+		return held.ForEach(func(k, v []byte) error {
+			post := held.Bucket(k)
+			if post == nil {
+				return nil
+			}
+			h, err := readHeldPost(k, post)
+			if err != nil {
+				return err
+			}
+			if visible(h) {
+				posts = append(posts, h)
+			}
+			return nil
+		})
+	})
+	return
+}
+
+func GetHeldPost(id string) (h HeldPost, err error) {
+	key, ok := sequenceKey(id)
+	if !ok {
+		return h, ErrHeldPostNotFound
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		post := tx.Bucket(DBHELD).Bucket(key)
+		if post == nil {
+			return ErrHeldPostNotFound
+		}
+		h, err = readHeldPost(key, post)
+		return err
+	})
+	return
+}
+
+func ApproveHeldPost(id string) (h HeldPost, err error) {
+	/*
+		Write the post as if it was just
+		posted (keeping the time it was
+		written), and remove it from DBHELD.
+	*/
+	key, ok := sequenceKey(id)
+	if !ok {
+		return h, ErrHeldPostNotFound
+	}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		held := tx.Bucket(DBHELD)
+		post := held.Bucket(key)
+		if post == nil {
+			return ErrHeldPostNotFound
+		}
+		h, err = readHeldPost(key, post)
+		if err != nil {
+			return err
+		}
+		timeBytes := append([]byte{}, post.Get([]byte("time"))...)
+
+		/*
+			The author or the thread may have been
+			moderated while the post was held.
+		*/
+		if user := tx.Bucket(DBUSERS).Bucket([]byte(h.User)); user != nil {
+			if isMuted, _ := userMutedStatus(user); isMuted {
+				return ErrHeldAuthorMuted
+			}
+		}
+		if _, banned := IsUserBannedTx(tx, h.User); banned {
+			return ErrHeldAuthorBanned
+		}
+		if h.Thread != "" {
+			thread := tx.Bucket(DBALLTHREADS).Bucket([]byte(h.Thread))
+			if thread == nil || IsThreadArchived(thread) {
+				return errors.New("Thread not found.")
+			}
+			if bytes.Equal(thread.Get([]byte("locked")), []byte("1")) {
+				return ErrThreadIsLocked
+			}
+			if err := addPostTx(tx, thread, h.User, h.Thread, h.Text, timeBytes, nil); err != nil {
+				return err
+			}
+		} else {
+			if h.Thread, err = addThreadTx(tx, h.Subforum, h.User, h.Title, h.Text, timeBytes, nil); err != nil {
+				return err
+			}
+		}
+		return held.DeleteBucket(key)
+	}); err != nil {
+		return
+	}
+	go SendNotifications(h.User, h.Thread, h.Text)
+	return
+}
+
+func RejectHeldPost(id string) error {
+	key, ok := sequenceKey(id)
+	if !ok {
+		return ErrHeldPostNotFound
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		held := tx.Bucket(DBHELD)
+		if held.Bucket(key) == nil {
+			return ErrHeldPostNotFound
+		}
+		return held.DeleteBucket(key)
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestHeldPosts(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+		Hold: ConfigHold{FirstPosts: 2},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestHeldPosts.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 3)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/carol/carol%40example.net/?password", Cert: 3, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/carol/?password", Cert: 3, Response: []byte("30 /\r\n")},
+	)
+	UpdateForPostNudge("alice")
+	UpdateForPostNudge("bob")
+	UpdateForPostNudge("carol")
+
+	/*
+		Admins are not held
+	*/
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/new/thread/firstsub/first/?hello", Cert: 1, Response: []byte("30 /f/firstsub/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/new/thread/firstsub/buy%20now/?cheap", Cert: 2, Response: HeldPostResponse("/f/firstsub/").Bytes()},
+		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?first%20reply", Cert: 2, Response: HeldPostResponse("/thread/0000000000000001/").Bytes()},
+		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?spam", Cert: 3, Response: HeldPostResponse("/thread/0000000000000001/").Bytes()},
+	)
+
+	held, err := ListHeldPosts(func(HeldPost) bool { return true })
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var lines []string
+	for _, h := range held {
+		lines = append(lines, h.String())
+	}
+	if len(held) != 3 || held[0].Title != "buy now" || held[1].Thread != "0000000000000001" || held[2].User != "carol" || held[2].Reason != HoldNewAccount {
+		t.Fatalf("Incorrect held posts: %+v", held)
+	}
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/console/?held", Cert: 1, Response: []byte(fmt.Sprintf("20 text/plain\r\n%s", strings.Join(lines, "\n")))},
+		gemtest.Input{URL: "gemini://localhost/console/?approvepost%2099", Cert: 1, Response: []byte("59 Held post not found.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?rejectpost%201", Cert: 1, Response: []byte("20 text/plain\r\nPost has been rejected and removed.")},
+		gemtest.Input{URL: "gemini://localhost/console/?approvepost%202", Cert: 1, Response: []byte("20 text/plain\r\nPost has been approved.")},
+		gemtest.Input{URL: "gemini://localhost/console/?approvepost%202", Cert: 1, Response: []byte("59 Held post not found.\r\n")},
+
+		/*
+			bob has one approved post, so the
+			next one is still held
+		*/
+		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?second%20reply", Cert: 2, Response: HeldPostResponse("/thread/0000000000000001/").Bytes()},
+		gemtest.Input{URL: "gemini://localhost/console/?approvepost%204", Cert: 1, Response: []byte("20 text/plain\r\nPost has been approved.")},
+		gemtest.Input{URL: "gemini://localhost/new/post/0000000000000001/?third%20reply", Cert: 2, Response: []byte("30 /thread/0000000000000001/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?held", Cert: 1, Response: []byte(fmt.Sprintf("20 text/plain\r\n%s", held[2].String()))},
+	)
+
+	/*
+		The author and thread are checked
+		again when a post is approved
+	*/
+	ConsoleCommand("alice", Admin, "mute carol permanent")
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/console/?approvepost%203", Cert: 1, Response: []byte("59 The author of this post is now muted.\r\n")},
+	)
+	ConsoleCommand("alice", Admin, "unmute carol")
+	ConsoleCommand("alice", Admin, "lock 0000000000000001")
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/console/?approvepost%203", Cert: 1, Response: []byte("59 Thread is locked\r\n")},
+	)
+	ConsoleCommand("alice", Admin, "unlock 0000000000000001")
+	ConsoleCommand("alice", Admin, "ban carol")
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/console/?approvepost%203", Cert: 1, Response: []byte("59 The author of this post is now banned.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?rejectpost%203", Cert: 1, Response: []byte("20 text/plain\r\nPost has been rejected and removed.")},
+	)
+}
+
+func TestIsNewAccount(t *testing.T) {
+	Configuration = &ConfigStr{
+		Hold: ConfigHold{Hours: 24},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestIsNewAccount.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for username, registered := range map[string]time.Time{
+		"alice": time.Now().Add(-time.Hour),
+		"bob":   time.Now().Add(-25 * time.Hour),
+		"carol": time.Time{}, // registered before the time was stored
+	} {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			user, err := tx.Bucket(DBUSERS).CreateBucket([]byte(username))
+			if err != nil {
+				return err
+			}
+			if registered.IsZero() {
+				return nil
+			}
+			registeredBytes, err := registered.MarshalText()
+			if err != nil {
+				return err
+			}
+			return user.Put([]byte("registered"), registeredBytes)
+		}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	if !IsNewAccount("alice") {
+		t.Error("Expected alice's posts to be held")
+	}
+	if IsNewAccount("bob") {
+		t.Error("Expected bob's posts not to be held")
+	}
+	if IsNewAccount("carol") {
+		t.Error("Expected carol's posts not to be held")
+	}
+	Configuration.Hold.FirstPosts = 1
+	if !IsNewAccount("carol") {
+		t.Error("Expected carol's posts to be held until their first post")
+	}
+	Configuration.Hold = ConfigHold{}
+	if IsNewAccount("alice") {
+		t.Error("Expected no posts to be held when the policy is disabled")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"codeberg.org/FiskFan1999/gemini"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Ignore lists
+
+DBIGNORES key=username (the ignorer) sub-bucket
+key=ignored username val="1"
+*/
+
+var (
+	ErrIgnoreSelf     = errors.New("You can not ignore yourself.")
+	ErrAlreadyIgnored = errors.New("You are already ignoring this user.")
+	ErrNotIgnored     = errors.New("You are not ignoring this user.")
+)
+
+func IsIgnoringTx(tx *bolt.Tx, ignorer, author string) bool {
+	all := tx.Bucket(DBIGNORES)
+	if all == nil || ignorer == "" {
+		return false
+	}
+	user := all.Bucket([]byte(ignorer))
+	if user == nil {
+		return false
+	}
+	return user.Get([]byte(author)) != nil
+}
+
+func GetIgnoredUsers(username string) (ignored map[string]bool) {
+	ignored = map[string]bool{}
+	if db == nil || username == "" {
+		return
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		all := tx.Bucket(DBIGNORES)
+		if all == nil {
+			return nil
+		}
+		user := all.Bucket([]byte(username))
+		if user == nil {
+			return nil
+		}
+		return user.ForEach(func(k, v []byte) error {
+			ignored[string(k)] = true
+			return nil
+		})
+	}); err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+func ListIgnoredUsers(username string) (names []string) {
+	for name := range GetIgnoredUsers(username) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func IgnoreUser(ignorer, ignored string) error {
+	if ignorer == ignored {
+		return ErrIgnoreSelf
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(DBUSERS).Bucket([]byte(ignored)) == nil {
+			return UserNotFound
+		}
+		user, err := tx.Bucket(DBIGNORES).CreateBucketIfNotExists([]byte(ignorer))
+		if err != nil {
+			return err
+		}
+		if user.Get([]byte(ignored)) != nil {
+			return ErrAlreadyIgnored
+		}
+		return user.Put([]byte(ignored), []byte("1"))
+	})
+}
+
+func UnignoreUser(ignorer, ignored string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBIGNORES).Bucket([]byte(ignorer))
+		if user == nil || user.Get([]byte(ignored)) == nil {
+			return ErrNotIgnored
+		}
+		return user.Delete([]byte(ignored))
+	})
+}
+
+func UserProfileHandler(u *url.URL, c *tls.Conn) gemini.Response {
+	/*
+		/user/<name>/
+		/user/<name>/ignore/
+		/user/<name>/unignore/
+	*/
+	parts := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
+	if len(parts) < 2 || len(parts) > 3 {
+		return gemini.BadRequest.Response("Bad request")
+	}
+	profile, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return gemini.BadRequest.Error(err)
+	}
+	if registered, ok := LookupUsername(profile); ok {
+		profile = registered
+	}
+
+	var username string
+	if fp := GetFingerprint(c); fp != nil {
+		username, _, _, _ = GetUsernameFromFP(fp)
+	}
+
+	if len(parts) == 3 {
+		if username == "" {
+			return CertRequired
+		}
+		switch parts[2] {
+		case "ignore":
+			err = IgnoreUser(username, profile)
+		case "unignore":
+			err = UnignoreUser(username, profile)
+		default:
+			return NotFound
+		}
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		return gemini.RedirectTemporary.Response(fmt.Sprintf("/user/%s/", parts[1]))
+	}
+
+	var exists, ignoring bool
+	var renamed string
+	if err := db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(DBUSERS).Bucket([]byte(profile)) != nil
+		if !exists {
+			renamed, _ = LookupRenamedUserTx(tx, profile)
+		}
+		ignoring = IsIgnoringTx(tx, username, profile)
+		return nil
+	}); err != nil {
+		return gemini.TemporaryFailure.Error(err)
+	}
+	if renamed != "" {
+		return gemini.RedirectPermanent.Response(fmt.Sprintf("/user/%s/", url.PathEscape(renamed)))
+	}
+	if !exists {
+		return NotFound
+	}
+
+	lines := gemini.Lines{}
+	lines.Header(1, DisplayUsernameAuto(profile))
+	lines.LinkDesc(fmt.Sprintf("/search/?%s", url.QueryEscape("@"+profile)), "Threads and replies")
+	if username != "" && username != profile {
+		if ignoring {
+			lines.Line("You are ignoring this user.")
+			lines.LinkDesc(fmt.Sprintf("/user/%s/unignore/", parts[1]), "Stop ignoring")
+		} else {
+			lines.LinkDesc(fmt.Sprintf("/user/%s/ignore/", parts[1]), "Ignore this user")
+		}
+	}
+	lines.Line("")
+	lines.LinkDesc("/", "Go to home.")
+
+	return gemini.ResponseFormat{
+		Status: gemini.Success,
+		Mime:   "text/gemini",
+		Lines:  lines,
+	}
+}
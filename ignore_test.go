@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestIgnoreUser(t *testing.T) {
+	Configuration = &ConfigStr{}
+	var err error
+	var testDBpath string = ".testing/TestIgnoreUser.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	/*
+		alice wrote a thread, and wants to be
+		notified about replies and mentions.
+	*/
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"alice", "bob", "carol"} {
+			user, err := tx.Bucket(DBUSERS).CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			user.Put([]byte("email"), []byte(name+"@example.com"))
+		}
+		thread, err := tx.Bucket(DBALLTHREADS).CreateBucket(itob(1))
+		if err != nil {
+			return err
+		}
+		thread.Put([]byte("title"), []byte("first thread"))
+		return thread.Put([]byte("user"), []byte("alice"))
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, name := range []string{"notifyreplies", "notifymentions"} {
+		if err := SetUserSetting("alice", name, "1"); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	for _, c := range []struct {
+		Ignored string
+		Err     error
+	}{
+		{"alice", ErrIgnoreSelf},
+		{"nobody", UserNotFound},
+		{"bob", nil},
+		{"bob", ErrAlreadyIgnored},
+	} {
+		if err := IgnoreUser("alice", c.Ignored); !errors.Is(err, c.Err) {
+			t.Errorf("ignore %s: expected error %v, recieved %v", c.Ignored, c.Err, err)
+		}
+	}
+	if ignored := ListIgnoredUsers("alice"); !cmp.Equal(ignored, []string{"bob"}) {
+		t.Errorf("Expected alice to ignore bob, recieved %q", ignored)
+	}
+
+	recipients := func(author string) (out []string) {
+		db.View(func(tx *bolt.Tx) error {
+			for _, n := range collectNotificationsTx(tx, author, string(itob(1)), "hello @alice") {
+				out = append(out, n.To)
+			}
+			return nil
+		})
+		return
+	}
+	if to := recipients("bob"); len(to) != 0 {
+		t.Errorf("Ignored user sent notifications to %q", to)
+	}
+	if to := recipients("carol"); !cmp.Equal(to, []string{"alice@example.com"}) {
+		t.Errorf("Expected one notification to alice, recieved %q", to)
+	}
+
+	if err := UnignoreUser("alice", "bob"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := UnignoreUser("alice", "bob"); !errors.Is(err, ErrNotIgnored) {
+		t.Errorf("Expected ErrNotIgnored, recieved %v", err)
+	}
+	if to := recipients("bob"); !cmp.Equal(to, []string{"alice@example.com"}) {
+		t.Errorf("Expected one notification to alice, recieved %q", to)
+	}
+}
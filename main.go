@@ -13,13 +13,13 @@ import (
 	"time"
 
 	"codeberg.org/FiskFan1999/gemini"
-	"github.com/coinpaprika/ratelimiter"
 	flag "github.com/spf13/pflag"
 )
 
 var (
 	serv              *gemini.Server
 	ConfigurationPath string
+	solveChallenge    string
 )
 
 var logf *os.File
@@ -32,6 +32,11 @@ func OnQuit() {
 	log.Println("TCP network shutting down.")
 	<-serv.ShutdownCompleted // wait for server shutting down to finish
 	log.Println("TCP network shut down complete.")
+	if rateLimitStore != nil {
+		if err := rateLimitStore.Flush(time.Now()); err != nil {
+			log.Printf("Error while flushing rate limits: %s\n", err.Error())
+		}
+	}
 	log.Println("Datbase shutting down")
 	db.Close()
 	log.Println("Datbase shutting down complete.")
@@ -57,6 +62,7 @@ func main() {
 	flag.BoolVarP(&repopulateKeywordDB, "repopulate-keywords", "r", false, "Repopulate keywords database before starting server.")
 	flag.BoolVarP(&displayConfiguration, "display-configuration", "d", false, "Show a JSON representation of the configuration. Can be used to debug errors while writing TOML.")
 	flag.BoolVarP(&showFullCopyright, "show-copyright", "s", false, "Show the copyright notice of this binary and its dependencies.")
+	flag.StringVar(&solveChallenge, "solve", "", "Solve a registration proof of work challenge and print the number to enter.")
 
 	flag.Parse()
 
@@ -65,6 +71,15 @@ func main() {
 		os.Exit(0)
 	}
 
+	if solveChallenge != "" {
+		nonce, err := SolveProofOfWork(solveChallenge)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(nonce)
+		os.Exit(0)
+	}
+
 	/*
 		Load configuration
 	*/
@@ -80,13 +95,6 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	/*
-		Initialize rate limiting in memory
-	*/
-	limitStore = ratelimiter.NewMapLimitStore(10*time.Minute, 30*Configuration.LimitWindow*time.Minute)
-	limiter = ratelimiter.New(limitStore, Configuration.LimitConnections, time.Second*Configuration.LimitWindow)
-	emailReportLimiter = ratelimiter.New(limitStore, 1, time.Minute)
-
 	/*
 		Load certificates
 	*/
@@ -109,6 +117,12 @@ func main() {
 	*/
 	initDatabase()
 
+	/*
+		Initialize rate limiting (stored in
+		the database)
+	*/
+	InitRateLimits()
+
 	/*
 		Initialize keyword
 	*/
@@ -143,6 +157,31 @@ func main() {
 		}
 	}()
 
+	/*
+		Start timer for purging unverified accounts
+	*/
+	purgeTicker := time.Tick(PurgeUnverifiedInterval)
+
+	go func() {
+		for {
+			<-purgeTicker
+			runPurgeUnverified()
+		}
+	}()
+
+	/*
+		Start timer for deleting the content
+		of purged users
+	*/
+	purgeDeletionTicker := time.Tick(PurgeDeletionInterval)
+
+	go func() {
+		for {
+			<-purgeDeletionTicker
+			runPurgeDeletion()
+		}
+	}()
+
 	/*
 		Open file for logging
 	*/
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Mutes
+
+The current mute of a user is kept in their
+bucket in DBUSERS:
+muted=""/"permanent"/time of unmute (see IsUserCurrentlyMuted)
+mutereason=reason given by the moderator
+
+Every mute is also recorded in DBMUTES, which
+contains a sub-bucket for each username. In
+each, key=itob(sequence) and the value is a
+sub-bucket:
+by=username of moderator
+created=time.Now().MarshalText()
+expires=time.MarshalText() ("" = permanent)
+reason=reason given by the moderator
+lifted=time.MarshalText() if unmuted early
+liftedby=username of moderator who unmuted
+*/
+
+type MuteRecord struct {
+	By       string
+	Created  time.Time
+	Expires  time.Time // zero = permanent
+	Reason   string
+	Lifted   time.Time // zero = not unmuted early
+	LiftedBy string
+}
+
+func (m MuteRecord) String() string {
+	until := "permanently"
+	if !m.Expires.IsZero() {
+		until = fmt.Sprintf("until %s", m.Expires.UTC().Format(time.RFC1123))
+	}
+	reason := m.Reason
+	if reason == "" {
+		reason = "no reason given"
+	}
+	s := fmt.Sprintf("%s - by %s, %s (%s)", m.Created.UTC().Format(time.RFC1123), m.By, until, reason)
+	if !m.Lifted.IsZero() {
+		s += fmt.Sprintf(", unmuted by %s on %s", m.LiftedBy, m.Lifted.UTC().Format(time.RFC1123))
+	}
+	return s
+}
+
+func userMutedStatus(user *bolt.Bucket) (bool, MutedStatus) {
+	isMuted, status := IsUserCurrentlyMuted(user.Get([]byte("muted")))
+	if isMuted {
+		status.Reason = string(user.Get([]byte("mutereason")))
+	}
+	return isMuted, status
+}
+
+func MuteUserTx(tx *bolt.Tx, username, by string, expires time.Time, reason string) error {
+	/*
+		expires.IsZero() mutes permanently.
+	*/
+	user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+	if user == nil {
+		return ErrUserNotFound
+	}
+	value := PERMANENTLYMUTED
+	if !expires.IsZero() {
+		value = []byte(expires.Format(time.RFC3339))
+	}
+	if err := user.Put([]byte("muted"), value); err != nil {
+		return err
+	}
+	if err := user.Put([]byte("mutereason"), []byte(reason)); err != nil {
+		return err
+	}
+
+	history, err := tx.Bucket(DBMUTES).CreateBucketIfNotExists([]byte(username))
+	if err != nil {
+		return err
+	}
+	seq, err := history.NextSequence()
+	if err != nil {
+		return err
+	}
+	record, err := history.CreateBucket(itob(seq))
+	if err != nil {
+		return err
+	}
+	created, err := time.Now().MarshalText()
+	if err != nil {
+		return err
+	}
+	var expiresText []byte
+	if !expires.IsZero() {
+		if expiresText, err = expires.MarshalText(); err != nil {
+			return err
+		}
+	}
+	for k, v := range map[string][]byte{
+		"by":      []byte(by),
+		"created": created,
+		"expires": expiresText,
+		"reason":  []byte(reason),
+	} {
+		if err := record.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func MuteUser(username, by string, expires time.Time, reason string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return MuteUserTx(tx, username, by, expires, reason)
+	})
+}
+
+func UnmuteUser(username, by string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return unmuteUserTx(tx, username, by)
+	})
+}
+
+func unmuteUserTx(tx *bolt.Tx, username, by string) error {
+	user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+	if user == nil {
+		return ErrUserNotFound
+	}
+	isMuted, _ := userMutedStatus(user)
+	if err := user.Put([]byte("muted"), []byte("")); err != nil {
+		return err
+	}
+	if err := user.Delete([]byte("mutereason")); err != nil {
+		return err
+	}
+	if !isMuted {
+		return nil
+	}
+
+	/*
+		Record that the latest mute
+		was lifted early.
+	*/
+	history := tx.Bucket(DBMUTES).Bucket([]byte(username))
+	if history == nil {
+		return nil
+	}
+	k, _ := history.Cursor().Last()
+	if k == nil {
+		return nil
+	}
+	record := history.Bucket(k)
+	if record == nil {
+		return nil
+	}
+	lifted, err := time.Now().MarshalText()
+	if err != nil {
+		return err
+	}
+	if err := record.Put([]byte("lifted"), lifted); err != nil {
+		return err
+	}
+	return record.Put([]byte("liftedby"), []byte(by))
+}
+
+func GetMuteHistory(username string) (mutes []MuteRecord, err error) {
+	/*
+		Oldest first.
+	*/
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(DBUSERS).Bucket([]byte(username)) == nil {
+			return ErrUserNotFound
+		}
+		history := tx.Bucket(DBMUTES).Bucket([]byte(username))
+		if history == nil {
+			return nil
+		}
+		return history.ForEach(func(k, v []byte) error {
+			record := history.Bucket(k)
+			if record == nil {
+				return nil
+			}
+			m := MuteRecord{
+				By:       string(record.Get([]byte("by"))),
+				Reason:   string(record.Get([]byte("reason"))),
+				LiftedBy: string(record.Get([]byte("liftedby"))),
+			}
+			if err := m.Created.UnmarshalText(record.Get([]byte("created"))); err != nil {
+				return err
+			}
+			if expires := record.Get([]byte("expires")); len(expires) != 0 {
+				if err := m.Expires.UnmarshalText(expires); err != nil {
+					return err
+				}
+			}
+			if lifted := record.Get([]byte("lifted")); len(lifted) != 0 {
+				if err := m.Lifted.UnmarshalText(lifted); err != nil {
+					return err
+				}
+			}
+			mutes = append(mutes, m)
+			return nil
+		})
+	})
+	return
+}
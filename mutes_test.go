@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMuteAndLockReasons(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestMuteAndLockReasons.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, username := range []string{"alice", "bob"} {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.Bucket(DBUSERS).CreateBucket([]byte(username))
+			return err
+		}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	OnNewThread("firstsub", "alice", "first thread", "hello")
+
+	mutedStatus := func() (isMuted bool, status MutedStatus) {
+		db.View(func(tx *bolt.Tx) error {
+			isMuted, status = userMutedStatus(tx.Bucket(DBUSERS).Bucket([]byte("bob")))
+			return nil
+		})
+		return
+	}
+
+	/*
+		Mutes
+	*/
+	if response, status := ConsoleCommand("alice", Admin, "mute bob 3 spamming links"); status != gemini.Success {
+		t.Fatalf("Recieved %d %q", status, response)
+	}
+	isMuted, status := mutedStatus()
+	if msg := status.Message(); !isMuted || !strings.HasPrefix(msg, "You are currently temporarily muted (") || !strings.HasSuffix(msg, "). Reason: spamming links") {
+		t.Errorf("Recieved %v %q", isMuted, msg)
+	}
+	if info, _ := ConsoleCommand("alice", Admin, "user bob"); !strings.Contains(info, " - spamming links\nBanned: no") {
+		t.Errorf("Recieved %q", info)
+	}
+
+	ConsoleCommand("alice", Admin, "unmute bob")
+	if isMuted, status := mutedStatus(); isMuted || status.Reason != "" {
+		t.Errorf("Expected bob to be unmuted, recieved %+v", status)
+	}
+	ConsoleCommand("alice", Admin, "mute bob permanent")
+	if _, status := mutedStatus(); status.Message() != "You are currently permanently muted." {
+		t.Errorf("Recieved %q", status.Message())
+	}
+
+	history, respStatus := ConsoleCommand("alice", Admin, "mutes bob")
+	lines := strings.Split(history, "\n")
+	if respStatus != gemini.Success || len(lines) != 2 {
+		t.Fatalf("Recieved %d %q", respStatus, history)
+	}
+	if !strings.Contains(lines[0], " - by alice, until ") || !strings.Contains(lines[0], "(spamming links), unmuted by alice on ") {
+		t.Errorf("Recieved %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], " - by alice, permanently (no reason given)") {
+		t.Errorf("Recieved %q", lines[1])
+	}
+	if history, status := ConsoleCommand("alice", Admin, "mutes alice"); status != gemini.Success || history != "This user has never been muted." {
+		t.Errorf("Recieved %d %q", status, history)
+	}
+	if _, err := GetMuteHistory("nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, recieved %v", err)
+	}
+
+	/*
+		Locks
+	*/
+	threadURL, err := url.Parse("/t/0000000000000001/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	viewThread := func() string {
+		return strings.Join(ThreadViewHandler(threadURL, nil).(gemini.ResponseFormat).Lines, "\n")
+	}
+
+	if response, status := ConsoleCommand("alice", Admin, "lock 0000000000000001 off topic"); status != gemini.Success {
+		t.Fatalf("Recieved %d %q", status, response)
+	}
+	if view := viewThread(); !strings.Contains(view, "This thread is locked and not accepting new comments.\nReason: off topic") {
+		t.Errorf("Recieved %q", view)
+	}
+	if resp := string(OnNewPost("bob", "0000000000000001", "hello", false).Bytes()); !strings.Contains(resp, "Thread is locked. Reason: off topic") {
+		t.Errorf("Recieved %q", resp)
+	}
+
+	ConsoleCommand("alice", Admin, "unlock 0000000000000001")
+	ConsoleCommand("alice", Admin, "lock 0000000000000001")
+	if view := viewThread(); !strings.Contains(view, "This thread is locked and not accepting new comments.") || strings.Contains(view, "Reason:") {
+		t.Errorf("Recieved %q", view)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/jordan-wright/email"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+@username in the text of a post
+*/
+var mentionRegexp = regexp.MustCompile(`@([` + usernameCharClass + `]+)`)
+
+func GetMentions(text string) (names []string) {
+	seen := map[string]bool{}
+	for _, m := range mentionRegexp.FindAllStringSubmatch(text, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return
+}
+
+type notification struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+func collectNotificationsTx(tx *bolt.Tx, author, threadID, text string) (out []notification) {
+	/*
+		Find the users that asked to be notified
+		about this post: the author of the thread
+		(replies) and every user mentioned with
+		@username. Authors are never notified about
+		their own posts, and users are never notified
+		about posts by users that they ignore.
+	*/
+	thread := tx.Bucket(DBALLTHREADS).Bucket([]byte(threadID))
+	if thread == nil {
+		return
+	}
+	title := string(thread.Get([]byte("title")))
+	link := fmt.Sprintf("gemini://%s/thread/%s/", Configuration.Hostname, threadID)
+	users := tx.Bucket(DBUSERS)
+
+	notified := map[string]bool{author: true}
+	add := func(username, subject string) {
+		if notified[username] || IsIgnoringTx(tx, username, author) {
+			return
+		}
+		user := users.Bucket([]byte(username))
+		if user == nil {
+			return
+		}
+		notified[username] = true
+		out = append(out, notification{
+			To:      string(user.Get([]byte("email"))),
+			Subject: subject,
+			Text:    fmt.Sprintf("%s wrote in \"%s\":\n\n%s\n\n%s\n\nYou can turn off these emails at gemini://%s/settings/", author, title, text, link, Configuration.Hostname),
+		})
+	}
+
+	if threadAuthor := string(thread.Get([]byte("user"))); getUserSettingsTx(tx, threadAuthor).NotifyReplies {
+		add(threadAuthor, fmt.Sprintf("%s: new reply to %s", Configuration.ForumName, title))
+	}
+	for _, username := range GetMentions(text) {
+		if getUserSettingsTx(tx, username).NotifyMentions {
+			add(username, fmt.Sprintf("%s: %s mentioned you", Configuration.ForumName, author))
+		}
+	}
+	return
+}
+
+func SendNotifications(author, threadID, text string) {
+	/*
+		Send email notifications for a new post.
+		Called in a new goroutine after the post
+		has been written.
+	*/
+	if !Configuration.Smtp.Enabled {
+		return
+	}
+	var notifications []notification
+	if err := db.View(func(tx *bolt.Tx) error {
+		notifications = collectNotificationsTx(tx, author, threadID, text)
+		return nil
+	}); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	for _, n := range notifications {
+		em := email.NewEmail()
+		em.From = Configuration.Smtp.From
+		em.To = []string{n.To}
+		em.Subject = n.Subject
+		em.Text = []byte(n.Text)
+		if err := sendEmail(em); err != nil {
+			log.Printf("Error while sending notification to %s: %s\n", n.To, err.Error())
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+Password hashes
+
+Every stored hash begins with the name of its
+algorithm, so that older hashes can still be
+checked after the policy changes:
+
+bcrypt: $2a$<cost>$... (as written by bcrypt)
+Argon2id: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+(the PHC string format, with unpadded base64)
+*/
+
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+)
+
+const (
+	BcryptStrength = 12 // default bcrypt cost
+
+	Argon2Time    = 3         // default number of passes
+	Argon2Memory  = 64 * 1024 // default memory in KiB
+	Argon2Threads = 4         // default parallelism
+	Argon2SaltLen = 16
+	Argon2KeyLen  = 32
+)
+
+var (
+	ErrPasswordMismatch         = errors.New("Login unsuccessful")
+	ErrPasswordHashMalformed    = errors.New("Malformed password hash")
+	ErrInvalidPasswordAlgorithm = errors.New("Invalid password algorithm (must be \"argon2id\" or \"bcrypt\")")
+)
+
+var argon2Encoding = base64.RawStdEncoding
+
+type argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+func PasswordPolicy() ConfigPassword {
+	/*
+		Configuration.Password with the
+		defaults filled in
+	*/
+	var policy ConfigPassword
+	if Configuration != nil {
+		policy = Configuration.Password
+	}
+	policy.Algorithm = strings.ToLower(policy.Algorithm)
+	if policy.Algorithm == "" {
+		policy.Algorithm = PasswordArgon2id
+	}
+	if policy.BcryptCost == 0 {
+		policy.BcryptCost = BcryptStrength
+	}
+	if policy.Time == 0 {
+		policy.Time = Argon2Time
+	}
+	if policy.Memory == 0 {
+		policy.Memory = Argon2Memory
+	}
+	if policy.Threads == 0 {
+		policy.Threads = Argon2Threads
+	}
+	return policy
+}
+
+func ValidatePasswordPolicy(policy ConfigPassword) error {
+	switch strings.ToLower(policy.Algorithm) {
+	case "", PasswordArgon2id, PasswordBcrypt:
+	default:
+		return ErrInvalidPasswordAlgorithm
+	}
+	if policy.BcryptCost != 0 && (policy.BcryptCost < bcrypt.MinCost || policy.BcryptCost > bcrypt.MaxCost) {
+		return fmt.Errorf("Invalid bcrypt cost (must be between %d and %d)", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+func HashPassword(password string) ([]byte, error) {
+	policy := PasswordPolicy()
+	if policy.Algorithm == PasswordBcrypt {
+		return bcrypt.GenerateFromPassword([]byte(password), policy.BcryptCost)
+	}
+
+	salt := make([]byte, Argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	params := argon2Params{policy.Time, policy.Memory, policy.Threads}
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, Argon2KeyLen)
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Time, params.Threads, argon2Encoding.EncodeToString(salt), argon2Encoding.EncodeToString(key))), nil
+}
+
+func parseArgon2Hash(hash []byte) (params argon2Params, salt, key []byte, err error) {
+	/*
+		"", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	*/
+	fields := strings.Split(string(hash), "$")
+	if len(fields) != 6 || fields[1] != PasswordArgon2id {
+		err = ErrPasswordHashMalformed
+		return
+	}
+	var version int
+	if _, err = fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		err = ErrPasswordHashMalformed
+		return
+	}
+	if _, err = fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		err = ErrPasswordHashMalformed
+		return
+	}
+	if salt, err = argon2Encoding.DecodeString(fields[4]); err != nil {
+		err = ErrPasswordHashMalformed
+		return
+	}
+	if key, err = argon2Encoding.DecodeString(fields[5]); err != nil {
+		err = ErrPasswordHashMalformed
+	}
+	return
+}
+
+func isArgon2Hash(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func CheckPassword(hash []byte, password string) error {
+	/*
+		Returns ErrPasswordMismatch if the
+		password is wrong, whichever algorithm
+		the hash was made with.
+	*/
+	if !isArgon2Hash(hash) {
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			return ErrPasswordMismatch
+		}
+		return nil
+	}
+	params, salt, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return err
+	}
+	computed := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func PasswordNeedsRehash(hash []byte) bool {
+	/*
+		True if this hash does not use the
+		configured algorithm, or uses weaker
+		parameters than the current policy.
+	*/
+	policy := PasswordPolicy()
+	if !isArgon2Hash(hash) {
+		if policy.Algorithm != PasswordBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost(hash)
+		return err != nil || cost < policy.BcryptCost
+	}
+	if policy.Algorithm != PasswordArgon2id {
+		return true
+	}
+	params, _, _, err := parseArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Time < policy.Time || params.Memory < policy.Memory || params.Threads < policy.Threads
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	for _, algorithm := range []string{PasswordArgon2id, PasswordBcrypt} {
+		Configuration = &ConfigStr{Password: ConfigPassword{Algorithm: algorithm, BcryptCost: bcrypt.MinCost, Memory: 1024, Time: 1, Threads: 1}}
+		hash, err := HashPassword("password")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if isArgon2Hash(hash) != (algorithm == PasswordArgon2id) {
+			t.Errorf("%s: hash has the wrong algorithm: %s", algorithm, hash)
+		}
+		if err := CheckPassword(hash, "password"); err != nil {
+			t.Errorf("%s: correct password was not accepted: %v", algorithm, err)
+		}
+		if err := CheckPassword(hash, "wrong"); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("%s: expected ErrPasswordMismatch, recieved %v", algorithm, err)
+		}
+		if PasswordNeedsRehash(hash) {
+			t.Errorf("%s: new hash needs rehash", algorithm)
+		}
+	}
+
+	argon2Hash := []byte("$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$Y2FrZQ")
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	for _, c := range []struct {
+		Policy ConfigPassword
+		Hash   []byte
+		Rehash bool
+	}{
+		{ConfigPassword{}, bcryptHash, true},
+		{ConfigPassword{Algorithm: PasswordBcrypt}, bcryptHash, true},
+		{ConfigPassword{Algorithm: PasswordBcrypt, BcryptCost: bcrypt.MinCost}, bcryptHash, false},
+		{ConfigPassword{Memory: 1024, Time: 1, Threads: 1}, argon2Hash, false},
+		{ConfigPassword{Memory: 2048, Time: 1, Threads: 1}, argon2Hash, true},
+		{ConfigPassword{Memory: 1024, Time: 2, Threads: 1}, argon2Hash, true},
+		{ConfigPassword{Algorithm: PasswordBcrypt}, argon2Hash, true},
+	} {
+		Configuration = &ConfigStr{Password: c.Policy}
+		if rehash := PasswordNeedsRehash(c.Hash); rehash != c.Rehash {
+			t.Errorf("Policy %+v, hash %s: expected rehash = %v", c.Policy, c.Hash, c.Rehash)
+		}
+	}
+
+	if err := CheckPassword([]byte("$argon2id$v=19$m=1024"), "password"); !errors.Is(err, ErrPasswordHashMalformed) {
+		t.Errorf("Expected ErrPasswordHashMalformed, recieved %v", err)
+	}
+	if err := ValidatePasswordPolicy(ConfigPassword{Algorithm: "md5"}); !errors.Is(err, ErrInvalidPasswordAlgorithm) {
+		t.Errorf("Expected ErrInvalidPasswordAlgorithm, recieved %v", err)
+	}
+}
+
+func TestLoginRehash(t *testing.T) {
+	Configuration = &ConfigStr{}
+
+	var err error
+	var testDBpath string = ".testing/TestLoginRehash.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	/*
+		Account from before Argon2id was used
+	*/
+	if err := db.Update(func(tx *bolt.Tx) error {
+		user, err := tx.Bucket(DBUSERS).CreateBucket([]byte("alice"))
+		if err != nil {
+			return err
+		}
+		phash, err := bcrypt.GenerateFromPassword([]byte("password"), BcryptStrength)
+		if err != nil {
+			return err
+		}
+		user.Put([]byte("password"), phash)
+		return user.Put([]byte("verified"), []byte("1"))
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	storedHash := func() (hash []byte) {
+		db.View(func(tx *bolt.Tx) error {
+			hash = append(hash, tx.Bucket(DBUSERS).Bucket([]byte("alice")).Get([]byte("password"))...)
+			return nil
+		})
+		return
+	}
+
+	serv := gemtest.Testd(t, handler, 1)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/login/alice/?wrong", Cert: 1, Response: []byte("59 Login unsuccessful\r\n")},
+	)
+	if isArgon2Hash(storedHash()) {
+		t.Fatal("Password was rehashed after an unsuccessful login")
+	}
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+	)
+	if hash := storedHash(); !isArgon2Hash(hash) || PasswordNeedsRehash(hash) {
+		t.Fatalf("Password was not rehashed: %s", hash)
+	}
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/logout/", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+	)
+}
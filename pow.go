@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Proof of work for registration
+
+A challenge is "<difficulty>.<expiry>.<random>.<mac>",
+where mac is an HMAC of the other fields with a key
+which is made when the server starts, so challenges
+do not need to be stored. A solution is a number N
+such that SHA-256("<challenge>.<N>") begins with at
+least <difficulty> zero bits. The solution is
+written in the registration path:
+
+/register/<challenge>.<N>/<username>/<email>/?<password>
+
+(after the invite code, in invite mode).
+Each solution may only be used for one account.
+*/
+
+const (
+	ProofOfWorkDifficulty    = 20 // default leading zero bits
+	ProofOfWorkMaxDifficulty = 28 // default
+	ProofOfWorkExpiry        = 30 * time.Minute
+	ProofOfWorkWindow        = time.Hour // registrations counted when scaling difficulty
+)
+
+var (
+	ErrProofOfWorkMalformed = errors.New("Malformed proof of work challenge.")
+	ErrProofOfWorkExpired   = errors.New("This proof of work challenge has expired. Please start registering again.")
+	ErrProofOfWorkInvalid   = errors.New("Invalid proof of work solution.")
+	ErrProofOfWorkUsed      = errors.New("This proof of work solution has already been used.")
+)
+
+var (
+	proofOfWorkKey     []byte
+	proofOfWorkKeyOnce sync.Once
+
+	proofOfWorkMutex sync.Mutex
+	// used challenge -> its expiry
+	proofOfWorkUsed = map[string]time.Time{}
+	// times of recent registrations
+	proofOfWorkRecent []time.Time
+)
+
+func ProofOfWorkEnabled() bool {
+	return Configuration != nil && Configuration.ProofOfWork.Enabled
+}
+
+func getProofOfWorkKey() []byte {
+	proofOfWorkKeyOnce.Do(func() {
+		proofOfWorkKey = make([]byte, 32)
+		if _, err := rand.Read(proofOfWorkKey); err != nil {
+			panic(err)
+		}
+	})
+	return proofOfWorkKey
+}
+
+func proofOfWorkMAC(fields string) string {
+	mac := hmac.New(sha256.New, getProofOfWorkKey())
+	mac.Write([]byte(fields))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func CurrentProofOfWorkDifficulty(now time.Time) int {
+	/*
+		The difficulty rises by one bit (twice as
+		much work) each time the number of
+		registrations in the last hour doubles
+		past SpikeThreshold.
+	*/
+	config := Configuration.ProofOfWork
+	difficulty := config.Difficulty
+	if difficulty <= 0 {
+		difficulty = ProofOfWorkDifficulty
+	}
+	maxDifficulty := config.MaxDifficulty
+	if maxDifficulty <= 0 {
+		maxDifficulty = ProofOfWorkMaxDifficulty
+	}
+	if config.SpikeThreshold > 0 {
+		proofOfWorkMutex.Lock()
+		count := 0
+		for _, t := range proofOfWorkRecent {
+			if now.Sub(t) < ProofOfWorkWindow {
+				count++
+			}
+		}
+		proofOfWorkMutex.Unlock()
+		for n := config.SpikeThreshold; count >= n; n *= 2 {
+			difficulty++
+		}
+	}
+	if difficulty > maxDifficulty {
+		difficulty = maxDifficulty
+	}
+	return difficulty
+}
+
+func NewProofOfWorkChallenge(now time.Time) string {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	fields := fmt.Sprintf("%d.%d.%s", CurrentProofOfWorkDifficulty(now), now.Add(ProofOfWorkExpiry).Unix(), hex.EncodeToString(random))
+	return fmt.Sprintf("%s.%s", fields, proofOfWorkMAC(fields))
+}
+
+func parseProofOfWorkChallenge(challenge string, now time.Time) (difficulty int, expiry time.Time, err error) {
+	fields := strings.Split(challenge, ".")
+	if len(fields) != 4 {
+		return 0, expiry, ErrProofOfWorkMalformed
+	}
+	if !hmac.Equal([]byte(proofOfWorkMAC(strings.Join(fields[:3], "."))), []byte(fields[3])) {
+		return 0, expiry, ErrProofOfWorkMalformed
+	}
+	difficulty, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, expiry, ErrProofOfWorkMalformed
+	}
+	unix, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, expiry, ErrProofOfWorkMalformed
+	}
+	expiry = time.Unix(unix, 0)
+	if now.After(expiry) {
+		return 0, expiry, ErrProofOfWorkExpired
+	}
+	return difficulty, expiry, nil
+}
+
+func proofOfWorkZeroBits(challenge string, nonce uint64) int {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s.%d", challenge, nonce)))
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+func splitProofOfWorkSolution(solution string) (challenge string, nonce uint64, err error) {
+	i := strings.LastIndexByte(solution, '.')
+	if i == -1 {
+		return "", 0, ErrProofOfWorkMalformed
+	}
+	nonce, err = strconv.ParseUint(solution[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, ErrProofOfWorkInvalid
+	}
+	return solution[:i], nonce, nil
+}
+
+func CheckProofOfWork(solution string, now time.Time) (expiry time.Time, err error) {
+	/*
+		solution = "<challenge>.<N>"
+	*/
+	challenge, nonce, err := splitProofOfWorkSolution(solution)
+	if err != nil {
+		return
+	}
+	difficulty, expiry, err := parseProofOfWorkChallenge(challenge, now)
+	if err != nil {
+		return
+	}
+	if proofOfWorkZeroBits(challenge, nonce) < difficulty {
+		return expiry, ErrProofOfWorkInvalid
+	}
+	proofOfWorkMutex.Lock()
+	defer proofOfWorkMutex.Unlock()
+	if _, used := proofOfWorkUsed[challenge]; used {
+		return expiry, ErrProofOfWorkUsed
+	}
+	return expiry, nil
+}
+
+func UseProofOfWork(solution string, now time.Time) error {
+	/*
+		Called when an account is registered.
+		The challenge can not be used again,
+		with this or any other number.
+	*/
+	expiry, err := CheckProofOfWork(solution, now)
+	if err != nil {
+		return err
+	}
+	challenge, _, _ := splitProofOfWorkSolution(solution)
+
+	proofOfWorkMutex.Lock()
+	defer proofOfWorkMutex.Unlock()
+	if _, used := proofOfWorkUsed[challenge]; used {
+		// used while this request was checked
+		return ErrProofOfWorkUsed
+	}
+	for c, e := range proofOfWorkUsed {
+		if now.After(e) {
+			delete(proofOfWorkUsed, c)
+		}
+	}
+	proofOfWorkUsed[challenge] = expiry
+
+	recent := proofOfWorkRecent[:0]
+	for _, t := range proofOfWorkRecent {
+		if now.Sub(t) < ProofOfWorkWindow {
+			recent = append(recent, t)
+		}
+	}
+	proofOfWorkRecent = append(recent, now)
+	return nil
+}
+
+func ReleaseProofOfWork(solution string, used time.Time) {
+	/*
+		Undo UseProofOfWork(solution, used) when
+		the registration failed, so that the user
+		does not have to solve a new challenge.
+	*/
+	challenge, _, _ := splitProofOfWorkSolution(solution)
+
+	proofOfWorkMutex.Lock()
+	defer proofOfWorkMutex.Unlock()
+	delete(proofOfWorkUsed, challenge)
+	for i, t := range proofOfWorkRecent {
+		if t.Equal(used) {
+			proofOfWorkRecent = append(proofOfWorkRecent[:i], proofOfWorkRecent[i+1:]...)
+			break
+		}
+	}
+}
+
+func SolveProofOfWork(challenge string) (uint64, error) {
+	/*
+		Used by the --solve flag.
+	*/
+	fields := strings.Split(challenge, ".")
+	if len(fields) != 4 {
+		return 0, ErrProofOfWorkMalformed
+	}
+	difficulty, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, ErrProofOfWorkMalformed
+	}
+	for nonce := uint64(0); ; nonce++ {
+		if proofOfWorkZeroBits(challenge, nonce) >= difficulty {
+			return nonce, nil
+		}
+	}
+}
+
+func ProofOfWorkPrompt(challenge string) string {
+	difficulty, _ := strconv.Atoi(strings.SplitN(challenge, ".", 2)[0])
+	return fmt.Sprintf("Proof of work: enter a number N such that the SHA-256 hash of \"%s.N\" begins with %d zero bits (larigot --solve %s)", challenge, difficulty, challenge)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestProofOfWork(t *testing.T) {
+	Configuration = &ConfigStr{
+		ProofOfWork: ConfigProofOfWork{Enabled: true, Difficulty: 4, MaxDifficulty: 6, SpikeThreshold: 2},
+	}
+	proofOfWorkRecent = nil
+
+	now := time.Now()
+	challenge := NewProofOfWorkChallenge(now)
+	nonce, err := SolveProofOfWork(challenge)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	solution := fmt.Sprintf("%s.%d", challenge, nonce)
+
+	for _, c := range []struct {
+		Solution string
+		Time     time.Time
+		Err      error
+	}{
+		{solution, now, nil},
+		{solution, now.Add(ProofOfWorkExpiry + time.Second), ErrProofOfWorkExpired},
+		{"8" + solution[1:], now, ErrProofOfWorkMalformed}, // difficulty changed
+		{challenge + ".x", now, ErrProofOfWorkInvalid},
+	} {
+		if _, err := CheckProofOfWork(c.Solution, c.Time); !errors.Is(err, c.Err) {
+			t.Errorf("%s: expected %v, recieved %v", c.Solution, c.Err, err)
+		}
+	}
+
+	if err := UseProofOfWork(solution, now); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := UseProofOfWork(solution, now); !errors.Is(err, ErrProofOfWorkUsed) {
+		t.Errorf("Expected ErrProofOfWorkUsed, recieved %v", err)
+	}
+
+	/*
+		Difficulty rises by one at 2, 4, 8...
+		registrations, up to the maximum.
+	*/
+	for registrations, expected := range []int{4, 4, 5, 5, 6, 6, 6, 6, 6} {
+		proofOfWorkRecent = nil
+		for i := 0; i < registrations; i++ {
+			proofOfWorkRecent = append(proofOfWorkRecent, now)
+		}
+		if difficulty := CurrentProofOfWorkDifficulty(now); difficulty != expected {
+			t.Errorf("%d registrations: expected difficulty %d, recieved %d", registrations, expected, difficulty)
+		}
+		if difficulty := CurrentProofOfWorkDifficulty(now.Add(ProofOfWorkWindow)); difficulty != 4 {
+			t.Errorf("%d registrations an hour ago: expected difficulty 4, recieved %d", registrations, difficulty)
+		}
+	}
+	proofOfWorkRecent = nil
+}
+
+func TestRegisterProofOfWork(t *testing.T) {
+	Configuration = &ConfigStr{
+		ProofOfWork: ConfigProofOfWork{Enabled: true, Difficulty: 4},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestRegisterProofOfWork.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	request := func(path string) string {
+		u, err := url.Parse(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return string(RegisterUserHandler(u, nil).Bytes())
+	}
+
+	/*
+		Skipping the proof of work is not possible
+	*/
+	if resp := request("/register/alice/alice%40example.net/?password"); resp != "59 Malformed proof of work challenge.\r\n" {
+		t.Errorf("Recieved %q", resp)
+	}
+
+	resp := request("/register/")
+	if !strings.HasPrefix(resp, "30 /register/") {
+		t.Fatalf("Expected redirect to challenge, recieved %q", resp)
+	}
+	challenge := strings.Trim(strings.TrimSpace(resp[3:]), "/")[len("register/"):]
+	if resp := request(fmt.Sprintf("/register/%s/", challenge)); resp != fmt.Sprintf("10 %s\r\n", ProofOfWorkPrompt(challenge)) {
+		t.Errorf("Recieved %q", resp)
+	}
+	nonce, err := SolveProofOfWork(challenge)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	solution := fmt.Sprintf("%s.%d", challenge, nonce)
+	if resp := request(fmt.Sprintf("/register/%s/?%d", challenge, nonce)); resp != fmt.Sprintf("30 /register/%s/\r\n", solution) {
+		t.Errorf("Recieved %q", resp)
+	}
+	if resp := request(fmt.Sprintf("/register/%s/?alice", solution)); resp != fmt.Sprintf("30 /register/%s/alice/\r\n", solution) {
+		t.Errorf("Recieved %q", resp)
+	}
+
+	/*
+		A failed registration does not use
+		the solution
+	*/
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.Bucket(DBUSERS).CreateBucket([]byte("carol")); err != nil {
+			return err
+		}
+		return addUsernameIndexTx(tx, "carol")
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if resp := request(fmt.Sprintf("/register/%s/carol/carol%%40example.net/?password", solution)); resp != "59 "+ErrUserAlreadyExists.Error()+"\r\n" {
+		t.Errorf("Recieved %q", resp)
+	}
+	if resp := request(fmt.Sprintf("/register/%s/alice/alice%%40example.net/?password", solution)); resp != "30 /\r\n" {
+		t.Errorf("Recieved %q", resp)
+	}
+	if resp := request(fmt.Sprintf("/register/%s/bob/bob%%40example.net/?password", solution)); resp != "59 This proof of work solution has already been used.\r\n" {
+		t.Errorf("Recieved %q", resp)
+	}
+}
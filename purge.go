@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Purging the content of a user
+
+"purge <username>" archives every thread and
+post written by the user, removes the posts
+from the keyword index and bans the account.
+Until the undo window (Purge.UndoHours) has
+passed, "unpurge <username>" puts everything
+back the way it was. After that, the threads
+and posts are deleted from the database.
+
+DBPURGES key=username sub-bucket:
+by=username of moderator
+time=time.MarshalText() of the purge
+banned="1" if the purge banned the account
+threads=sub-bucket thread ID -> previous value of "archived"
+posts=sub-bucket post ID -> previous value of "archived"
+*/
+
+const DefaultPurgeUndoWindow = time.Hour * 72
+
+var PurgeDeletionInterval = time.Hour
+
+var (
+	ErrAlreadyPurged = errors.New("This user has already been purged. Use unpurge first to purge again.")
+	ErrPurgeNotFound = errors.New("This user has not been purged, or the undo window has passed.")
+)
+
+func PurgeUndoWindow() time.Duration {
+	if Configuration.Purge.UndoHours <= 0 {
+		return DefaultPurgeUndoWindow
+	}
+	return Configuration.Purge.UndoHours * time.Hour
+}
+
+type PurgeSummary struct {
+	User         string
+	DryRun       bool
+	Threads      []string // "ID "title"" of each thread
+	Posts        int
+	OtherReplies int  // replies by other users in the user's threads
+	Banned       bool // the account is banned by this purge
+	Deletion     time.Time
+}
+
+func (p PurgeSummary) String() string {
+	var lines []string
+	if p.DryRun {
+		ban := "ban the account"
+		if !p.Banned {
+			ban = "keep the existing ban on the account"
+		}
+		lines = append(lines, fmt.Sprintf("Purging %s would archive %d threads and %d posts, remove the posts from search, and %s.", p.User, len(p.Threads), p.Posts, ban))
+	} else {
+		lines = append(lines, fmt.Sprintf("Purged %s: archived %d threads and %d posts, and removed the posts from search.", p.User, len(p.Threads), p.Posts))
+		if p.Banned {
+			lines = append(lines, "The account has been banned.")
+		}
+		lines = append(lines, fmt.Sprintf("Everything will be deleted after %s unless \"unpurge %s\" is used before then.", p.Deletion.UTC().Format(time.RFC1123), p.User))
+	}
+	if p.OtherReplies != 0 {
+		lines = append(lines, fmt.Sprintf("The threads include %d replies by other users, which are archived with them.", p.OtherReplies))
+	}
+	if len(p.Threads) != 0 {
+		lines = append(lines, "", "Threads:")
+		lines = append(lines, p.Threads...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func PurgeUser(username, by string, dryRun bool) (p PurgeSummary, err error) {
+	p.User = username
+	p.DryRun = dryRun
+	p.Deletion = time.Now().Add(PurgeUndoWindow())
+
+	var unindex [][]byte
+	purge := func(tx *bolt.Tx) error {
+		if tx.Bucket(DBUSERS).Bucket([]byte(username)) == nil {
+			return ErrUserNotFound
+		}
+		purges := tx.Bucket(DBPURGES)
+		if purges.Bucket([]byte(username)) != nil {
+			return ErrAlreadyPurged
+		}
+		var record, threadsRecord, postsRecord *bolt.Bucket
+		if !dryRun {
+			var err error
+			if record, err = purges.CreateBucket([]byte(username)); err != nil {
+				return err
+			}
+			if threadsRecord, err = record.CreateBucket([]byte("threads")); err != nil {
+				return err
+			}
+			if postsRecord, err = record.CreateBucket([]byte("posts")); err != nil {
+				return err
+			}
+			now, err := time.Now().MarshalText()
+			if err != nil {
+				return err
+			}
+			record.Put([]byte("by"), []byte(by))
+			record.Put([]byte("time"), now)
+		}
+
+		threads := tx.Bucket(DBALLTHREADS)
+		posts := tx.Bucket(DBALLPOSTS)
+		if byUser := tx.Bucket(DBUSERTHREADS).Bucket([]byte(username)); byUser != nil {
+			if err := byUser.ForEach(func(k, id []byte) error {
+				thread := threads.Bucket(id)
+				if thread == nil {
+					// deleted
+					return nil
+				}
+				p.Threads = append(p.Threads, fmt.Sprintf("%s %q", id, thread.Get([]byte("title"))))
+				thread.Bucket([]byte("posts")).ForEach(func(k, postID []byte) error {
+					if post := posts.Bucket(postID); post != nil && string(post.Get([]byte("user"))) != username {
+						p.OtherReplies++
+					}
+					return nil
+				})
+				if dryRun {
+					return nil
+				}
+				if err := threadsRecord.Put(id, append([]byte{}, thread.Get([]byte("archived"))...)); err != nil {
+					return err
+				}
+				return thread.Put([]byte("archived"), []byte("1"))
+			}); err != nil {
+				return err
+			}
+		}
+		if byUser := tx.Bucket(DBUSERPOSTS).Bucket([]byte(username)); byUser != nil {
+			if err := byUser.ForEach(func(k, id []byte) error {
+				post := posts.Bucket(id)
+				if post == nil {
+					// deleted
+					return nil
+				}
+				p.Posts++
+				if dryRun {
+					return nil
+				}
+				unindex = append(unindex, append([]byte{}, id...))
+				if err := postsRecord.Put(id, append([]byte{}, post.Get([]byte("archived"))...)); err != nil {
+					return err
+				}
+				return post.Put([]byte("archived"), []byte("1"))
+			}); err != nil {
+				return err
+			}
+		}
+
+		/*
+			An existing ban (such as a temporary
+			one) is kept, and is not removed by
+			unpurge.
+		*/
+		if _, banned := IsUserBannedTx(tx, username); banned {
+			return nil
+		}
+		p.Banned = true
+		if dryRun {
+			return nil
+		}
+		if err := addBanTx(tx, BanUser, username, by, 0, "purged"); err != nil {
+			return err
+		}
+		return record.Put([]byte("banned"), []byte("1"))
+	}
+
+	if dryRun {
+		err = db.View(purge)
+		return
+	}
+	if err = db.Update(purge); err != nil {
+		return
+	}
+	for _, id := range unindex {
+		removePostFromKeywordDB(id)
+	}
+	return
+}
+
+func UnpurgeUser(username string) error {
+	var reindex []KeywordIndex
+	if err := db.Update(func(tx *bolt.Tx) error {
+		purges := tx.Bucket(DBPURGES)
+		record := purges.Bucket([]byte(username))
+		if record == nil {
+			return ErrPurgeNotFound
+		}
+		threads := tx.Bucket(DBALLTHREADS)
+		if err := record.Bucket([]byte("threads")).ForEach(func(id, archived []byte) error {
+			if thread := threads.Bucket(id); thread != nil {
+				return thread.Put([]byte("archived"), append([]byte{}, archived...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		posts := tx.Bucket(DBALLPOSTS)
+		if err := record.Bucket([]byte("posts")).ForEach(func(id, archived []byte) error {
+			post := posts.Bucket(id)
+			if post == nil {
+				return nil
+			}
+			reindex = append(reindex, makeKeywordIndex(string(post.Get([]byte("user"))), string(post.Get([]byte("text"))), id, post.Get([]byte("thread"))))
+			return post.Put([]byte("archived"), append([]byte{}, archived...))
+		}); err != nil {
+			return err
+		}
+		if bytes.Equal(record.Get([]byte("banned")), []byte("1")) {
+			if bans := tx.Bucket(DBBANS).Bucket([]byte(BanUser)); bans != nil && bans.Bucket([]byte(username)) != nil {
+				if err := bans.DeleteBucket([]byte(username)); err != nil {
+					return err
+				}
+			}
+		}
+		return purges.DeleteBucket([]byte(username))
+	}); err != nil {
+		return err
+	}
+	for _, post := range reindex {
+		sendPostToKeywordDB(post.Author, post.Text, post.ID, post.ThreadID)
+	}
+	return nil
+}
+
+type PendingPurge struct {
+	User     string
+	By       string
+	Time     time.Time
+	Threads  int
+	Posts    int
+	Deletion time.Time
+}
+
+func (p PendingPurge) String() string {
+	return fmt.Sprintf("%s - by %s on %s, %d threads and %d posts, deleted after %s", p.User, p.By, p.Time.UTC().Format(time.RFC1123), p.Threads, p.Posts, p.Deletion.UTC().Format(time.RFC1123))
+}
+
+func ListPurges() (purges []PendingPurge, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		all := tx.Bucket(DBPURGES)
+		return all.ForEach(func(k, v []byte) error {
+			record := all.Bucket(k)
+			if record == nil {
+				return nil
+			}
+			p := PendingPurge{
+				User:    string(k),
+				By:      string(record.Get([]byte("by"))),
+				Threads: countKeys(record.Bucket([]byte("threads"))),
+				Posts:   countKeys(record.Bucket([]byte("posts"))),
+			}
+			if err := p.Time.UnmarshalText(record.Get([]byte("time"))); err != nil {
+				return err
+			}
+			p.Deletion = p.Time.Add(PurgeUndoWindow())
+			purges = append(purges, p)
+			return nil
+		})
+	})
+	return
+}
+
+func deletePostTx(tx *bolt.Tx, id []byte) (deleted bool, err error) {
+	/*
+		Remove a post from DBALLPOSTS, its
+		thread, and its author's posts.
+	*/
+	posts := tx.Bucket(DBALLPOSTS)
+	post := posts.Bucket(id)
+	if post == nil {
+		return false, nil
+	}
+	if thread := tx.Bucket(DBALLTHREADS).Bucket(post.Get([]byte("thread"))); thread != nil {
+		if threadPosts := thread.Bucket([]byte("posts")); threadPosts != nil {
+			if err := threadPosts.Delete(post.Get([]byte("index"))); err != nil {
+				return false, err
+			}
+		}
+	}
+	if byUser := tx.Bucket(DBUSERPOSTS).Bucket(post.Get([]byte("user"))); byUser != nil {
+		if err := removeValues(byUser, [][]byte{id}); err != nil {
+			return false, err
+		}
+	}
+	return true, posts.DeleteBucket(id)
+}
+
+func deleteThreadTx(tx *bolt.Tx, id []byte) (deletedPosts [][]byte, err error) {
+	/*
+		Remove a thread and every post in it,
+		including replies by other users.
+	*/
+	threads := tx.Bucket(DBALLTHREADS)
+	thread := threads.Bucket(id)
+	if thread == nil {
+		return nil, nil
+	}
+	var postIDs [][]byte
+	thread.Bucket([]byte("posts")).ForEach(func(k, postID []byte) error {
+		postIDs = append(postIDs, append([]byte{}, postID...))
+		return nil
+	})
+	for _, postID := range postIDs {
+		deleted, err := deletePostTx(tx, postID)
+		if err != nil {
+			return nil, err
+		}
+		if deleted {
+			deletedPosts = append(deletedPosts, postID)
+		}
+	}
+
+	threadToSubforum := tx.Bucket(DBTHREADTOSF)
+	if subforum := tx.Bucket(DBSUBFORUMS).Bucket(threadToSubforum.Get(id)); subforum != nil {
+		if err := removeValues(subforum, [][]byte{id}); err != nil {
+			return nil, err
+		}
+	}
+	if byUser := tx.Bucket(DBUSERTHREADS).Bucket(thread.Get([]byte("user"))); byUser != nil {
+		if err := removeValues(byUser, [][]byte{id}); err != nil {
+			return nil, err
+		}
+	}
+	if err := threadToSubforum.Delete(id); err != nil {
+		return nil, err
+	}
+	return deletedPosts, threads.DeleteBucket(id)
+}
+
+func DeleteExpiredPurges(olderThan time.Duration) (deleted []string, err error) {
+	/*
+		Delete the threads and posts of every
+		purge made more than olderThan ago.
+		Posts which were restored since the
+		purge (archived is no longer "1") are
+		kept.
+	*/
+	var unindex [][]byte
+	err = db.Update(func(tx *bolt.Tx) error {
+		purges := tx.Bucket(DBPURGES)
+		if err := purges.ForEach(func(k, v []byte) error {
+			record := purges.Bucket(k)
+			if record == nil {
+				return nil
+			}
+			var t time.Time
+			if err := t.UnmarshalText(record.Get([]byte("time"))); err != nil {
+				return err
+			}
+			if time.Since(t) > olderThan {
+				deleted = append(deleted, string(k))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		threads := tx.Bucket(DBALLTHREADS)
+		posts := tx.Bucket(DBALLPOSTS)
+		for _, username := range deleted {
+			record := purges.Bucket([]byte(username))
+			var threadIDs, postIDs [][]byte
+			record.Bucket([]byte("threads")).ForEach(func(id, v []byte) error {
+				if thread := threads.Bucket(id); thread != nil && IsThreadArchived(thread) {
+					threadIDs = append(threadIDs, append([]byte{}, id...))
+				}
+				return nil
+			})
+			record.Bucket([]byte("posts")).ForEach(func(id, v []byte) error {
+				if post := posts.Bucket(id); post != nil && bytes.Equal(post.Get([]byte("archived")), []byte("1")) {
+					postIDs = append(postIDs, append([]byte{}, id...))
+				}
+				return nil
+			})
+			for _, id := range threadIDs {
+				removed, err := deleteThreadTx(tx, id)
+				if err != nil {
+					return err
+				}
+				unindex = append(unindex, removed...)
+			}
+			for _, id := range postIDs {
+				if _, err := deletePostTx(tx, id); err != nil {
+					return err
+				}
+			}
+			if err := purges.DeleteBucket([]byte(username)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	/*
+		Replies by other users were still
+		in the keyword index.
+	*/
+	for _, id := range unindex {
+		removePostFromKeywordDB(id)
+	}
+	return
+}
+
+func removeValues(b *bolt.Bucket, values [][]byte) error {
+	/*
+		Delete every key with one of these
+		values. Keys can not be deleted
+		during ForEach, so they are
+		collected first.
+	*/
+	var keys [][]byte
+	b.ForEach(func(k, v []byte) error {
+		for _, value := range values {
+			if bytes.Equal(v, value) {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+		return nil
+	})
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runPurgeDeletion() {
+	deleted, err := DeleteExpiredPurges(PurgeUndoWindow())
+	if err != nil {
+		log.Printf("Error while deleting purged content: %s\n", err.Error())
+		return
+	}
+	if len(deleted) != 0 {
+		log.Printf("Deleted the purged content of %d users: %s\n", len(deleted), strings.Join(deleted, " "))
+	}
+}
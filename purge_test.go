@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestPurgeUser(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+		Roles: map[string][]Capability{
+			"banner": {CapConsole, CapBan},
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestPurgeUser.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, username := range []string{"alice", "bob", "carol"} {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.Bucket(DBUSERS).CreateBucket([]byte(username))
+			return err
+		}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	OnNewThread("firstsub", "bob", "cheap pills", "buy now")     // thread 1, post 1
+	OnNewPost("carol", "0000000000000001", "go away", false)     // post 2
+	OnNewThread("firstsub", "carol", "hello", "first post")      // thread 2, post 3
+	OnNewPost("bob", "0000000000000002", "buy now", false)       // post 4
+	OnNewPost("carol", "0000000000000002", "second post", false) // post 5
+
+	threadIDs := func() (ids []string) {
+		threads, err := GetThreadsForSubforum("firstsub", ThreadSortOldest)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, thread := range threads {
+			ids = append(ids, string(thread.ID))
+		}
+		return
+	}
+	visiblePosts := func() (ids []string) {
+		db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(DBALLPOSTS).ForEach(func(k, v []byte) error {
+				if !IsPostHidden(tx.Bucket(DBALLPOSTS).Bucket(k)) {
+					ids = append(ids, string(k))
+				}
+				return nil
+			})
+		})
+		return
+	}
+	isBanned := func() (banned bool) {
+		db.View(func(tx *bolt.Tx) error {
+			_, banned = IsUserBannedTx(tx, "bob")
+			return nil
+		})
+		return
+	}
+
+	/*
+		Nothing is changed by a dry run
+	*/
+	response, status := ConsoleCommand("alice", Admin, "purge bob dryrun")
+	if status != gemini.Success || response != "Purging bob would archive 1 threads and 2 posts, remove the posts from search, and ban the account.\nThe threads include 1 replies by other users, which are archived with them.\n\nThreads:\n0000000000000001 \"cheap pills\"" {
+		t.Errorf("Recieved %d %q", status, response)
+	}
+	if ids := strings.Join(threadIDs(), " "); ids != "0000000000000001 0000000000000002" || isBanned() {
+		t.Fatalf("Dry run changed the database: %s", ids)
+	}
+
+	response, status = ConsoleCommand("alice", Admin, "purge bob")
+	if status != gemini.Success || !strings.HasPrefix(response, "Purged bob: archived 1 threads and 2 posts, and removed the posts from search.\nThe account has been banned.\n") {
+		t.Errorf("Recieved %d %q", status, response)
+	}
+	if ids := strings.Join(threadIDs(), " "); ids != "0000000000000002" {
+		t.Errorf("Incorrect threads after purge: %s", ids)
+	}
+	if ids := strings.Join(visiblePosts(), " "); ids != "0000000000000002 0000000000000003 0000000000000005" {
+		t.Errorf("Incorrect posts after purge: %s", ids)
+	}
+	if !isBanned() {
+		t.Error("Expected bob to be banned")
+	}
+	if _, err := PurgeUser("bob", "alice", false); !errors.Is(err, ErrAlreadyPurged) {
+		t.Errorf("Expected ErrAlreadyPurged, recieved %v", err)
+	}
+	if purges, err := ListPurges(); err != nil || len(purges) != 1 || purges[0].User != "bob" || purges[0].Threads != 1 || purges[0].Posts != 2 {
+		t.Errorf("Incorrect purges: %+v %v", purges, err)
+	}
+	if resp := OnNewPost("carol", "0000000000000001", "hello?", false); !strings.HasPrefix(string(resp.Bytes()), "4") {
+		t.Errorf("Expected replying to a purged thread to fail, recieved %q", resp.Bytes())
+	}
+
+	/*
+		Undoing a purge needs the same
+		capabilities as purging
+	*/
+	if err := AssignRole("carol", "banner", GlobalScope); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, command := range []string{"purge bob", "unpurge bob"} {
+		if response, status := ConsoleCommand("carol", User, command); status != gemini.CertificateNotAuthorised {
+			t.Errorf("%s: recieved %d %q", command, status, response)
+		}
+	}
+
+	/*
+		Undo
+	*/
+	if response, status := ConsoleCommand("alice", Admin, "unpurge bob"); status != gemini.Success {
+		t.Fatalf("Recieved %d %q", status, response)
+	}
+	if ids := strings.Join(threadIDs(), " "); ids != "0000000000000001 0000000000000002" {
+		t.Errorf("Incorrect threads after unpurge: %s", ids)
+	}
+	if ids := strings.Join(visiblePosts(), " "); ids != "0000000000000001 0000000000000002 0000000000000003 0000000000000004 0000000000000005" {
+		t.Errorf("Incorrect posts after unpurge: %s", ids)
+	}
+	if isBanned() {
+		t.Error("Expected the ban to be removed")
+	}
+
+	/*
+		Deleted after the undo window
+	*/
+	if _, err := PurgeUser("bob", "alice", false); err != nil {
+		t.Fatal(err.Error())
+	}
+	if deleted, err := DeleteExpiredPurges(time.Hour); err != nil || len(deleted) != 0 {
+		t.Fatalf("Expected nothing to be deleted, recieved %v %v", deleted, err)
+	}
+	if deleted, err := DeleteExpiredPurges(0); err != nil || strings.Join(deleted, " ") != "bob" {
+		t.Fatalf("Recieved %v %v", deleted, err)
+	}
+	if err := UnpurgeUser("bob"); !errors.Is(err, ErrPurgeNotFound) {
+		t.Errorf("Expected ErrPurgeNotFound, recieved %v", err)
+	}
+	if ids := strings.Join(threadIDs(), " "); ids != "0000000000000002" {
+		t.Errorf("Incorrect threads after deletion: %s", ids)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := countKeys(tx.Bucket(DBALLPOSTS)); n != 2 {
+			t.Errorf("Expected 2 posts, recieved %d", n)
+		}
+		if n := countKeys(tx.Bucket(DBUSERPOSTS).Bucket([]byte("carol"))); n != 2 {
+			t.Errorf("Expected 2 posts by carol, recieved %d", n)
+		}
+		if n := countKeys(tx.Bucket(DBALLTHREADS).Bucket([]byte("0000000000000002")).Bucket([]byte("posts"))); n != 2 {
+			t.Errorf("Expected 2 posts in thread 2, recieved %d", n)
+		}
+		if n := countKeys(tx.Bucket(DBUSERTHREADS).Bucket([]byte("bob"))); n != 0 {
+			t.Errorf("Expected no threads by bob, recieved %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !isBanned() {
+		t.Error("Expected bob to stay banned")
+	}
+}
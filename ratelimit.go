@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	"github.com/coinpaprika/ratelimiter"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Rate limits
+
+Each route belongs to a class, which has its own
+limit. Clients which are logged in are counted by
+username, and others by network (see
+ClientPrefixLength in bans.go). Moderators,
+admins and users listed in RateLimit.Trusted are
+not limited.
+
+Counters are kept in memory, and written to
+DBRATELIMITS every RateLimitFlushInterval and on
+shutdown so that they survive restarts:
+key=<class>:user:<username>_<window (RFC3339)> or
+<class>:ip:<network>_<window> val=count
+*/
+
+const (
+	RateRead     = "read"
+	RateSearch   = "search"
+	RatePost     = "post"
+	RateRegister = "register"
+	RateLogin    = "login"
+	RateReport   = "report"
+	RateAppeal   = "appeal"
+)
+
+var RateClasses = []string{RateRead, RateSearch, RatePost, RateRegister, RateLogin, RateReport, RateAppeal}
+
+/*
+Used when a class is not in the configuration
+file. The read limit defaults to
+LimitConnections and LimitWindow.
+*/
+var defaultRateLimits = map[string]ConfigRateLimitClass{
+	RateRead:     {Requests: 5, Window: 15},
+	RateSearch:   {Requests: 10, Window: 60},
+	RatePost:     {Requests: 10, Window: 300},
+	RateRegister: {Requests: 10, Window: 3600},
+	RateLogin:    {Requests: 10, Window: 600},
+	RateReport:   {Requests: 5, Window: 600},
+	RateAppeal:   {Requests: 10, Window: 3600},
+}
+
+const (
+	RateLimitExpiry        = 2 * time.Hour // longest window kept in the store
+	RateLimitFlushInterval = 10 * time.Minute
+)
+
+var ErrInvalidRateClass = errors.New("Invalid rate limit class (must be read, search, post, register, login, report or appeal)")
+
+var (
+	rateLimitStore *BoltLimitStore
+	rateLimiters   map[string]*ratelimiter.RateLimiter
+)
+
+func RouteRateClass(u *url.URL) string {
+	path := u.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, "/register/"):
+		return RateRegister
+	case strings.HasPrefix(path, "/login/"):
+		return RateLogin
+	case strings.HasPrefix(path, "/search/"):
+		return RateSearch
+	case strings.HasPrefix(path, "/new/"):
+		return RatePost
+	case strings.HasPrefix(path, "/report/"):
+		return RateReport
+	case (strings.HasPrefix(path, "/appeal/new/") || strings.HasPrefix(path, "/appeal/login/")) && u.RawQuery != "":
+		// only submitted appeals and passwords, not checking on an appeal
+		return RateAppeal
+	}
+	return RateRead
+}
+
+func ValidateRateLimits(limits map[string]ConfigRateLimitClass) error {
+	for class := range limits {
+		if _, ok := defaultRateLimits[strings.ToLower(class)]; !ok {
+			return ErrInvalidRateClass
+		}
+	}
+	return nil
+}
+
+func RateLimitFor(class string) ConfigRateLimitClass {
+	for name, limit := range Configuration.RateLimit.Class {
+		if strings.EqualFold(name, class) && limit.Requests > 0 && limit.Window > 0 {
+			return limit
+		}
+	}
+	if class == RateRead && Configuration.LimitConnections > 0 && Configuration.LimitWindow > 0 {
+		return ConfigRateLimitClass{Requests: Configuration.LimitConnections, Window: Configuration.LimitWindow}
+	}
+	return defaultRateLimits[class]
+}
+
+func InitRateLimits() {
+	rateLimitStore = NewBoltLimitStore(RateLimitExpiry, RateLimitFlushInterval)
+	rateLimiters = map[string]*ratelimiter.RateLimiter{}
+	for _, class := range RateClasses {
+		limit := RateLimitFor(class)
+		rateLimiters[class] = ratelimiter.New(rateLimitStore, limit.Requests, time.Second*limit.Window)
+	}
+	emailResendLimiter = ratelimiter.New(rateLimitStore, 1, time.Minute)
+}
+
+func IsRateLimitExempt(username string, priv UserPriviledge) bool {
+	if username == "" {
+		return false
+	}
+	if priv.Is(Mod) {
+		return true
+	}
+	for _, trusted := range Configuration.RateLimit.Trusted {
+		if trusted == username {
+			return true
+		}
+	}
+	return false
+}
+
+func CheckRateLimit(class, ip, username string, priv UserPriviledge) gemini.Response {
+	/*
+		Returns nil if this request may continue,
+		and counts it.
+	*/
+	limiter := rateLimiters[class]
+	if limiter == nil || IsRateLimitExempt(username, priv) {
+		return nil
+	}
+	key := fmt.Sprintf("%s:ip:%s", class, ClientNetwork(ip))
+	if username != "" {
+		key = fmt.Sprintf("%s:user:%s", class, username)
+	}
+	stat, err := limiter.Check(key)
+	if err != nil {
+		log.Println(err.Error())
+		return InternalError
+	}
+	if stat.IsLimited {
+		// meta = number of seconds to wait (integer rounded up)
+		return gemini.ResponseFormat{
+			Status: gemini.SlowDown,
+			Mime:   fmt.Sprintf("%d", int(stat.LimitDuration.Seconds())+1),
+			Lines:  nil,
+		}
+	}
+	if err := limiter.Inc(key); err != nil {
+		log.Println(err.Error())
+		return InternalError
+	}
+	return nil
+}
+
+/*
+BoltLimitStore implements ratelimiter.LimitStore
+*/
+type BoltLimitStore struct {
+	expirationTime time.Duration
+	mutex          sync.Mutex
+	counts         map[string]int64 // key=boltLimitKey
+	changed        map[string]bool  // not yet written to the database
+}
+
+func NewBoltLimitStore(expirationTime, flushInterval time.Duration) *BoltLimitStore {
+	/*
+		Counters are written to the database, and
+		counters for windows older than
+		expirationTime are removed, every
+		flushInterval.
+	*/
+	s := &BoltLimitStore{expirationTime: expirationTime}
+	if err := s.Load(); err != nil {
+		log.Printf("Error while loading rate limits: %s\n", err.Error())
+	}
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		for range ticker.C {
+			if err := s.Flush(time.Now()); err != nil {
+				log.Printf("Error while flushing rate limits: %s\n", err.Error())
+			}
+		}
+	}()
+	return s
+}
+
+func boltLimitKey(key string, window time.Time) string {
+	return fmt.Sprintf("%s_%s", key, window.UTC().Format(time.RFC3339))
+}
+
+func boltLimitExpired(k string, now time.Time, expirationTime time.Duration) bool {
+	i := strings.LastIndexByte(k, '_')
+	if i == -1 {
+		return true
+	}
+	window, err := time.Parse(time.RFC3339, k[i+1:])
+	return err != nil || now.Sub(window) > expirationTime
+}
+
+func (s *BoltLimitStore) Load() error {
+	/*
+		Read the counters written before
+		the last restart.
+	*/
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.counts = map[string]int64{}
+	s.changed = map[string]bool{}
+	return db.View(func(tx *bolt.Tx) error {
+		limits := tx.Bucket(DBRATELIMITS)
+		if limits == nil {
+			return nil
+		}
+		return limits.ForEach(func(k, v []byte) error {
+			s.counts[string(k)], _ = strconv.ParseInt(string(v), 10, 64)
+			return nil
+		})
+	})
+}
+
+func (s *BoltLimitStore) Inc(key string, window time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.counts == nil {
+		s.counts = map[string]int64{}
+		s.changed = map[string]bool{}
+	}
+	k := boltLimitKey(key, window)
+	s.counts[k]++
+	s.changed[k] = true
+	return nil
+}
+
+func (s *BoltLimitStore) Get(key string, previousWindow, currentWindow time.Time) (prevValue int64, currValue int64, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.counts[boltLimitKey(key, previousWindow)], s.counts[boltLimitKey(key, currentWindow)], nil
+}
+
+func (s *BoltLimitStore) Flush(now time.Time) error {
+	/*
+		Remove expired counters, and write the
+		changed ones to the database.
+	*/
+	s.mutex.Lock()
+	changed := map[string]int64{}
+	for k, count := range s.counts {
+		if boltLimitExpired(k, now, s.expirationTime) {
+			delete(s.counts, k)
+		} else if s.changed[k] {
+			changed[k] = count
+		}
+	}
+	s.changed = map[string]bool{}
+	s.mutex.Unlock()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		limits, err := tx.CreateBucketIfNotExists(DBRATELIMITS)
+		if err != nil {
+			return err
+		}
+		var expired [][]byte
+		limits.ForEach(func(k, v []byte) error {
+			if boltLimitExpired(string(k), now, s.expirationTime) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := limits.Delete(k); err != nil {
+				return err
+			}
+		}
+		for k, count := range changed {
+			if err := limits.Put([]byte(k), []byte(strconv.FormatInt(count, 10))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
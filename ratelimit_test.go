@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	"github.com/coinpaprika/ratelimiter"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRouteRateClass(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/":                         RateRead,
+		"/thread/01/":               RateRead,
+		"/search/":                  RateSearch,
+		"/new/post/01/":             RatePost,
+		"/new/thread/sf/":           RatePost,
+		"/register/alice/":          RateRegister,
+		"/login/alice/":             RateLogin,
+		"/report/0000000001":        RateReport,
+		"/appeal/new/?text":         RateAppeal,
+		"/appeal/login/bob/?secret": RateAppeal,
+		"/appeal/new/":              RateRead,
+		"/appeal/":                  RateRead,
+	} {
+		u, err := url.Parse(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if class := RouteRateClass(u); class != expected {
+			t.Errorf("%s: expected %s, recieved %s", path, expected, class)
+		}
+	}
+
+	Configuration = &ConfigStr{
+		LimitConnections: 3,
+		LimitWindow:      7,
+		RateLimit: ConfigRateLimit{Class: map[string]ConfigRateLimitClass{
+			"Search": {Requests: 1, Window: 2},
+		}},
+	}
+	for class, expected := range map[string]ConfigRateLimitClass{
+		RateRead:   {Requests: 3, Window: 7},
+		RateSearch: {Requests: 1, Window: 2},
+		RateLogin:  defaultRateLimits[RateLogin],
+	} {
+		if limit := RateLimitFor(class); limit != expected {
+			t.Errorf("%s: expected %+v, recieved %+v", class, expected, limit)
+		}
+	}
+	if err := ValidateRateLimits(map[string]ConfigRateLimitClass{"pages": {}}); err != ErrInvalidRateClass {
+		t.Errorf("Expected ErrInvalidRateClass, recieved %v", err)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	Configuration = &ConfigStr{
+		Priviledges: map[string]UserPriviledge{
+			"carol": Mod,
+		},
+		RateLimit: ConfigRateLimit{Trusted: []string{"bob"}},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestRateLimits.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	store := &BoltLimitStore{expirationTime: RateLimitExpiry}
+	rateLimiters = map[string]*ratelimiter.RateLimiter{
+		RateRead:   ratelimiter.New(store, 100, time.Hour),
+		RateSearch: ratelimiter.New(store, 1, time.Hour),
+	}
+	defer func() { rateLimiters = nil }()
+
+	serv := gemtest.Testd(t, handler, 3)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/carol/carol%40example.net/?password", Cert: 3, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/carol/?password", Cert: 3, Response: []byte("30 /\r\n")},
+
+		/*
+			alice is counted by username, so the
+			search by the client without a
+			certificate (same IP) is not limited.
+		*/
+		gemtest.Input{URL: "gemini://localhost/search/", Cert: 1, Response: []byte("10 keyword or @username search\r\n")},
+		gemtest.Input{URL: "gemini://localhost/search/", Cert: 0, Response: []byte("10 keyword or @username search\r\n")},
+
+		// trusted user and moderator
+		gemtest.Input{URL: "gemini://localhost/search/", Cert: 2, Response: []byte("10 keyword or @username search\r\n")},
+		gemtest.Input{URL: "gemini://localhost/search/", Cert: 2, Response: []byte("10 keyword or @username search\r\n")},
+		gemtest.Input{URL: "gemini://localhost/search/", Cert: 3, Response: []byte("10 keyword or @username search\r\n")},
+		gemtest.Input{URL: "gemini://localhost/search/", Cert: 3, Response: []byte("10 keyword or @username search\r\n")},
+	)
+
+	/*
+		Counters are written to the database
+		when flushed
+	*/
+	if err := store.Flush(time.Now()); err != nil {
+		t.Fatal(err.Error())
+	}
+	store = &BoltLimitStore{expirationTime: RateLimitExpiry}
+	if err := store.Load(); err != nil {
+		t.Fatal(err.Error())
+	}
+	rateLimiters[RateSearch] = ratelimiter.New(store, 1, time.Hour)
+	_, count, err := store.Get("search:user:alice", time.Now().UTC().Truncate(time.Hour).Add(-time.Hour), time.Now().UTC().Truncate(time.Hour))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Expected count 1, recieved %d", count)
+	}
+	if stat, err := rateLimiters[RateSearch].Check("search:user:alice"); err != nil || !stat.IsLimited {
+		t.Errorf("Expected alice to be limited: %+v %v", stat, err)
+	}
+	if _, count, _ := store.Get("search:user:bob", time.Time{}, time.Now().UTC().Truncate(time.Hour)); count != 0 {
+		t.Errorf("Trusted user was counted %d times", count)
+	}
+
+	if err := store.Flush(time.Now().Add(RateLimitExpiry + 2*time.Hour)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, count, _ := store.Get("search:user:alice", time.Time{}, time.Now().UTC().Truncate(time.Hour)); count != 0 {
+		t.Errorf("Expected expired counter to be removed, recieved %d", count)
+	}
+}
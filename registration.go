@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Values for Configuration.Registration.Mode
+*/
+const (
+	RegistrationOpen     = "open"     // anyone may register (default)
+	RegistrationClosed   = "closed"   // nobody may register
+	RegistrationInvite   = "invite"   // an invite code is required
+	RegistrationApproval = "approval" // a moderator approves new accounts
+)
+
+var (
+	ErrRegistrationClosed   = errors.New("Registration is closed.")
+	ErrInvalidRegistration  = errors.New("Invalid registration mode. Allowed values: open/closed/invite/approval")
+	ErrInviteNotFound       = errors.New("Invite code not found.")
+	ErrInviteExpired        = errors.New("Invite code has expired.")
+	ErrInviteUsedUp         = errors.New("Invite code has already been used.")
+	ErrUserNotPending       = errors.New("User is not awaiting approval.")
+	ErrAwaitingApproval     = errors.New("Account is awaiting moderator approval")
+	ErrInvalidInviteCommand = errors.New("Invalid field: number of uses and days must be numbers > 0.")
+)
+
+func RegistrationMode() string {
+	if Configuration.Registration.Mode == "" {
+		return RegistrationOpen
+	}
+	return strings.ToLower(Configuration.Registration.Mode)
+}
+
+func ValidateRegistrationMode(mode string) error {
+	switch strings.ToLower(mode) {
+	case "", RegistrationOpen, RegistrationClosed, RegistrationInvite, RegistrationApproval:
+		return nil
+	}
+	return ErrInvalidRegistration
+}
+
+func updateVerified(user *bolt.Bucket) error {
+	/*
+		An account is verified once its email
+		address has been confirmed and, if it was
+		registered in approval mode, once a
+		moderator has approved it.
+	*/
+	if !bytes.Equal(user.Get([]byte("emailverified")), []byte("1")) {
+		return nil
+	}
+	if bytes.Equal(user.Get([]byte("approved")), []byte("0")) {
+		return nil
+	}
+	return user.Put([]byte("verified"), []byte("1"))
+}
+
+func IsAwaitingApproval(user *bolt.Bucket) bool {
+	return bytes.Equal(user.Get([]byte("approved")), []byte("0"))
+}
+
+/*
+Invite codes
+
+Each code in DBINVITES is a sub-bucket:
+creator=username of moderator
+created=time.Now().MarshalText()
+expires=time.MarshalText() ("" = never)
+maxuses=number of accounts that may use this code
+users=sub-bucket of usernames that used this code
+*/
+
+type Invite struct {
+	Code    string
+	Creator string
+	Created time.Time
+	Expires time.Time // zero = never
+	MaxUses int
+	Uses    int
+}
+
+func (i Invite) String() string {
+	expires := "never"
+	if !i.Expires.IsZero() {
+		expires = i.Expires.UTC().Format(time.RFC1123)
+	}
+	return fmt.Sprintf("%s - by %s, used %d/%d, expires %s", i.Code, i.Creator, i.Uses, i.MaxUses, expires)
+}
+
+func CreateInvite(creator string, maxUses, days int) (code string, err error) {
+	if maxUses <= 0 || days < 0 {
+		return "", ErrInvalidInviteCommand
+	}
+	/*
+		Invite codes grant registration, so
+		they must not be predictable.
+	*/
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	code = hex.EncodeToString(random)
+	err = db.Update(func(tx *bolt.Tx) error {
+		invite, err := tx.Bucket(DBINVITES).CreateBucket([]byte(code))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		nowBytes, err := now.MarshalText()
+		if err != nil {
+			return err
+		}
+		var expires []byte
+		if days > 0 {
+			expires, err = now.Add(time.Hour * 24 * time.Duration(days)).MarshalText()
+			if err != nil {
+				return err
+			}
+		}
+		invite.Put([]byte("creator"), []byte(creator))
+		invite.Put([]byte("created"), nowBytes)
+		invite.Put([]byte("expires"), expires)
+		invite.Put([]byte("maxuses"), []byte(strconv.Itoa(maxUses)))
+		_, err = invite.CreateBucket([]byte("users"))
+		return err
+	})
+	return
+}
+
+func readInvite(code []byte, invite *bolt.Bucket) (i Invite, err error) {
+	i.Code = string(code)
+	i.Creator = string(invite.Get([]byte("creator")))
+	if err = i.Created.UnmarshalText(invite.Get([]byte("created"))); err != nil {
+		return
+	}
+	if expires := invite.Get([]byte("expires")); len(expires) != 0 {
+		if err = i.Expires.UnmarshalText(expires); err != nil {
+			return
+		}
+	}
+	if i.MaxUses, err = strconv.Atoi(string(invite.Get([]byte("maxuses")))); err != nil {
+		return
+	}
+	if users := invite.Bucket([]byte("users")); users != nil {
+		i.Uses = users.Stats().KeyN
+	}
+	return
+}
+
+func checkInviteTx(tx *bolt.Tx, code string) (*bolt.Bucket, error) {
+	invite := tx.Bucket(DBINVITES).Bucket([]byte(code))
+	if invite == nil {
+		return nil, ErrInviteNotFound
+	}
+	i, err := readInvite([]byte(code), invite)
+	if err != nil {
+		return nil, err
+	}
+	if !i.Expires.IsZero() && time.Now().After(i.Expires) {
+		return nil, ErrInviteExpired
+	}
+	if i.Uses >= i.MaxUses {
+		return nil, ErrInviteUsedUp
+	}
+	return invite, nil
+}
+
+func CheckInvite(code string) error {
+	return db.View(func(tx *bolt.Tx) error {
+		_, err := checkInviteTx(tx, code)
+		return err
+	})
+}
+
+func useInviteTx(tx *bolt.Tx, code, username string) error {
+	invite, err := checkInviteTx(tx, code)
+	if err != nil {
+		return err
+	}
+	return invite.Bucket([]byte("users")).Put([]byte(username), []byte("1"))
+}
+
+func ListInvites() (invites []Invite, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		all := tx.Bucket(DBINVITES)
+		return all.ForEach(func(k, v []byte) error {
+			invite := all.Bucket(k)
+			if invite == nil {
+				return nil
+			}
+			i, err := readInvite(k, invite)
+			if err != nil {
+				return err
+			}
+			invites = append(invites, i)
+			return nil
+		})
+	})
+	return
+}
+
+func RevokeInvite(code string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		all := tx.Bucket(DBINVITES)
+		if all.Bucket([]byte(code)) == nil {
+			return ErrInviteNotFound
+		}
+		return all.DeleteBucket([]byte(code))
+	})
+}
+
+/*
+Accounts awaiting approval
+
+DBPENDING key=username val=time of registration
+*/
+
+type PendingUser struct {
+	Username   string
+	Email      string
+	Registered time.Time
+}
+
+func (p PendingUser) String() string {
+	return fmt.Sprintf("%s <%s> registered %s", p.Username, p.Email, p.Registered.UTC().Format(time.RFC1123))
+}
+
+func ListPendingUsers() (pending []PendingUser, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		users := tx.Bucket(DBUSERS)
+		return tx.Bucket(DBPENDING).ForEach(func(k, v []byte) error {
+			p := PendingUser{Username: string(k)}
+			if err := p.Registered.UnmarshalText(v); err != nil {
+				return err
+			}
+			if user := users.Bucket(k); user != nil {
+				p.Email = string(user.Get([]byte("email")))
+			}
+			pending = append(pending, p)
+			return nil
+		})
+	})
+	return
+}
+
+func ApprovePendingUser(username string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(DBPENDING)
+		if pending.Get([]byte(username)) == nil {
+			return ErrUserNotPending
+		}
+		if err := pending.Delete([]byte(username)); err != nil {
+			return err
+		}
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil {
+			return ErrUserNotFound
+		}
+		if err := user.Put([]byte("approved"), []byte("1")); err != nil {
+			return err
+		}
+		return updateVerified(user)
+	})
+}
+
+func RejectPendingUser(username string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(DBPENDING)
+		if pending.Get([]byte(username)) == nil {
+			return ErrUserNotPending
+		}
+		if err := pending.Delete([]byte(username)); err != nil {
+			return err
+		}
+		return deleteUnverifiedUserTx(tx, username)
+	})
+}
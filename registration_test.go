@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openRegistrationTestDB(t *testing.T, mode string) func() {
+	Configuration = &ConfigStr{
+		Priviledges:  map[string]UserPriviledge{"alice": Mod},
+		Registration: ConfigRegistration{Mode: mode},
+	}
+	var err error
+	var testDBpath string = ".testing/TestRegistrationModes.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+	return func() {
+		db.Close()
+		os.Remove(testDBpath)
+	}
+}
+
+func TestRegistrationClosed(t *testing.T) {
+	defer openRegistrationTestDB(t, RegistrationClosed)()
+	serv := gemtest.Testd(t, handler, 0)
+	defer serv.Stop()
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/", Cert: 0, Response: []byte("59 Registration is closed.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 0, Response: []byte("59 Registration is closed.\r\n")},
+	)
+}
+
+func TestRegistrationInvite(t *testing.T) {
+	defer openRegistrationTestDB(t, RegistrationInvite)()
+
+	code, status := DoCommand("invite 1")
+	if status != gemini.Success {
+		t.Fatalf("invite command failed: %s", code)
+	}
+	code = strings.TrimPrefix(code, "Invite code: ")
+
+	serv := gemtest.Testd(t, handler, 0)
+	defer serv.Stop()
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/", Cert: 0, Response: []byte("10 Please enter your invite code.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/?wrong", Cert: 0, Response: []byte("59 Invite code not found.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 0, Response: []byte("59 Invite code not found.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/?" + code, Cert: 0, Response: []byte("30 /register/" + code + "/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/" + code + "/", Cert: 0, Response: []byte("10 Please enter your username.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/" + code + "/?alice", Cert: 0, Response: []byte("30 /register/" + code + "/alice/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/" + code + "/alice/?alice%40example.net", Cert: 0, Response: []byte("30 /register/" + code + "/alice/alice%40example.net/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/" + code + "/alice/alice%40example.net/?password", Cert: 0, Response: []byte("30 /\r\n")},
+		// only one use
+		gemtest.Input{URL: "gemini://localhost/register/" + code + "/bob/bob%40example.net/?password", Cert: 0, Response: []byte("59 Invite code has already been used.\r\n")},
+	)
+
+	if resp, _ := DoCommand("invites"); !strings.HasPrefix(resp, code+" - by *internal*, used 1/1, expires never") {
+		t.Errorf("Unexpected invites listing %q", resp)
+	}
+	if resp, status := DoCommand("revoke " + code); status != gemini.Success {
+		t.Errorf("revoke command failed: %s", resp)
+	}
+	if resp, _ := DoCommand("invites"); resp != "" {
+		t.Errorf("Invite code remains after revoking: %q", resp)
+	}
+}
+
+func TestRegistrationApproval(t *testing.T) {
+	defer openRegistrationTestDB(t, RegistrationApproval)()
+
+	serv := gemtest.Testd(t, handler, 1)
+	defer serv.Stop()
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 0, Response: []byte("20 text/gemini\r\nThank you for registering. You will be able to log in once a moderator has approved your account.\r\n=> / Go to home.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 1, Response: []byte("59 Account is awaiting moderator approval\r\n")},
+	)
+
+	if resp, _ := DoCommand("pending"); !strings.HasPrefix(resp, "bob <bob@example.net> registered ") {
+		t.Errorf("Unexpected pending listing %q", resp)
+	}
+	if resp, status := DoCommand("approve bob"); status != gemini.Success {
+		t.Errorf("approve command failed: %s", resp)
+	}
+	if resp, _ := DoCommand("approve bob"); resp != ErrUserNotPending.Error() {
+		t.Errorf("Expected %q approving twice, recieved %q", ErrUserNotPending, resp)
+	}
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/carol/carol%40example.net/?password", Cert: 0, Response: []byte("20 text/gemini\r\nThank you for registering. You will be able to log in once a moderator has approved your account.\r\n=> / Go to home.\r\n")},
+	)
+
+	if resp, status := DoCommand("reject carol"); status != gemini.Success {
+		t.Errorf("reject command failed: %s", resp)
+	}
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/login/carol/?password", Cert: 1, Response: []byte("59 User does not exist.\r\n")},
+	)
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Renamed users
+
+DBRENAMES key=previous username val=current username
+
+The profile page of a previous username
+redirects to the current one.
+*/
+
+var (
+	ErrRenameSameName    = errors.New("The new username is the same as the current username.")
+	ErrRenamePriviledged = errors.New("The configuration file gives a priviledge to this username. Please change the configuration file first.")
+)
+
+func copyBucket(from, to *bolt.Bucket) error {
+	/*
+		Copy every key and nested bucket,
+		including sequence numbers.
+	*/
+	if err := to.SetSequence(from.Sequence()); err != nil {
+		return err
+	}
+	return from.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return to.Put(k, v)
+		}
+		sub, err := to.CreateBucketIfNotExists(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(from.Bucket(k), sub)
+	})
+}
+
+func moveBucket(parent *bolt.Bucket, from, to string) error {
+	/*
+		Move the sub-bucket "from" to "to", merging
+		with any bucket already there. Nothing is
+		done if "from" does not exist.
+	*/
+	if parent == nil {
+		return nil
+	}
+	existing := parent.Bucket([]byte(from))
+	if existing == nil {
+		return nil
+	}
+	renamed, err := parent.CreateBucketIfNotExists([]byte(to))
+	if err != nil {
+		return err
+	}
+	if err := copyBucket(existing, renamed); err != nil {
+		return err
+	}
+	return parent.DeleteBucket([]byte(from))
+}
+
+func replaceValues(b *bolt.Bucket, from, to string) error {
+	/*
+		Values can not be changed during
+		ForEach, so the keys are collected first.
+	*/
+	if b == nil {
+		return nil
+	}
+	var keys [][]byte
+	b.ForEach(func(k, v []byte) error {
+		if v != nil && string(v) == from {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	for _, k := range keys {
+		if err := b.Put(k, []byte(to)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renameKey(b *bolt.Bucket, from, to string) error {
+	if b == nil {
+		return nil
+	}
+	v := b.Get([]byte(from))
+	if v == nil {
+		return nil
+	}
+	if err := b.Put([]byte(to), append([]byte{}, v...)); err != nil {
+		return err
+	}
+	return b.Delete([]byte(from))
+}
+
+func checkRenameAvailableTx(tx *bolt.Tx, oldName, newName string) error {
+	/*
+		Like checkUsernameAvailableTx, but the user
+		may be renamed to a name that only looks
+		like their own name (such as a change of
+		case). Reserved names are not checked,
+		because only administrators rename users.
+	*/
+	if found, exists := LookupUsernameTx(tx, newName); exists && found != oldName {
+		return ErrUserAlreadyExists
+	}
+	if found, exists := LookupConfusableTx(tx, newName); exists && found != oldName {
+		return ErrUsernameConfusable
+	}
+	if current, exists := LookupPreviousUsernameTx(tx, newName); exists && current != oldName {
+		return ErrUsernameRenamed
+	}
+	return nil
+}
+
+func RenameUser(oldName, newName string) error {
+	/*
+		Rewrite every reference to this username
+		in one transaction, then update the
+		keyword index for the user's posts.
+	*/
+	if oldName == newName {
+		return ErrRenameSameName
+	}
+	if err := validateUsernameFormat(newName); err != nil {
+		return err
+	}
+	/*
+		Priviledges in the configuration file are
+		given by name, so neither name may have one.
+	*/
+	for _, name := range []string{oldName, newName} {
+		if _, ok := Configuration.Priviledges[name]; ok {
+			return ErrRenamePriviledged
+		}
+	}
+
+	var reindex []KeywordIndex
+	if err := db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(DBUSERS)
+		if users.Bucket([]byte(oldName)) == nil {
+			return ErrUserNotFound
+		}
+		if err := checkRenameAvailableTx(tx, oldName, newName); err != nil {
+			return err
+		}
+
+		/*
+			1. Account
+		*/
+		if err := moveBucket(users, oldName, newName); err != nil {
+			return err
+		}
+		if err := removeUsernameIndexTx(tx, oldName); err != nil {
+			return err
+		}
+		if err := addUsernameIndexTx(tx, newName); err != nil {
+			return err
+		}
+
+		/*
+			2. Logged in certificates and
+			verification codes
+		*/
+		for _, b := range [][]byte{DBFP, DBVALIDATION} {
+			if err := replaceValues(tx.Bucket(b), oldName, newName); err != nil {
+				return err
+			}
+		}
+
+		/*
+			3. Threads and posts
+		*/
+		threads := tx.Bucket(DBALLTHREADS)
+		if byUser := tx.Bucket(DBUSERTHREADS).Bucket([]byte(oldName)); byUser != nil {
+			if err := byUser.ForEach(func(k, id []byte) error {
+				if thread := threads.Bucket(id); thread != nil {
+					return thread.Put([]byte("user"), []byte(newName))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		posts := tx.Bucket(DBALLPOSTS)
+		if byUser := tx.Bucket(DBUSERPOSTS).Bucket([]byte(oldName)); byUser != nil {
+			if err := byUser.ForEach(func(k, id []byte) error {
+				post := posts.Bucket(id)
+				if post == nil {
+					// deleted
+					return nil
+				}
+				reindex = append(reindex, makeKeywordIndex(newName, string(post.Get([]byte("text"))), id, post.Get([]byte("thread"))))
+				return post.Put([]byte("user"), []byte(newName))
+			}); err != nil {
+				return err
+			}
+		}
+		for _, b := range [][]byte{DBUSERTHREADS, DBUSERPOSTS, DBROLES, DBSETTINGS, DBIGNORES, DBPURGES, DBMUTES} {
+			if err := moveBucket(tx.Bucket(b), oldName, newName); err != nil {
+				return err
+			}
+		}
+
+		/*
+			4. Other users' ignore lists
+		*/
+		if ignores := tx.Bucket(DBIGNORES); ignores != nil {
+			if err := ignores.ForEach(func(k, v []byte) error {
+				return renameKey(ignores.Bucket(k), oldName, newName)
+			}); err != nil {
+				return err
+			}
+		}
+
+		/*
+			5. Bans given to or by this user
+		*/
+		if bans := tx.Bucket(DBBANS); bans != nil {
+			if err := moveBucket(bans.Bucket([]byte(BanUser)), oldName, newName); err != nil {
+				return err
+			}
+			if err := bans.ForEach(func(kind, v []byte) error {
+				byKind := bans.Bucket(kind)
+				if byKind == nil {
+					return nil
+				}
+				return byKind.ForEach(func(target, v []byte) error {
+					if ban := byKind.Bucket(target); ban != nil && string(ban.Get([]byte("by"))) == oldName {
+						return ban.Put([]byte("by"), []byte(newName))
+					}
+					return nil
+				})
+			}); err != nil {
+				return err
+			}
+		}
+
+		/*
+			6. Invite codes and accounts
+			awaiting approval
+		*/
+		invites := tx.Bucket(DBINVITES)
+		if err := invites.ForEach(func(code, v []byte) error {
+			invite := invites.Bucket(code)
+			if invite == nil {
+				return nil
+			}
+			if string(invite.Get([]byte("creator"))) == oldName {
+				if err := invite.Put([]byte("creator"), []byte(newName)); err != nil {
+					return err
+				}
+			}
+			return renameKey(invite.Bucket([]byte("users")), oldName, newName)
+		}); err != nil {
+			return err
+		}
+		if err := renameKey(tx.Bucket(DBPENDING), oldName, newName); err != nil {
+			return err
+		}
+
+		/*
+			7. Reports by, on and resolved by
+			this user
+		*/
+		reports := tx.Bucket(DBREPORTS)
+		if err := reports.ForEach(func(id, v []byte) error {
+			report := reports.Bucket(id)
+			if report == nil {
+				return nil
+			}
+			for _, field := range []string{"author", "reporter", "resolvedby"} {
+				if string(report.Get([]byte(field))) == oldName {
+					if err := report.Put([]byte(field), []byte(newName)); err != nil {
+						return err
+					}
+				}
+			}
+			if string(report.Get([]byte("reporter"))) != newName {
+				return nil
+			}
+			if post := posts.Bucket(report.Get([]byte("post"))); post != nil {
+				return renameKey(post.Bucket([]byte("reporters")), oldName, newName)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		/*
+			8. Appeals by and answered by
+			this user
+		*/
+		appeals := tx.Bucket(DBAPPEALS)
+		if err := appeals.ForEach(func(id, v []byte) error {
+			appeal := appeals.Bucket(id)
+			if appeal == nil {
+				return nil
+			}
+			for _, field := range []string{"user", "answeredby"} {
+				if string(appeal.Get([]byte(field))) == oldName {
+					if err := appeal.Put([]byte(field), []byte(newName)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		/*
+			9. Mutes given or lifted and purges
+			done by this user
+		*/
+		mutes := tx.Bucket(DBMUTES)
+		if err := mutes.ForEach(func(target, v []byte) error {
+			history := mutes.Bucket(target)
+			if history == nil {
+				return nil
+			}
+			return history.ForEach(func(seq, v []byte) error {
+				record := history.Bucket(seq)
+				if record == nil {
+					return nil
+				}
+				for _, field := range []string{"by", "liftedby"} {
+					if string(record.Get([]byte(field))) == oldName {
+						if err := record.Put([]byte(field), []byte(newName)); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			})
+		}); err != nil {
+			return err
+		}
+		purges := tx.Bucket(DBPURGES)
+		if err := purges.ForEach(func(target, v []byte) error {
+			if purge := purges.Bucket(target); purge != nil && string(purge.Get([]byte("by"))) == oldName {
+				return purge.Put([]byte("by"), []byte(newName))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		/*
+			10. Posts held for approval
+		*/
+		held := tx.Bucket(DBHELD)
+		if err := held.ForEach(func(id, v []byte) error {
+			if post := held.Bucket(id); post != nil && string(post.Get([]byte("user"))) == oldName {
+				return post.Put([]byte("user"), []byte(newName))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		/*
+			11. Redirect from the old profile. Earlier
+			names of this user now also point to the
+			new name, and the new name no longer
+			redirects anywhere.
+		*/
+		renames, err := tx.CreateBucketIfNotExists(DBRENAMES)
+		if err != nil {
+			return err
+		}
+		if err := replaceValues(renames, oldName, newName); err != nil {
+			return err
+		}
+		if err := renames.Delete([]byte(newName)); err != nil {
+			return err
+		}
+		return renames.Put([]byte(oldName), []byte(newName))
+	}); err != nil {
+		return err
+	}
+
+	for _, post := range reindex {
+		if keywordDBchan != nil {
+			keywordDBchan <- post
+		}
+	}
+	log.Printf("Renamed user %q to %q (%d posts reindexed)\n", oldName, newName, len(reindex))
+	return nil
+}
+
+func LookupRenamedUserTx(tx *bolt.Tx, username string) (string, bool) {
+	renames := tx.Bucket(DBRENAMES)
+	if renames == nil {
+		return "", false
+	}
+	current := renames.Get([]byte(username))
+	return string(current), current != nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	"github.com/google/go-cmp/cmp"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRenameUser(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestRenameUser.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 2)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+	)
+	OnNewThread("firstsub", "bob", "first thread", "hello")
+	if err := IgnoreUser("alice", "bob"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := AssignRole("bob", "mod", GlobalScope); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket(DBUSERS).CreateBucket([]byte("carol"))
+		return err
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := MuteUser("carol", "bob", time.Time{}, "testing"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := UnmuteUser("carol", "bob"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := PurgeUser("carol", "bob", false); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/console/?rename%20bob%20%D0%B0lice", Cert: 1, Response: []byte("59 This username looks too similar to an existing user.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?rename%20bob%20bob%21", Cert: 1, Response: []byte("59 Unallowed character in username\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?rename%20nobody%20robert", Cert: 1, Response: []byte("59 User not found\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?rename%20bob%20robert", Cert: 1, Response: []byte("20 text/plain\r\nUser has been renamed.")},
+
+		/*
+			bob's certificate is still logged in
+		*/
+		gemtest.Input{URL: "gemini://localhost/user/bob/", Cert: 2, Response: []byte("31 /user/robert/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/search/?%40robert", Cert: 2, Response: []byte("20 text/gemini\r\n# Search by user robert\r\n\r\n## Created threads\r\n=> /thread/0000000000000001/ <robert> first thread\r\nID: 0000000000000001\r\n> hello\r\n## Replies\r\n")},
+		gemtest.Input{URL: "gemini://localhost/search/?%40bob", Cert: 2, Response: []byte("59 User by that name not found\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/robert/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("59 User does not exist.\r\n")},
+	)
+
+	/*
+		The old name can not be taken by
+		someone else
+	*/
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/Bob/bob2%40example.net/?password", Cert: 2, Response: []byte("59 " + ErrUsernameRenamed.Error() + "\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?rename%20alice%20alicia", Cert: 1, Response: []byte("59 " + ErrRenamePriviledged.Error() + "\r\n")},
+	)
+
+	if ignored := ListIgnoredUsers("alice"); !cmp.Equal(ignored, []string{"robert"}) {
+		t.Errorf("Expected alice to ignore robert, recieved %q", ignored)
+	}
+	if described, err := DescribeUserRoles("robert"); err != nil || described != "priviledge: User\n*: mod" {
+		t.Errorf("Roles were not moved: %q %v", described, err)
+	}
+	if mutes, err := GetMuteHistory("carol"); err != nil || len(mutes) != 1 || mutes[0].By != "robert" || mutes[0].LiftedBy != "robert" {
+		t.Errorf("Mute history was not updated: %+v %v", mutes, err)
+	}
+	if purges, err := ListPurges(); err != nil || len(purges) != 1 || purges[0].By != "robert" {
+		t.Errorf("Purge was not updated: %+v %v", purges, err)
+	}
+
+	/*
+		Renaming back removes the redirect
+	*/
+	if err := RenameUser("robert", "bob"); err != nil {
+		t.Fatal(err.Error())
+	}
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/user/robert/", Cert: 2, Response: []byte("31 /user/bob/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/user/bob/", Cert: 2, Response: []byte("20 text/gemini\r\n# bob\r\n=> /search/?%40bob Threads and replies\r\n\r\n=> / Go to home.\r\n")},
+	)
+}
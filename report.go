@@ -5,8 +5,11 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"log"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"codeberg.org/FiskFan1999/gemini"
 	bolt "go.etcd.io/bbolt"
@@ -14,6 +17,422 @@ import (
 
 var AlreadyReported = errors.New("Already reported this post")
 
+/*
+Moderation queue
+
+Each user may report a post once. The post
+sub-bucket "reporters" holds key=username
+val=report ID, and "reports" is the number of
+reporters. When Reports.HideThreshold users have
+reported a post, hidden="1" is set on the post
+until a moderator restores or archives it. A
+restored post has hidden="0", and is not hidden
+again automatically.
+
+DBREPORTS key=itob(NextSequence) sub-bucket:
+post=post ID
+thread=thread ID
+author=username of the author of the post
+reporter=username
+reason=reason given by the reporter
+time=time.MarshalText()
+status="open" or "resolved"
+action=how the report was resolved (see ReportActions)
+resolvedby=username of the moderator
+resolved=time.MarshalText()
+*/
+
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+const (
+	ReportDismiss = "dismiss" // nothing wrong, the reporter may report the post again
+	ReportAccept  = "accept"  // the report was correct
+	ReportRestore = "restore" // show the post again and close every report on it
+	ReportArchive = "archive" // remove the post and close every report on it
+	ReportLock    = "lock"    // lock the thread
+	ReportMute    = "mute"    // permanently mute the author of the post
+)
+
+var ReportActions = []string{ReportDismiss, ReportAccept, ReportRestore, ReportArchive, ReportLock, ReportMute}
+
+var (
+	ErrReportNotFound        = errors.New("Report not found.")
+	ErrReportAlreadyResolved = errors.New("This report has already been resolved.")
+	ErrInvalidReportAction   = errors.New("Invalid action (must be dismiss, accept, restore, archive, lock or mute).")
+)
+
+type Report struct {
+	ID         string
+	Post       string
+	Thread     string
+	Author     string
+	Reporter   string
+	Reason     string
+	Time       time.Time
+	Status     string
+	Action     string
+	ResolvedBy string
+	Resolved   time.Time
+
+	// current state of the post
+	PostReports int
+	PostHidden  bool
+}
+
+func (r Report) String() string {
+	var hidden string
+	if r.PostHidden {
+		hidden = ", hidden"
+	}
+	s := fmt.Sprintf("%s: post %s by %s in thread %s (%d reports%s), reported by %s on %s: %s", r.ID, r.Post, r.Author, r.Thread, r.PostReports, hidden, r.Reporter, r.Time.UTC().Format(time.RFC1123), r.Reason)
+	if r.Status == ReportResolved {
+		s += fmt.Sprintf(" (%s by %s)", r.Action, r.ResolvedBy)
+	}
+	return s
+}
+
+func IsPostHidden(post *bolt.Bucket) bool {
+	/*
+		Hidden pending review, or archived.
+	*/
+	return bytes.Equal(post.Get([]byte("hidden")), []byte("1")) || bytes.Equal(post.Get([]byte("archived")), []byte("1"))
+}
+
+func IsFirstPostHiddenTx(tx *bolt.Tx, thread *bolt.Bucket) bool {
+	/*
+		A thread whose first post is hidden is
+		hidden from lists and search.
+	*/
+	posts := thread.Bucket([]byte("posts"))
+	if posts == nil {
+		return false
+	}
+	first := tx.Bucket(DBALLPOSTS).Bucket(posts.Get(itob(1)))
+	return first != nil && IsPostHidden(first)
+}
+
+func PostReportCount(post *bolt.Bucket) int {
+	n, _ := strconv.Atoi(string(post.Get([]byte("reports"))))
+	return n
+}
+
+func readReportTx(tx *bolt.Tx, id []byte, report *bolt.Bucket) (r Report, err error) {
+	r.ID = string(id)
+	r.Post = string(report.Get([]byte("post")))
+	r.Thread = string(report.Get([]byte("thread")))
+	r.Author = string(report.Get([]byte("author")))
+	r.Reporter = string(report.Get([]byte("reporter")))
+	r.Reason = string(report.Get([]byte("reason")))
+	r.Status = string(report.Get([]byte("status")))
+	r.Action = string(report.Get([]byte("action")))
+	r.ResolvedBy = string(report.Get([]byte("resolvedby")))
+	if err = r.Time.UnmarshalText(report.Get([]byte("time"))); err != nil {
+		return
+	}
+	if resolved := report.Get([]byte("resolved")); resolved != nil {
+		if err = r.Resolved.UnmarshalText(resolved); err != nil {
+			return
+		}
+	}
+	if post := tx.Bucket(DBALLPOSTS).Bucket([]byte(r.Post)); post != nil {
+		r.PostReports = PostReportCount(post)
+		r.PostHidden = IsPostHidden(post)
+	}
+	return
+}
+
+func sequenceKey(id string) ([]byte, bool) {
+	/*
+		IDs made with itob(NextSequence). The
+		leading zeros may be left out.
+	*/
+	n, err := strconv.ParseUint(id, 16, 64)
+	if err != nil {
+		return nil, false
+	}
+	return itob(n), true
+}
+
+func addReportTx(tx *bolt.Tx, post *bolt.Bucket, postID, reporter, reason string) error {
+	reporters, err := post.CreateBucketIfNotExists([]byte("reporters"))
+	if err != nil {
+		return err
+	}
+	if reporters.Get([]byte(reporter)) != nil {
+		return AlreadyReported
+	}
+	reports := tx.Bucket(DBREPORTS)
+	seq, err := reports.NextSequence()
+	if err != nil {
+		return err
+	}
+	report, err := reports.CreateBucket(itob(seq))
+	if err != nil {
+		return err
+	}
+	now, err := time.Now().MarshalText()
+	if err != nil {
+		return err
+	}
+	if err := reporters.Put([]byte(reporter), itob(seq)); err != nil {
+		return err
+	}
+	if err := countReportersTx(post); err != nil {
+		return err
+	}
+	if threshold := Configuration.Reports.HideThreshold; threshold > 0 && PostReportCount(post) >= threshold && post.Get([]byte("hidden")) == nil {
+		if err := post.Put([]byte("hidden"), []byte("1")); err != nil {
+			return err
+		}
+	}
+	report.Put([]byte("post"), []byte(postID))
+	report.Put([]byte("thread"), post.Get([]byte("thread")))
+	report.Put([]byte("author"), post.Get([]byte("user")))
+	report.Put([]byte("reporter"), []byte(reporter))
+	report.Put([]byte("reason"), []byte(reason))
+	report.Put([]byte("time"), now)
+	return report.Put([]byte("status"), []byte(ReportOpen))
+}
+
+func countReportersTx(post *bolt.Bucket) error {
+	var n int
+	if reporters := post.Bucket([]byte("reporters")); reporters != nil {
+		reporters.ForEach(func(k, v []byte) error {
+			n++
+			return nil
+		})
+	}
+	return post.Put([]byte("reports"), []byte(strconv.Itoa(n)))
+}
+
+func resolveReportTx(report *bolt.Bucket, action, by string, now []byte) error {
+	report.Put([]byte("status"), []byte(ReportResolved))
+	report.Put([]byte("action"), []byte(action))
+	report.Put([]byte("resolvedby"), []byte(by))
+	return report.Put([]byte("resolved"), now)
+}
+
+func ListReports(all bool, visible func(Report) bool) (reports []Report, err error) {
+	/*
+		Newest first. Resolved reports are
+		only included if all is true.
+	*/
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(DBREPORTS)
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if v != nil {
+				continue
+			}
+			r, err := readReportTx(tx, k, bucket.Bucket(k))
+			if err != nil {
+				return err
+			}
+			if (all || r.Status == ReportOpen) && visible(r) {
+				reports = append(reports, r)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func GetReport(id string) (r Report, err error) {
+	key, ok := sequenceKey(id)
+	if !ok {
+		return r, ErrReportNotFound
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		report := tx.Bucket(DBREPORTS).Bucket(key)
+		if report == nil {
+			return ErrReportNotFound
+		}
+		r, err = readReportTx(tx, key, report)
+		return err
+	})
+	return
+}
+
+func ReportActionCapability(action string) (Capability, error) {
+	switch action {
+	case ReportDismiss, ReportAccept, ReportRestore:
+		return CapReadReports, nil
+	case ReportArchive:
+		return CapArchive, nil
+	case ReportLock:
+		return CapLock, nil
+	case ReportMute:
+		return CapMute, nil
+	}
+	return "", ErrInvalidReportAction
+}
+
+func ReportActionScope(action, subforum string) string {
+	/*
+		A mute applies to the whole forum, so
+		it needs a global role, like the mute
+		command. Other actions only need the
+		capability in the subforum of the post.
+	*/
+	if action == ReportMute {
+		return ""
+	}
+	return subforum
+}
+
+func ResolveReport(id, action, by string) error {
+	key, ok := sequenceKey(id)
+	if !ok {
+		return ErrReportNotFound
+	}
+	if _, err := ReportActionCapability(action); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		report := tx.Bucket(DBREPORTS).Bucket(key)
+		if report == nil {
+			return ErrReportNotFound
+		}
+		r, err := readReportTx(tx, key, report)
+		if err != nil {
+			return err
+		}
+		if r.Status != ReportOpen {
+			return ErrReportAlreadyResolved
+		}
+		now, err := time.Now().MarshalText()
+		if err != nil {
+			return err
+		}
+
+		post := tx.Bucket(DBALLPOSTS).Bucket([]byte(r.Post))
+		switch action {
+		case ReportDismiss:
+			if post == nil {
+				break
+			}
+			if reporters := post.Bucket([]byte("reporters")); reporters != nil {
+				if err := reporters.Delete([]byte(r.Reporter)); err != nil {
+					return err
+				}
+			}
+			if err := countReportersTx(post); err != nil {
+				return err
+			}
+			/*
+				Nothing is pending review once every
+				report on a hidden post is dismissed.
+			*/
+			if open, err := openReportsOnPostTx(tx, post, key); err != nil {
+				return err
+			} else if open == 0 && bytes.Equal(post.Get([]byte("hidden")), []byte("1")) {
+				if err := post.Delete([]byte("hidden")); err != nil {
+					return err
+				}
+			}
+		case ReportRestore, ReportArchive:
+			if post == nil {
+				return errors.New("Post not found.")
+			}
+			if err := post.Put([]byte("hidden"), []byte("0")); err != nil {
+				return err
+			}
+			if action == ReportArchive {
+				if err := post.Put([]byte("archived"), []byte("1")); err != nil {
+					return err
+				}
+			}
+			/*
+				Close the other reports on this post
+			*/
+			if reporters := post.Bucket([]byte("reporters")); reporters != nil {
+				if err := reporters.ForEach(func(k, id []byte) error {
+					other := tx.Bucket(DBREPORTS).Bucket(id)
+					if other == nil || bytes.Equal(id, key) || string(other.Get([]byte("status"))) != ReportOpen {
+						return nil
+					}
+					return resolveReportTx(other, action, by, now)
+				}); err != nil {
+					return err
+				}
+			}
+		case ReportLock:
+			thread := tx.Bucket(DBALLTHREADS).Bucket([]byte(r.Thread))
+			if thread == nil {
+				return errors.New("Thread not found.")
+			}
+			if err := thread.Put([]byte("locked"), []byte("1")); err != nil {
+				return err
+			}
+		case ReportMute:
+			// the report reason was written by the reporter, not a moderator
+			if err := MuteUserTx(tx, r.Author, by, time.Time{}, fmt.Sprintf("Reported post %s", r.Post)); err != nil {
+				return err
+			}
+		}
+
+		return resolveReportTx(report, action, by, now)
+	})
+}
+
+func openReportsOnPostTx(tx *bolt.Tx, post *bolt.Bucket, except []byte) (n int, err error) {
+	reporters := post.Bucket([]byte("reporters"))
+	if reporters == nil {
+		return
+	}
+	err = reporters.ForEach(func(k, id []byte) error {
+		report := tx.Bucket(DBREPORTS).Bucket(id)
+		if report != nil && !bytes.Equal(id, except) && string(report.Get([]byte("status"))) == ReportOpen {
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+func ReportsPage(user string, priv UserPriviledge) gemini.Response {
+	/*
+		/console/reports/
+		Open reports which this moderator
+		may read, with links to resolve them.
+	*/
+	reports, err := ListReports(false, func(r Report) bool {
+		return Authorize(user, priv, CapReadReports, GetSubforumOfThread([]byte(r.Thread)))
+	})
+	if err != nil {
+		return gemini.TemporaryFailure.Error(err)
+	}
+	lines := gemini.Lines{}
+	lines.Header(1, "Open reports")
+	if len(reports) == 0 {
+		lines.Line("There are no open reports.")
+	}
+	for _, r := range reports {
+		lines.Header(2, fmt.Sprintf("Report %s", r.ID))
+		lines.Line(fmt.Sprintf("Reported by %s on %s", r.Reporter, r.Time.UTC().Format(time.RFC1123)))
+		lines.Quote(r.Reason)
+		lines.LinkDesc(fmt.Sprintf("/thread/%s/", r.Thread), fmt.Sprintf("Post %s by %s", r.Post, r.Author))
+		if r.PostHidden {
+			lines.Line(fmt.Sprintf("This post has %d reports and is hidden pending review.", r.PostReports))
+		} else {
+			lines.Line(fmt.Sprintf("This post has %d reports.", r.PostReports))
+		}
+		for _, action := range ReportActions {
+			lines.LinkDesc(fmt.Sprintf("/console/?%s", url.QueryEscape(fmt.Sprintf("resolve %s %s", r.ID, action))), fmt.Sprintf("Resolve: %s", action))
+		}
+	}
+	lines.Line("")
+	lines.LinkDesc("/console/", "Console")
+	return gemini.ResponseFormat{
+		Status: gemini.Success,
+		Mime:   "text/gemini",
+		Lines:  lines,
+	}
+}
+
 func ReportHandler(u *url.URL, c *tls.Conn) gemini.ResponseFormat {
 	fp := GetFingerprint(c)
 	if fp == nil {
@@ -54,6 +473,7 @@ func ReportHandler(u *url.URL, c *tls.Conn) gemini.ResponseFormat {
 	}
 	/*
 		Get information about the post from the database
+		and add the report to the moderation queue
 	*/
 	var post Post
 	if err := db.Update(func(tx *bolt.Tx) error {
@@ -64,12 +484,6 @@ func ReportHandler(u *url.URL, c *tls.Conn) gemini.ResponseFormat {
 			return ErrNotFound
 		}
 
-		if !bytes.Equal(thisPost.Get([]byte("reports")), []byte("0")) {
-			return AlreadyReported
-		} else {
-			thisPost.Put([]byte("reports"), []byte("1"))
-		}
-
 		post.ID = []byte(id)
 		post.Text = string(thisPost.Get([]byte("text")))
 		post.Author = string(thisPost.Get([]byte("user")))
@@ -77,11 +491,11 @@ func ReportHandler(u *url.URL, c *tls.Conn) gemini.ResponseFormat {
 			return err
 		}
 
-		return nil
+		return addReportTx(tx, thisPost, id, username, reason)
 	}); errors.Is(err, AlreadyReported) {
 		return gemini.ResponseFormat{
 			Status: gemini.BadRequest,
-			Mime:   "Post has already been reported. Thank you.",
+			Mime:   "You have already reported this post. Thank you.",
 			Lines:  nil,
 		}
 	} else if err != nil {
@@ -91,20 +505,11 @@ func ReportHandler(u *url.URL, c *tls.Conn) gemini.ResponseFormat {
 			Lines:  nil,
 		}
 	}
-	// send email to administrators
-	if err := SendEmailOnReport(post, username, reason, c); err != nil {
-		if errors.Is(err, reportRateLimited) {
-			return gemini.ResponseFormat{
-				Status: gemini.SlowDown,
-				Mime:   "60",
-				Lines:  nil,
-			}
-		} else {
-			return gemini.ResponseFormat{
-				Status: gemini.TemporaryFailure,
-				Mime:   err.Error(),
-				Lines:  nil,
-			}
+	// notify administrators by email, if enabled
+	// (limited by the "report" rate limit, see ratelimit.go)
+	if Configuration.Smtp.Enabled {
+		if err := SendEmailOnReport(post, username, reason, c); err != nil {
+			log.Printf("Error while sending report email: %s\n", err.Error())
 		}
 	}
 	return gemini.ResponseFormat{
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestReportQueue(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+		Smtp: ConfigStrSmtp{Enabled: false},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestReportQueue.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 2)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+	)
+	OnNewThread("firstsub", "alice", "first thread", "hello")
+
+	/*
+		Reporting works without SMTP
+	*/
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/report/0000000000000001/?spam", Cert: 2, Response: []byte("20 text/gemini\r\nThank you for your report.\r\n=> / Go to home.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/report/0000000000000001/?spam", Cert: 2, Response: []byte("59 You have already reported this post. Thank you.\r\n")},
+	)
+
+	report, err := GetReport("1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if report.Post != "0000000000000001" || report.Thread != "0000000000000001" || report.Author != "alice" || report.Reporter != "bob" || report.Reason != "spam" || report.Status != ReportOpen {
+		t.Errorf("Incorrect report stored: %+v", report)
+	}
+	if page := string(ReportsPage("alice", Admin).Bytes()); !strings.Contains(page, "=> /console/?resolve+0000000000000001+lock Resolve: lock\r\n") {
+		t.Errorf("Recieved %q", page)
+	}
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/console/?reports", Cert: 1, Response: []byte("20 text/plain\r\n" + report.String())},
+		gemtest.Input{URL: "gemini://localhost/console/reports/", Cert: 1, Response: ReportsPage("alice", Admin).Bytes()},
+		gemtest.Input{URL: "gemini://localhost/console/reports/", Cert: 2, Response: []byte("61 Unauthorized\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%201%20delete", Cert: 1, Response: []byte("59 Invalid action (must be dismiss, accept, restore, archive, lock or mute).\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%2099%20dismiss", Cert: 1, Response: []byte("59 Report not found.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%201%20dismiss", Cert: 1, Response: []byte("20 text/plain\r\nReport has been resolved.")},
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%201%20dismiss", Cert: 1, Response: []byte("59 This report has already been resolved.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?reports", Cert: 1, Response: []byte("20 text/plain\r\nThere are no open reports.")},
+
+		// dismissed, so bob may report it again
+		gemtest.Input{URL: "gemini://localhost/report/0000000000000001/?still%20spam", Cert: 2, Response: []byte("20 text/gemini\r\nThank you for your report.\r\n=> / Go to home.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%202%20lock", Cert: 1, Response: []byte("20 text/plain\r\nReport has been resolved.")},
+	)
+
+	/*
+		A role in the subforum does not
+		allow muting the author.
+	*/
+	Configuration.Roles = map[string][]Capability{
+		"submod": {CapConsole, CapReadReports, CapMute},
+	}
+	if err := AssignRole("bob", "submod", "firstsub"); err != nil {
+		t.Fatal(err.Error())
+	}
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/report/0000000000000001/?still%20spam", Cert: 1, Response: []byte("20 text/gemini\r\nThank you for your report.\r\n=> / Go to home.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%203%20mute", Cert: 2, Response: []byte("61 " + CommandUnauthorized + "\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%203%20dismiss", Cert: 2, Response: []byte("20 text/plain\r\nReport has been resolved.")},
+	)
+
+	reports, err := ListReports(true, func(Report) bool { return true })
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(reports) != 3 || reports[0].Action != ReportDismiss || reports[1].Action != ReportLock || reports[2].Action != ReportDismiss || reports[2].ResolvedBy != "alice" {
+		t.Errorf("Incorrect reports: %+v", reports)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if locked := string(tx.Bucket(DBALLTHREADS).Bucket([]byte("0000000000000001")).Get([]byte("locked"))); locked != "1" {
+			t.Errorf("Expected thread to be locked, recieved %q", locked)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	/*
+		The mute reason is not written by
+		the reporter
+	*/
+	OnNewThread("firstsub", "alice", "second thread", "hello again")
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/report/0000000000000002/?you%20are%20muted%20for%20being%20an%20idiot", Cert: 2, Response: []byte("20 text/gemini\r\nThank you for your report.\r\n=> / Go to home.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%204%20mute", Cert: 1, Response: []byte("20 text/plain\r\nReport has been resolved.")},
+	)
+	if mutes, err := GetMuteHistory("alice"); err != nil || len(mutes) != 1 || mutes[0].Reason != "Reported post 0000000000000002" {
+		t.Errorf("Incorrect mute: %+v %v", mutes, err)
+	}
+}
+
+func TestReportHideThreshold(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+		Reports: ConfigReports{HideThreshold: 2},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestReportHideThreshold.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 3)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/carol/carol%40example.net/?password", Cert: 3, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/carol/?password", Cert: 3, Response: []byte("30 /\r\n")},
+	)
+	OnNewThread("firstsub", "alice", "first thread", "hello")
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(DBALLPOSTS).Bucket([]byte("0000000000000001")).Put([]byte("time"), []byte("2020-01-01T01:00:00.000000-04:00"))
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	visible := "20 text/gemini\r\n# first thread\r\n=> /new/post/0000000000000001/ Write comment\r\n\r\n### [Admin]alice\r\n=> /report/0000000000000001/ Wed, 01 Jan 2020 05:00:00 UTC (click to report)\r\n> hello\r\n\r\n=> /new/post/0000000000000001/ Write comment\r\n"
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/report/0000000000000001/?spam", Cert: 2, Response: []byte("20 text/gemini\r\nThank you for your report.\r\n=> / Go to home.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/thread/0000000000000001/", Cert: 3, Response: []byte(visible)},
+		gemtest.Input{URL: "gemini://localhost/report/0000000000000001/?spam", Cert: 3, Response: []byte("20 text/gemini\r\nThank you for your report.\r\n=> / Go to home.\r\n")},
+
+		/*
+			Two users have reported the post,
+			so it is hidden except from moderators.
+		*/
+		gemtest.Input{URL: "gemini://localhost/thread/0000000000000001/", Cert: 3, Response: []byte("20 text/gemini\r\n# first thread\r\n=> /new/post/0000000000000001/ Write comment\r\n\r\nPost by alice hidden pending review\r\n\r\n=> /new/post/0000000000000001/ Write comment\r\n")},
+		gemtest.Input{URL: "gemini://localhost/thread/0000000000000001/", Cert: 1, Response: []byte("20 text/gemini\r\n# first thread\r\n=> /new/post/0000000000000001/ Write comment\r\n\r\n### [Admin]alice\r\n=> /report/0000000000000001/ Wed, 01 Jan 2020 05:00:00 UTC (click to report)\r\n=> /console/reports/ 2 reports, hidden pending review\r\n> hello\r\n\r\n=> /new/post/0000000000000001/ Write comment\r\n")},
+		gemtest.Input{URL: "gemini://localhost/search/?%40alice", Cert: 3, Response: []byte("20 text/gemini\r\n# Search by user alice\r\n\r\n## Created threads\r\n## Replies\r\n")},
+		gemtest.Input{URL: "gemini://localhost/f/firstsub/", Cert: 3, Response: []byte("20 text/gemini\r\n# first subforum\r\n=> /new/thread/firstsub Post new thread\r\n\r\nThread by alice hidden pending review\r\n")},
+
+		/*
+			Restoring closes both reports, and the
+			post is not hidden again by more reports.
+		*/
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%202%20restore", Cert: 1, Response: []byte("20 text/plain\r\nReport has been resolved.")},
+		gemtest.Input{URL: "gemini://localhost/console/?reports", Cert: 1, Response: []byte("20 text/plain\r\nThere are no open reports.")},
+		gemtest.Input{URL: "gemini://localhost/thread/0000000000000001/", Cert: 3, Response: []byte(visible)},
+		gemtest.Input{URL: "gemini://localhost/report/0000000000000001/?spam", Cert: 3, Response: []byte("59 You have already reported this post. Thank you.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/report/0000000000000001/?spam", Cert: 1, Response: []byte("20 text/gemini\r\nThank you for your report.\r\n=> / Go to home.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/thread/0000000000000001/", Cert: 3, Response: []byte(visible)},
+
+		gemtest.Input{URL: "gemini://localhost/console/?resolve%203%20archive", Cert: 1, Response: []byte("20 text/plain\r\nReport has been resolved.")},
+		gemtest.Input{URL: "gemini://localhost/thread/0000000000000001/", Cert: 3, Response: []byte("20 text/gemini\r\n# first thread\r\n=> /new/post/0000000000000001/ Write comment\r\n\r\n=> /new/post/0000000000000001/ Write comment\r\n")},
+	)
+
+	reports, err := ListReports(true, func(Report) bool { return true })
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i, expected := range []string{ReportArchive, ReportRestore, ReportRestore} {
+		if reports[i].Action != expected || reports[i].PostReports != 3 {
+			t.Errorf("Report %s: expected %s with 3 reports, recieved %+v", reports[i].ID, expected, reports[i])
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+A capability is a single action that a
+role allows a user to perform.
+*/
+type Capability string
+
+const (
+	CapConsole        Capability = "console"    // use the operator console
+	CapLock           Capability = "lock"       // lock and unlock threads
+	CapMute           Capability = "mute"       // mute and unmute users
+	CapBan            Capability = "ban"        // ban users, IP addresses and certificates
+	CapMove           Capability = "move"       // move threads between subforums
+	CapArchive        Capability = "archive"    // archive threads and posts
+	CapReadReports    Capability = "reports"    // read reports on posts
+	CapManageUsers    Capability = "users"      // rename users, and change priviledges and roles (administrators only)
+	CapRegistrations  Capability = "register"   // manage invite codes and approve new accounts
+	CapRestrictedPost Capability = "restricted" // post in subforums above the user's priviledge
+)
+
+var AllCapabilities = []Capability{CapConsole, CapLock, CapMute, CapBan, CapMove, CapArchive, CapReadReports, CapManageUsers, CapRegistrations, CapRestrictedPost}
+
+/*
+Roles which are always defined. The "mod" and
+"admin" roles are given to every user with
+that UserPriviledge level.
+*/
+var BuiltinRoles = map[string][]Capability{
+	"mod":   {CapConsole, CapLock, CapMute, CapBan, CapMove, CapArchive, CapReadReports, CapRegistrations},
+	"admin": AllCapabilities,
+}
+
+/*
+Name of the scope used for roles which are
+assigned for every subforum.
+*/
+const GlobalScope = "*"
+
+var (
+	ErrUnknownCapability = errors.New("Unknown capability in role")
+	ErrBuiltinRoleName   = errors.New("Role name is already used by a built-in role")
+	ErrUnknownRole       = errors.New("Role not found")
+	ErrRoleNotAssigned   = errors.New("User does not have this role")
+)
+
+func ValidateRoles(roles map[string][]Capability) error {
+	for name, caps := range roles {
+		if _, ok := BuiltinRoles[name]; ok {
+			return fmt.Errorf("%w: %s", ErrBuiltinRoleName, name)
+		}
+		for _, c := range caps {
+			if !IsCapability(c) {
+				return fmt.Errorf("%w: %s", ErrUnknownCapability, c)
+			}
+		}
+	}
+	return nil
+}
+
+func IsCapability(c Capability) bool {
+	for _, a := range AllCapabilities {
+		if a == c {
+			return true
+		}
+	}
+	return false
+}
+
+func GetRoleCapabilities(role string) ([]Capability, bool) {
+	if caps, ok := BuiltinRoles[role]; ok {
+		return caps, true
+	}
+	if Configuration == nil {
+		return nil, false
+	}
+	caps, ok := Configuration.Roles[role]
+	return caps, ok
+}
+
+func RoleHasCapability(role string, cap Capability) bool {
+	caps, _ := GetRoleCapabilities(role)
+	for _, c := range caps {
+		if c == cap {
+			return true
+		}
+	}
+	return false
+}
+
+func PriviledgeRole(priv UserPriviledge) string {
+	switch {
+	case priv.Is(Admin):
+		return "admin"
+	case priv.Is(Mod):
+		return "mod"
+	}
+	return ""
+}
+
+/*
+Returns the roles assigned to a user in the
+database, sorted by scope (GlobalScope or
+subforum ID).
+*/
+func GetUserRolesTx(tx *bolt.Tx, username string) map[string][]string {
+	out := map[string][]string{}
+	allRoles := tx.Bucket(DBROLES)
+	if allRoles == nil {
+		return out
+	}
+	user := allRoles.Bucket([]byte(username))
+	if user == nil {
+		return out
+	}
+	user.ForEach(func(scope, v []byte) error {
+		scopeBucket := user.Bucket(scope)
+		if scopeBucket == nil {
+			return nil
+		}
+		return scopeBucket.ForEach(func(role, v []byte) error {
+			out[string(scope)] = append(out[string(scope)], string(role))
+			return nil
+		})
+	})
+	return out
+}
+
+func AuthorizeTx(tx *bolt.Tx, username string, priv UserPriviledge, cap Capability, subforum string) bool {
+	/*
+		Returns whether the user may use this
+		capability. subforum may be empty if the
+		action is not specific to a subforum, in which
+		case only global roles are considered.
+	*/
+	if RoleHasCapability(PriviledgeRole(priv), cap) {
+		return true
+	}
+	if username == "" || tx == nil {
+		return false
+	}
+	assigned := GetUserRolesTx(tx, username)
+	scopes := []string{GlobalScope}
+	if subforum != "" {
+		scopes = append(scopes, subforum)
+	}
+	for _, scope := range scopes {
+		for _, role := range assigned[scope] {
+			if RoleHasCapability(role, cap) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func Authorize(username string, priv UserPriviledge, cap Capability, subforum string) (ok bool) {
+	if db == nil || username == "" {
+		return AuthorizeTx(nil, username, priv, cap, subforum)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		ok = AuthorizeTx(tx, username, priv, cap, subforum)
+		return nil
+	}); err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+func AuthorizeAnyScope(username string, priv UserPriviledge, cap Capability) (ok bool) {
+	/*
+		Returns whether the user has this capability
+		either globally or in at least one subforum.
+	*/
+	if RoleHasCapability(PriviledgeRole(priv), cap) {
+		return true
+	}
+	if db == nil || username == "" {
+		return false
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		for _, roles := range GetUserRolesTx(tx, username) {
+			for _, role := range roles {
+				if RoleHasCapability(role, cap) {
+					ok = true
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+func CanPostInSubforum(username string, priv UserPriviledge, subforum string, required UserPriviledge) bool {
+	/*
+		required is the ThreadPriviledge or
+		ReplyPriviledge of the subforum.
+	*/
+	if priv.Is(required) {
+		return true
+	}
+	return Authorize(username, priv, CapRestrictedPost, subforum)
+}
+
+func AssignRole(username, role, scope string) error {
+	if _, ok := GetRoleCapabilities(role); !ok {
+		return ErrUnknownRole
+	}
+	if scope != GlobalScope {
+		if _, exists := SubforumExists(Configuration.Forum, scope); !exists {
+			return SubforumNotFound
+		}
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(DBUSERS).Bucket([]byte(username)) == nil {
+			return ErrUserNotFound
+		}
+		user, err := tx.Bucket(DBROLES).CreateBucketIfNotExists([]byte(username))
+		if err != nil {
+			return err
+		}
+		scopeBucket, err := user.CreateBucketIfNotExists([]byte(scope))
+		if err != nil {
+			return err
+		}
+		return scopeBucket.Put([]byte(role), []byte("1"))
+	})
+}
+
+func RemoveRole(username, role, scope string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBROLES).Bucket([]byte(username))
+		if user == nil {
+			return ErrRoleNotAssigned
+		}
+		scopeBucket := user.Bucket([]byte(scope))
+		if scopeBucket == nil || scopeBucket.Get([]byte(role)) == nil {
+			return ErrRoleNotAssigned
+		}
+		return scopeBucket.Delete([]byte(role))
+	})
+}
+
+func DescribeRoles() string {
+	/*
+		List every role and its capabilities
+	*/
+	var names []string
+	for name := range BuiltinRoles {
+		names = append(names, name)
+	}
+	if Configuration != nil {
+		for name := range Configuration.Roles {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		caps, _ := GetRoleCapabilities(name)
+		var capNames []string
+		for _, c := range caps {
+			capNames = append(capNames, string(c))
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", name, strings.Join(capNames, " ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func DescribeUserRoles(username string) (string, error) {
+	var lines []string
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(DBUSERS).Bucket([]byte(username)) == nil {
+			return ErrUserNotFound
+		}
+		priv := GetUserPriviledgeTx(tx, username)
+		lines = append(lines, fmt.Sprintf("priviledge: %s", priv))
+		assigned := GetUserRolesTx(tx, username)
+		var scopes []string
+		for scope := range assigned {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+		for _, scope := range scopes {
+			lines = append(lines, fmt.Sprintf("%s: %s", scope, strings.Join(assigned[scope], " ")))
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateRoles(t *testing.T) {
+	if err := ValidateRoles(map[string][]Capability{"helper": {CapConsole, CapLock}}); err != nil {
+		t.Errorf("Valid roles returned error %s", err)
+	}
+	if err := ValidateRoles(map[string][]Capability{"helper": {"fly"}}); !errors.Is(err, ErrUnknownCapability) {
+		t.Errorf("Expected ErrUnknownCapability, recieved %v", err)
+	}
+	if err := ValidateRoles(map[string][]Capability{"mod": {CapLock}}); !errors.Is(err, ErrBuiltinRoleName) {
+		t.Errorf("Expected ErrBuiltinRoleName, recieved %v", err)
+	}
+}
+
+func TestAuthorizeByPriviledge(t *testing.T) {
+	Configuration = &ConfigStr{}
+	for _, c := range TestAuthorizeByPriviledgeCases {
+		if result := AuthorizeTx(nil, "", c.Priv, c.Cap, ""); result != c.Expected {
+			t.Errorf("%s with capability %q: expected %t recieved %t", c.Priv, c.Cap, c.Expected, result)
+		}
+	}
+}
+
+var TestAuthorizeByPriviledgeCases = []struct {
+	Priv     UserPriviledge
+	Cap      Capability
+	Expected bool
+}{
+	{User, CapConsole, false},
+	{User, CapRestrictedPost, false},
+	{Mod, CapConsole, true},
+	{Mod, CapLock, true},
+	{Mod, CapManageUsers, false},
+	{Admin, CapManageUsers, true},
+	{Admin, CapRestrictedPost, true},
+}
@@ -34,19 +34,27 @@ func RootHandler(c *tls.Conn) gemini.ResponseFormat {
 		lines.Line(fmt.Sprintf("Currently logged in as %s.", DisplayUsername(username, priv)))
 		if isMuted {
 			lines.Line(fmt.Sprintf("Note: you are currently %s.", mStatus))
+			if mStatus.Reason != "" {
+				lines.Line(fmt.Sprintf("Reason: %s", mStatus.Reason))
+			}
+			lines.LinkDesc("/appeal/", "Appeal")
 		}
 		lines.Line(fmt.Sprintf("%s/logout/ Log out", gemini.Link))
+		lines.LinkDesc("/settings/", "Settings")
 	} else {
 		lines.Line("Currently not logged in.", fmt.Sprintf("%s/login/ Log in", gemini.Link))
 	}
 
 	lines.LinkDesc(" /register", "Register an account")
+	if Configuration.Smtp.Enabled && username == "" {
+		lines.LinkDesc(" /resend/", "Resend verification email")
+	}
 
 	lines.LinkDesc(" /search/", "Search")
 
 	lines.Line("")
 
-	if priv.Is(Mod) {
+	if AuthorizeAnyScope(username, priv, CapConsole) {
 		lines.LinkDesc("/console/", "Operator Console")
 	}
 
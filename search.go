@@ -30,6 +30,12 @@ func SearchHandler(u *url.URL, c *tls.Conn) gemini.Response {
 		}
 	}
 
+	var username string
+	if fp := GetFingerprint(c); fp != nil {
+		username, _, _, _ = GetUsernameFromFP(fp)
+	}
+	ignored := GetIgnoredUsers(username)
+
 	var lines gemini.Lines
 
 	if len(searchTerm) >= 2 && searchTerm[0] == '@' {
@@ -47,6 +53,11 @@ func SearchHandler(u *url.URL, c *tls.Conn) gemini.Response {
 			}
 		}
 		lines = append(lines, fmt.Sprintf("%s%s", gemini.Header, header), "")
+		if ignored[searchTerm[1:]] {
+			// hide everything written by an ignored user
+			lines.Line("You are ignoring this user.")
+			threads, posts = nil, nil
+		}
 
 		lines.Header(2, "Created threads")
 		for _, t := range threads {
@@ -85,8 +96,15 @@ func SearchHandler(u *url.URL, c *tls.Conn) gemini.Response {
 					continue
 				}
 
+				if IsPostHidden(post) {
+					continue
+				}
+
 				var newResult SearchResultPost
 				newResult.Author = string(post.Get([]byte("user")))
+				if ignored[newResult.Author] {
+					continue
+				}
 				newResult.Text = string(post.Get([]byte("text")))
 				newResult.ID = make([]byte, 16)
 				copy(newResult.ID, id)
@@ -99,6 +117,9 @@ func SearchHandler(u *url.URL, c *tls.Conn) gemini.Response {
 					log.Printf("Thread id %s not found", newResult.ThreadID)
 					continue
 				}
+				if IsThreadArchived(thread) {
+					continue
+				}
 
 				newResult.ThreadTitle = string(thread.Get([]byte("title")))
 				newResult.ThreadAuthor = string(thread.Get([]byte("user")))
@@ -166,10 +187,11 @@ func SearchUser(username string) (threads []SearchResultThread, posts []SearchRe
 			| lastmodified | 2022-10-04T20:22:28.548479-04:00 |
 			+--------------+----------------------------------+
 		*/
-		users := tx.Bucket(DBUSERS)
-		if users.Bucket([]byte(username)) == nil {
+		registered, exists := LookupUsernameTx(tx, username)
+		if !exists {
 			return SearchUsernameNotFound
 		}
+		username = registered
 		allPostsBucket := tx.Bucket(DBALLPOSTS)
 		allThreads := tx.Bucket(DBALLTHREADS)
 		userThreads := tx.Bucket(DBUSERTHREADS)
@@ -184,6 +206,9 @@ func SearchUser(username string) (threads []SearchResultThread, posts []SearchRe
 				if threadBucket == nil {
 					return errors.New("thread not found")
 				}
+				if IsThreadArchived(threadBucket) || IsFirstPostHiddenTx(tx, threadBucket) {
+					continue
+				}
 				threadInfo := SearchResultThread{}
 				threadInfo.Title = string(threadBucket.Get([]byte("title")))
 				threadInfo.Author = string(threadBucket.Get([]byte("user")))
@@ -237,6 +262,9 @@ func SearchUser(username string) (threads []SearchResultThread, posts []SearchRe
 					// don't include first post in thread
 					continue
 				}
+				if IsPostHidden(postBucket) {
+					continue
+				}
 				/*
 					TODO: parse time of post
 				*/
@@ -254,6 +282,9 @@ func SearchUser(username string) (threads []SearchResultThread, posts []SearchRe
 				if thisThread == nil {
 					fmt.Printf("Thread with id %s not found.\n", post.ThreadID)
 				}
+				if IsThreadArchived(thisThread) {
+					continue
+				}
 				post.ThreadTitle = string(thisThread.Get([]byte("title")))
 				post.ThreadAuthor = string(thisThread.Get([]byte("user")))
 
@@ -76,6 +76,18 @@ func sendPostToKeywordDB(author, text string, ID, threadID []byte) {
 	keywordDBchan <- current
 }
 
+func removePostFromKeywordDB(ID []byte) {
+	/*
+		index is nil during testing
+	*/
+	if index == nil {
+		return
+	}
+	if err := index.Delete(string(ID)); err != nil {
+		log.Printf("Error while removing post %s from keyword database: %s", ID, err.Error())
+	}
+}
+
 func keywordDBloop() {
 	for {
 		newIndex := <-keywordDBchan
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // time zones for servers without a tz database
+
+	"codeberg.org/FiskFan1999/gemini"
+	"github.com/hako/durafmt"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Values for UserSettings.Timestamps
+*/
+const (
+	TimestampsRelative = "relative" // "5 hours" for recent times (default)
+	TimestampsAbsolute = "absolute" // always print the date
+)
+
+/*
+Values for UserSettings.ThreadSort
+*/
+const (
+	ThreadSortActivity = "activity" // most recently modified first (default)
+	ThreadSortNewest   = "newest"   // most recently created first
+	ThreadSortOldest   = "oldest"   // first created first
+)
+
+const MaxPostsPerPage = 200
+
+var (
+	ErrInvalidTimeZone     = errors.New("Unknown time zone. Please use a name such as \"America/New_York\" or \"UTC\".")
+	ErrInvalidPostsPerPage = errors.New(fmt.Sprintf("Posts per page must be a number between 0 (all posts) and %d.", MaxPostsPerPage))
+	ErrInvalidThreadSort   = errors.New("Thread order must be one of: activity/newest/oldest")
+	ErrUnknownSetting      = errors.New("Unknown setting")
+)
+
+/*
+Settings stored per user in DBSETTINGS
+(key=username, sub-bucket key=setting name).
+Settings that are not set use the defaults
+of DefaultUserSettings.
+*/
+type UserSettings struct {
+	TimeZone       string
+	Timestamps     string
+	PostsPerPage   int // 0 = all posts on one page
+	ThreadSort     string
+	NotifyReplies  bool // email when someone replies to the user's thread
+	NotifyMentions bool // email when someone writes @username
+}
+
+var DefaultUserSettings = UserSettings{
+	TimeZone:     "UTC",
+	Timestamps:   TimestampsRelative,
+	PostsPerPage: 0,
+	ThreadSort:   ThreadSortActivity,
+}
+
+func (s UserSettings) Location() *time.Location {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (s UserSettings) FormatPostTime(t time.Time) string {
+	/*
+		If time is less than 24 hours old, print
+		the time since (unless absolute timestamps
+		are set)
+
+		Otherwise, print in the user's time zone
+	*/
+	if s.Timestamps != TimestampsAbsolute && time.Since(t).Hours() < float64(24) {
+		return fmt.Sprintf("%s", durafmt.ParseShort(time.Since(t)))
+	}
+	return t.In(s.Location()).Format(time.RFC1123)
+}
+
+func (s UserSettings) FormatFutureTime(t time.Time) string {
+	/*
+		Like FormatPostTime, for times which
+		have not happened yet (such as the end
+		of a ban).
+	*/
+	if s.Timestamps != TimestampsAbsolute && time.Until(t).Hours() < float64(24) {
+		return fmt.Sprintf("in %s", durafmt.ParseShort(time.Until(t)))
+	}
+	return t.In(s.Location()).Format(time.RFC1123)
+}
+
+func (s UserSettings) FormatThreadTime(t time.Time) string {
+	/*
+		If time is less than a week old, print
+		the time since (unless absolute timestamps
+		are set)
+
+		Otherwise, print the date in the user's time zone
+	*/
+	if s.Timestamps != TimestampsAbsolute && time.Since(t) < time.Hour*24*7 {
+		return fmt.Sprintf("%s", durafmt.ParseShort(time.Since(t)))
+	}
+	if s.Timestamps == TimestampsAbsolute {
+		return t.In(s.Location()).Format("02 Jan 2006 15:04 MST")
+	}
+	return t.In(s.Location()).Format("02 Jan 2006")
+}
+
+func getUserSettingsTx(tx *bolt.Tx, username string) UserSettings {
+	s := DefaultUserSettings
+	all := tx.Bucket(DBSETTINGS)
+	if all == nil {
+		return s
+	}
+	user := all.Bucket([]byte(username))
+	if user == nil {
+		return s
+	}
+	if v := user.Get([]byte("timezone")); v != nil {
+		s.TimeZone = string(v)
+	}
+	if v := user.Get([]byte("timestamps")); v != nil {
+		s.Timestamps = string(v)
+	}
+	if v := user.Get([]byte("postsperpage")); v != nil {
+		if n, err := strconv.Atoi(string(v)); err == nil {
+			s.PostsPerPage = n
+		}
+	}
+	if v := user.Get([]byte("threadsort")); v != nil {
+		s.ThreadSort = string(v)
+	}
+	s.NotifyReplies = bytes.Equal(user.Get([]byte("notifyreplies")), []byte("1"))
+	s.NotifyMentions = bytes.Equal(user.Get([]byte("notifymentions")), []byte("1"))
+	return s
+}
+
+func GetUserSettings(username string) (s UserSettings) {
+	s = DefaultUserSettings
+	if db == nil || username == "" {
+		return
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		s = getUserSettingsTx(tx, username)
+		return nil
+	}); err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+func GetSettingsFromConn(c *tls.Conn) UserSettings {
+	/*
+		Settings of the user logged in with this
+		connection, or the defaults.
+	*/
+	fp := GetFingerprint(c)
+	if fp == nil {
+		return DefaultUserSettings
+	}
+	username, _, _, _ := GetUsernameFromFP(fp)
+	return GetUserSettings(username)
+}
+
+func SetUserSetting(username, name, value string) error {
+	/*
+		Validate and write one setting.
+	*/
+	switch name {
+	case "timezone":
+		if _, err := time.LoadLocation(value); err != nil || value == "" || value == "Local" {
+			return ErrInvalidTimeZone
+		}
+	case "timestamps":
+		if value != TimestampsRelative && value != TimestampsAbsolute {
+			return ErrUnknownSetting
+		}
+	case "postsperpage":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > MaxPostsPerPage {
+			return ErrInvalidPostsPerPage
+		}
+	case "threadsort":
+		if value != ThreadSortActivity && value != ThreadSortNewest && value != ThreadSortOldest {
+			return ErrInvalidThreadSort
+		}
+	case "notifyreplies", "notifymentions":
+		if value != "0" && value != "1" {
+			return ErrUnknownSetting
+		}
+	default:
+		return ErrUnknownSetting
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		user, err := tx.Bucket(DBSETTINGS).CreateBucketIfNotExists([]byte(username))
+		if err != nil {
+			return err
+		}
+		return user.Put([]byte(name), []byte(value))
+	})
+}
+
+func SettingsHandler(u *url.URL, c *tls.Conn) gemini.Response {
+	fp := GetFingerprint(c)
+	if fp == nil {
+		return CertRequired
+	}
+	username, _, _, _ := GetUsernameFromFP(fp)
+	if username == "" {
+		return UnauthorizedCert
+	}
+
+	parts := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
+	if len(parts) == 1 {
+		return SettingsPage(username)
+	}
+	if parts[1] == "2fa" {
+		return TwoFactorSettingsHandler(u, username, parts)
+	}
+	if len(parts) != 2 {
+		return gemini.BadRequest.Response("Bad request")
+	}
+
+	settings := GetUserSettings(username)
+	var value string
+	switch parts[1] {
+	case "timestamps":
+		// toggle
+		value = TimestampsAbsolute
+		if settings.Timestamps == TimestampsAbsolute {
+			value = TimestampsRelative
+		}
+	case "notifyreplies":
+		value = boolSetting(!settings.NotifyReplies)
+	case "notifymentions":
+		value = boolSetting(!settings.NotifyMentions)
+	case "postnudge":
+		// show the posting reminder again
+		if err := db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(DBUSERS).Bucket([]byte(username)).Put([]byte("postnudge"), []byte("1"))
+		}); err != nil {
+			return gemini.TemporaryFailure.Error(err)
+		}
+		return gemini.RedirectTemporary.Response("/settings/")
+	case "ignore":
+		if u.RawQuery == "" {
+			return gemini.Input.Response("Username to ignore")
+		}
+		ignored, err := url.QueryUnescape(u.RawQuery)
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		if err := IgnoreUser(username, strings.TrimSpace(ignored)); err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		return gemini.RedirectTemporary.Response("/settings/")
+	case "timezone", "postsperpage", "threadsort":
+		if u.RawQuery == "" {
+			return gemini.Input.Response(settingsPrompts[parts[1]])
+		}
+		var err error
+		value, err = url.QueryUnescape(u.RawQuery)
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		value = strings.TrimSpace(value)
+	default:
+		return NotFound
+	}
+
+	if err := SetUserSetting(username, parts[1], value); err != nil {
+		return gemini.BadRequest.Error(err)
+	}
+	return gemini.RedirectTemporary.Response("/settings/")
+}
+
+var settingsPrompts = map[string]string{
+	"timezone":     "Time zone (such as America/New_York or UTC)",
+	"postsperpage": fmt.Sprintf("Posts per page (0 = all posts, maximum %d)", MaxPostsPerPage),
+	"threadsort":   "Thread order in subforums (activity/newest/oldest)",
+}
+
+func boolSetting(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func SettingsPage(username string) gemini.Response {
+	settings := GetUserSettings(username)
+
+	postNudgeSeen := true
+	if err := CheckForPostNudge(username); errors.Is(err, ShouldPostNudge) {
+		postNudgeSeen = false
+	} else if err != nil {
+		return gemini.TemporaryFailure.Error(err)
+	}
+
+	postsPerPage := "all"
+	if settings.PostsPerPage > 0 {
+		postsPerPage = strconv.Itoa(settings.PostsPerPage)
+	}
+
+	lines := gemini.Lines{}
+	lines.Header(1, fmt.Sprintf("Settings for %s", username))
+	lines.Line("")
+	lines.Header(2, "Display")
+	lines.LinkDesc("/settings/timezone/", fmt.Sprintf("Time zone: %s", settings.TimeZone))
+	lines.LinkDesc("/settings/timestamps/", fmt.Sprintf("Timestamps: %s", settings.Timestamps))
+	lines.LinkDesc("/settings/postsperpage/", fmt.Sprintf("Posts per page: %s", postsPerPage))
+	lines.LinkDesc("/settings/threadsort/", fmt.Sprintf("Thread order: %s", settings.ThreadSort))
+	lines.Header(2, "Email notifications")
+	lines.LinkDesc("/settings/notifyreplies/", fmt.Sprintf("Replies to my threads: %s", onOff(settings.NotifyReplies)))
+	lines.LinkDesc("/settings/notifymentions/", fmt.Sprintf("Mentions of @%s: %s", username, onOff(settings.NotifyMentions)))
+	lines.Header(2, "Security")
+	lines.LinkDesc("/settings/2fa/", fmt.Sprintf("Two-factor authentication: %s", onOff(HasTwoFactor(username))))
+	lines.Header(2, "Ignored users")
+	for _, ignored := range ListIgnoredUsers(username) {
+		lines.LinkDesc(fmt.Sprintf("/user/%s/unignore/", url.PathEscape(ignored)), fmt.Sprintf("Stop ignoring %s", ignored))
+	}
+	lines.LinkDesc("/settings/ignore/", "Ignore a user")
+	lines.Header(2, "Posting reminder")
+	if postNudgeSeen {
+		lines.LinkDesc("/settings/postnudge/", "Seen (click to show again)")
+	} else {
+		lines.Line("Not seen yet")
+	}
+	lines.Line("")
+	lines.LinkDesc("/", "Go to home.")
+
+	return gemini.ResponseFormat{
+		Status: gemini.Success,
+		Mime:   "text/gemini",
+		Lines:  lines,
+	}
+}
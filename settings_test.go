@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestUserSettingsFormat(t *testing.T) {
+	old := time.Date(2020, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+	if s := DefaultUserSettings.FormatPostTime(old); s != "Thu, 02 Jan 2020 15:04:05 UTC" {
+		t.Errorf("Default post time: recieved %q", s)
+	}
+	if s := DefaultUserSettings.FormatThreadTime(old); s != "02 Jan 2020" {
+		t.Errorf("Default thread time: recieved %q", s)
+	}
+
+	settings := DefaultUserSettings
+	settings.TimeZone = "Asia/Tokyo"
+	if s := settings.FormatPostTime(old); s != "Fri, 03 Jan 2020 00:04:05 JST" {
+		t.Errorf("Post time in time zone: recieved %q", s)
+	}
+
+	settings.Timestamps = TimestampsAbsolute
+	recent := time.Now().Add(-time.Minute)
+	if s := settings.FormatThreadTime(recent); s != recent.In(settings.Location()).Format("02 Jan 2006 15:04 MST") {
+		t.Errorf("Absolute thread time: recieved %q", s)
+	}
+}
+
+func TestGetMentions(t *testing.T) {
+	mentions := GetMentions("@alice hello @bob, and @alice again. email@example @zoë-ann @日本_1")
+	if !cmp.Equal(mentions, []string{"alice", "bob", "example", "zoë", "日本_1"}) {
+		t.Errorf("recieved %q", mentions)
+	}
+}
+
+func TestSetUserSetting(t *testing.T) {
+	Configuration = &ConfigStr{}
+	var err error
+	var testDBpath string = ".testing/TestSetUserSetting.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if s := GetUserSettings("alice"); s != DefaultUserSettings {
+		t.Errorf("Expected default settings, recieved %+v", s)
+	}
+
+	for _, c := range []struct {
+		Name, Value string
+		Err         error
+	}{
+		{"timezone", "Nowhere/Nothing", ErrInvalidTimeZone},
+		{"timezone", "Europe/Paris", nil},
+		{"postsperpage", "-1", ErrInvalidPostsPerPage},
+		{"postsperpage", "10", nil},
+		{"threadsort", "alphabetical", ErrInvalidThreadSort},
+		{"threadsort", ThreadSortOldest, nil},
+		{"notifyreplies", "1", nil},
+		{"colour", "blue", ErrUnknownSetting},
+	} {
+		if err := SetUserSetting("alice", c.Name, c.Value); !errors.Is(err, c.Err) {
+			t.Errorf("%s=%s: expected error %v, recieved %v", c.Name, c.Value, c.Err, err)
+		}
+	}
+
+	expected := UserSettings{
+		TimeZone:      "Europe/Paris",
+		Timestamps:    TimestampsRelative,
+		PostsPerPage:  10,
+		ThreadSort:    ThreadSortOldest,
+		NotifyReplies: true,
+	}
+	if s := GetUserSettings("alice"); s != expected {
+		t.Error(cmp.Diff(expected, s))
+	}
+}
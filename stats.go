@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Account and forum information for the
+"user" and "stats" console commands.
+*/
+
+func countKeys(b *bolt.Bucket) (n int) {
+	if b == nil {
+		return 0
+	}
+	b.ForEach(func(k, v []byte) error {
+		n++
+		return nil
+	})
+	return
+}
+
+type UserInfo struct {
+	Name          string
+	Priviledge    UserPriviledge
+	Email         string
+	EmailVerified bool
+	Approved      bool
+	Verified      bool
+	TwoFactor     bool
+	Registered    time.Time
+	Muted         bool
+	MutedStatus   MutedStatus
+	Ban           Ban
+	Banned        bool
+	Certificates  int
+	Threads       int
+	Posts         int
+	RenamedFrom   []string
+}
+
+func (u UserInfo) String() string {
+	account := "verified"
+	switch {
+	case !u.EmailVerified:
+		account = "awaiting email verification"
+	case !u.Approved:
+		account = "awaiting approval"
+	case !u.Verified:
+		account = "not verified"
+	}
+	muted := "no"
+	if u.Muted {
+		muted = u.MutedStatus.String()
+		if u.MutedStatus.Reason != "" {
+			muted += fmt.Sprintf(" - %s", u.MutedStatus.Reason)
+		}
+	}
+	banned := "no"
+	if u.Banned {
+		banned = u.Ban.String()
+	}
+	twoFactor := "disabled"
+	if u.TwoFactor {
+		twoFactor = "enabled"
+	}
+	lines := []string{
+		fmt.Sprintf("User: %s", u.Name),
+		fmt.Sprintf("Priviledge: %s", u.Priviledge),
+		fmt.Sprintf("Email: %s", u.Email),
+		fmt.Sprintf("Account: %s", account),
+		fmt.Sprintf("Two-factor authentication: %s", twoFactor),
+		fmt.Sprintf("Registered: %s", u.Registered.UTC().Format(time.RFC1123)),
+		fmt.Sprintf("Muted: %s", muted),
+		fmt.Sprintf("Banned: %s", banned),
+		fmt.Sprintf("Certificates: %d", u.Certificates),
+		fmt.Sprintf("Threads: %d", u.Threads),
+		fmt.Sprintf("Posts: %d", u.Posts),
+	}
+	if len(u.RenamedFrom) != 0 {
+		lines = append(lines, fmt.Sprintf("Previous names: %s", strings.Join(u.RenamedFrom, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func GetUserInfo(username string) (u UserInfo, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		/*
+			Accept any capitalization, or
+			a previous name of the user.
+		*/
+		name, ok := LookupUsernameTx(tx, username)
+		if !ok {
+			if name, ok = LookupRenamedUserTx(tx, username); !ok {
+				return ErrUserNotFound
+			}
+		}
+		user := tx.Bucket(DBUSERS).Bucket([]byte(name))
+		if user == nil {
+			return ErrUserNotFound
+		}
+		u.Name = name
+		u.Priviledge = GetUserPriviledgeTx(tx, name)
+		u.Email = string(user.Get([]byte("email")))
+		u.EmailVerified = bytes.Equal(user.Get([]byte("emailverified")), []byte("1"))
+		u.Approved = !IsAwaitingApproval(user)
+		u.Verified = bytes.Equal(user.Get([]byte("verified")), []byte("1"))
+		u.TwoFactor = HasTwoFactorTx(user)
+		if registered := user.Get([]byte("registered")); registered != nil {
+			if err := u.Registered.UnmarshalText(registered); err != nil {
+				return err
+			}
+		}
+		u.Muted, u.MutedStatus = userMutedStatus(user)
+		u.Ban, u.Banned = IsUserBannedTx(tx, name)
+
+		tx.Bucket(DBFP).ForEach(func(k, v []byte) error {
+			if string(v) == name {
+				u.Certificates++
+			}
+			return nil
+		})
+		u.Threads = countKeys(tx.Bucket(DBUSERTHREADS).Bucket([]byte(name)))
+		u.Posts = countKeys(tx.Bucket(DBUSERPOSTS).Bucket([]byte(name)))
+
+		if renames := tx.Bucket(DBRENAMES); renames != nil {
+			renames.ForEach(func(k, v []byte) error {
+				if string(v) == name {
+					u.RenamedFrom = append(u.RenamedFrom, string(k))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return
+}
+
+type SubforumStats struct {
+	ID      string
+	Threads int
+	Posts   int
+}
+
+type ForumStats struct {
+	Users        int
+	Threads      int
+	Posts        int
+	Subforums    []SubforumStats
+	OpenReports  int
+	HeldPosts    int
+	DatabaseSize int64
+	IndexedPosts uint64
+	IndexLoaded  bool
+	UsersWeek    int // registered in the last 7 days
+	ThreadsDay   int
+	ThreadsWeek  int
+	PostsDay     int
+	PostsWeek    int
+	PostsPerDay  float64 // average over the last 7 days
+}
+
+func (s ForumStats) String() string {
+	index := "not loaded"
+	if s.IndexLoaded {
+		index = fmt.Sprintf("%d posts", s.IndexedPosts)
+	}
+	lines := []string{
+		fmt.Sprintf("Users: %d", s.Users),
+		fmt.Sprintf("Threads: %d", s.Threads),
+		fmt.Sprintf("Posts: %d", s.Posts),
+		fmt.Sprintf("Open reports: %d", s.OpenReports),
+		fmt.Sprintf("Posts awaiting approval: %d", s.HeldPosts),
+		fmt.Sprintf("Database size: %d bytes", s.DatabaseSize),
+		fmt.Sprintf("Search index: %s", index),
+		"",
+		"Subforums:",
+	}
+	for _, sf := range s.Subforums {
+		lines = append(lines, fmt.Sprintf("%s: %d threads, %d posts", sf.ID, sf.Threads, sf.Posts))
+	}
+	lines = append(lines,
+		"",
+		"Recent activity:",
+		fmt.Sprintf("New users in the last 7 days: %d", s.UsersWeek),
+		fmt.Sprintf("New threads in the last day: %d, last 7 days: %d", s.ThreadsDay, s.ThreadsWeek),
+		fmt.Sprintf("Posts in the last day: %d, last 7 days: %d (%.1f per day)", s.PostsDay, s.PostsWeek, s.PostsPerDay),
+	)
+	return strings.Join(lines, "\n")
+}
+
+func GetForumStats() (s ForumStats, err error) {
+	now := time.Now()
+	day := now.Add(-24 * time.Hour)
+	week := now.Add(-7 * 24 * time.Hour)
+	firstPostIndex := itob(1)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		s.DatabaseSize = tx.Size()
+
+		users := tx.Bucket(DBUSERS)
+		if err := users.ForEach(func(k, v []byte) error {
+			user := users.Bucket(k)
+			if user == nil {
+				return nil
+			}
+			s.Users++
+			var registered time.Time
+			if err := registered.UnmarshalText(user.Get([]byte("registered"))); err == nil && registered.After(week) {
+				s.UsersWeek++
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		/*
+			Threads and posts per subforum,
+			in the order of the configuration
+		*/
+		perSubforum := map[string]*SubforumStats{}
+		for _, id := range GetAllSubforumIDs() {
+			s.Subforums = append(s.Subforums, SubforumStats{ID: id})
+		}
+		for i := range s.Subforums {
+			perSubforum[s.Subforums[i].ID] = &s.Subforums[i]
+		}
+		threads := tx.Bucket(DBALLTHREADS)
+		threadToSubforum := tx.Bucket(DBTHREADTOSF)
+		if err := threads.ForEach(func(k, v []byte) error {
+			thread := threads.Bucket(k)
+			if thread == nil {
+				return nil
+			}
+			s.Threads++
+			if sf, ok := perSubforum[string(threadToSubforum.Get(k))]; ok {
+				sf.Threads++
+				sf.Posts += countKeys(thread.Bucket([]byte("posts")))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		posts := tx.Bucket(DBALLPOSTS)
+		if err := posts.ForEach(func(k, v []byte) error {
+			post := posts.Bucket(k)
+			if post == nil {
+				return nil
+			}
+			s.Posts++
+			var written time.Time
+			if err := written.UnmarshalText(post.Get([]byte("time"))); err != nil || !written.After(week) {
+				return nil
+			}
+			newThread := bytes.Equal(post.Get([]byte("index")), firstPostIndex)
+			s.PostsWeek++
+			if newThread {
+				s.ThreadsWeek++
+			}
+			if written.After(day) {
+				s.PostsDay++
+				if newThread {
+					s.ThreadsDay++
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		s.PostsPerDay = float64(s.PostsWeek) / 7
+
+		reports := tx.Bucket(DBREPORTS)
+		reports.ForEach(func(k, v []byte) error {
+			if report := reports.Bucket(k); report != nil && string(report.Get([]byte("status"))) == ReportOpen {
+				s.OpenReports++
+			}
+			return nil
+		})
+		s.HeldPosts = countKeys(tx.Bucket(DBHELD))
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	if index != nil {
+		if s.IndexedPosts, err = index.DocCount(); err != nil {
+			return
+		}
+		s.IndexLoaded = true
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestUserAndStatsCommands(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}, Subforum{"second subforum", "secondsub", 0, 0}}}},
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestUserAndStatsCommands.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 2)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 2, Response: []byte("30 /\r\n")},
+	)
+	OnNewThread("firstsub", "alice", "first thread", "hello")
+	OnNewPost("bob", "0000000000000001", "hi", false)
+	OnNewPost("bob", "0000000000000001", "hi again", false)
+	ConsoleCommand("alice", Admin, "mute bob permanent")
+
+	info, status := ConsoleCommand("alice", Admin, "user BOB")
+	if status != gemini.Success {
+		t.Fatalf("Recieved %d %q", status, info)
+	}
+	for _, line := range []string{
+		"User: bob",
+		"Priviledge: User",
+		"Email: bob@example.net",
+		"Account: verified",
+		"Muted: permanently muted",
+		"Banned: no",
+		"Certificates: 1",
+		"Threads: 0",
+		"Posts: 2",
+	} {
+		if !strings.Contains(info+"\n", line+"\n") {
+			t.Errorf("%q not in %q", line, info)
+		}
+	}
+	if info, _ := ConsoleCommand("alice", Admin, "user alice"); !strings.Contains(info, "Threads: 1\nPosts: 1") {
+		t.Errorf("Recieved %q", info)
+	}
+	if info, status := ConsoleCommand("alice", Admin, "user nobody"); status != gemini.BadRequest || info != ErrUserNotFound.Error() {
+		t.Errorf("Recieved %d %q", status, info)
+	}
+
+	stats, status := ConsoleCommand("alice", Admin, "stats")
+	if status != gemini.Success {
+		t.Fatalf("Recieved %d %q", status, stats)
+	}
+	for _, line := range []string{
+		"Users: 2\nThreads: 1\nPosts: 3\nOpen reports: 0\nPosts awaiting approval: 0\n",
+		"Subforums:\nfirstsub: 1 threads, 3 posts\nsecondsub: 0 threads, 0 posts\n",
+		"New users in the last 7 days: 2\nNew threads in the last day: 1, last 7 days: 1\nPosts in the last day: 3, last 7 days: 3 (0.4 per day)",
+	} {
+		if !strings.Contains(stats, line) {
+			t.Errorf("%q not in %q", line, stats)
+		}
+	}
+}
@@ -8,10 +8,8 @@ import (
 	"net/url"
 	"sort"
 	"strings"
-	"time"
 
 	"codeberg.org/FiskFan1999/gemini"
-	"github.com/hako/durafmt"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -29,7 +27,7 @@ var NotFound = gemini.ResponseFormat{
 
 var ErrNotFound = errors.New("Subforum not found in database")
 
-func GetThreadsForSubforum(subforum string) (threads SubforumThreads, err error) {
+func GetThreadsForSubforum(subforum string, order string) (threads SubforumThreads, err error) {
 	if err = db.View(func(tx *bolt.Tx) error {
 		subforumBucketParent := tx.Bucket(DBSUBFORUMS)
 		if subforumBucketParent == nil {
@@ -55,7 +53,11 @@ func GetThreadsForSubforum(subforum string) (threads SubforumThreads, err error)
 				return err
 			}
 			t.Locked = bytes.Equal(threadInfo.Get([]byte("locked")), []byte("1"))
-			t.Archived = bytes.Equal(threadInfo.Get([]byte("archived")), []byte("1"))
+			t.Archived = IsThreadArchived(threadInfo)
+			if t.Archived {
+				continue
+			}
+			t.Hidden = IsFirstPostHiddenTx(tx, threadInfo)
 
 			threads = append(threads, t)
 		}
@@ -64,7 +66,15 @@ func GetThreadsForSubforum(subforum string) (threads SubforumThreads, err error)
 		return
 	}
 
-	sort.Sort(threads)
+	switch order {
+	case ThreadSortNewest:
+		// thread IDs are in order of creation
+		sort.SliceStable(threads, func(i, j int) bool { return bytes.Compare(threads[i].ID, threads[j].ID) > 0 })
+	case ThreadSortOldest:
+		sort.SliceStable(threads, func(i, j int) bool { return bytes.Compare(threads[i].ID, threads[j].ID) < 0 })
+	default:
+		sort.Sort(threads)
+	}
 	return
 }
 
@@ -81,6 +91,7 @@ func SubforumIndexHandler(u *url.URL, c *tls.Conn) gemini.Response {
 		return BadUserInput
 	}
 	subforumID := pathspl[1]
+	settings := GetSettingsFromConn(c)
 	name, exists := SubforumExists(Configuration.Forum, subforumID)
 	if !exists {
 		return NotFound
@@ -90,16 +101,34 @@ func SubforumIndexHandler(u *url.URL, c *tls.Conn) gemini.Response {
 
 	lines = append(lines, fmt.Sprintf("%s/new/thread/%s Post new thread", gemini.Link, subforumID), "")
 
-	threads, err := GetThreadsForSubforum(subforumID)
+	threads, err := GetThreadsForSubforum(subforumID, settings.ThreadSort)
 	if err != nil {
 		fmt.Println(err.Error())
 		return gemini.TemporaryFailure.Error(err)
 	}
 
+	/*
+		Moderators see the titles of threads
+		whose first post is hidden.
+	*/
+	var username string
+	var userPriv UserPriviledge
+	if fp := GetFingerprint(c); fp != nil {
+		username, userPriv, _, _ = GetUsernameFromFP(fp)
+	}
+	canReview := Authorize(username, userPriv, CapReadReports, subforumID)
+
 	for _, t := range threads {
+		if t.Hidden && !canReview {
+			lines = append(lines, fmt.Sprintf("Thread by %s hidden pending review", t.User))
+			continue
+		}
 		var buf bytes.Buffer
 		// timeSinceMod := time.Since(t.LastModified)
-		fmt.Fprintf(&buf, "%s/thread/%s/ %s (%s)", gemini.Link, t.ID, t.Title, TimeFormatForThread(t.LastModified))
+		fmt.Fprintf(&buf, "%s/thread/%s/ %s (%s)", gemini.Link, t.ID, t.Title, settings.FormatThreadTime(t.LastModified))
+		if t.Hidden {
+			buf.WriteString(" - hidden pending review")
+		}
 		lines = append(lines, buf.String())
 	}
 
@@ -110,18 +139,6 @@ func SubforumIndexHandler(u *url.URL, c *tls.Conn) gemini.Response {
 	}
 }
 
-func TimeFormatForThread(t time.Time) string {
-	/*
-		If time is less than 24 hours old, print
-
-		Otherwise, print UTC in some format
-	*/
-	if time.Since(t) < time.Hour*24*7 {
-		return fmt.Sprintf("%s", durafmt.ParseShort(time.Since(t)))
-	}
-	return t.UTC().Format("02 Jan 2006")
-}
-
 func SubforumExists(rootforum []Forum, id string) (string, bool) {
 	for _, f := range rootforum {
 		for _, sub := range f.Subforum {
@@ -14,9 +14,15 @@ import (
 	bolt "go.etcd.io/bbolt"
 )
 
-var CurrentlyMutedResponse = gemini.BadRequest.Response("You are currently muted")
+func CurrentlyMutedResponse(m MutedStatus) gemini.Response {
+	return gemini.BadRequest.Response(m.Message())
+}
 
-var whichPrivCanReplyToLockedThread UserPriviledge = Mod
+/*
+Users with this capability in the subforum
+may still reply to locked threads.
+*/
+var whichCapCanReplyToLockedThread Capability = CapLock
 
 var ErrThreadIsLocked = errors.New("Thread is locked")
 
@@ -54,9 +60,24 @@ type Thread struct {
 	LastModified time.Time
 	Locked       bool
 	Archived     bool
+	Hidden       bool // first post hidden pending review
+}
+
+func IsThreadArchived(thread *bolt.Bucket) bool {
+	/*
+		Archived threads are not shown in
+		the subforum, and can not be read
+		or replied to.
+	*/
+	return bytes.Equal(thread.Get([]byte("archived")), []byte("1"))
 }
 
-func OnNewPost(username, threadID, text string, userPriv UserPriviledge) gemini.Response {
+func OnNewPost(username, threadID, text string, canReplyLocked bool) gemini.Response {
+	match := CheckFilter(FilterText, text)
+	if match != nil && match.Action == FilterReject {
+		return gemini.BadRequest.Error(match)
+	}
+	holdReason := HoldReason(username, match)
 	if err := db.Update(func(tx *bolt.Tx) error {
 		/*
 			Get thread sub-bucket
@@ -67,49 +88,72 @@ func OnNewPost(username, threadID, text string, userPriv UserPriviledge) gemini.
 		}
 
 		thread := threads.Bucket([]byte(threadID))
-		if thread == nil {
+		if thread == nil || IsThreadArchived(thread) {
 			// not found
 			return ErrNotFound
 		}
 
-		if bytes.Equal(thread.Get([]byte("locked")), []byte("1")) && !userPriv.Is(whichPrivCanReplyToLockedThread) {
+		if bytes.Equal(thread.Get([]byte("locked")), []byte("1")) && !canReplyLocked {
 			/*
 				Thread locked and is not moderator
 			*/
+			if reason := thread.Get([]byte("lockreason")); len(reason) != 0 {
+				return fmt.Errorf("%w. Reason: %s", ErrThreadIsLocked, reason)
+			}
 			return ErrThreadIsLocked
 		}
 
-		// change LastModified time
-		nowBytes, err := time.Now().MarshalText()
-		if err != nil {
-			return err
+		if holdReason != "" {
+			return holdPostTx(tx, HeldPost{User: username, Thread: threadID, Text: text, Reason: holdReason})
 		}
-		thread.Put([]byte("lastmodified"), nowBytes)
 
-		err, postID := AddNewPostToDatabase(tx, text, username, nowBytes, []byte(threadID), thread)
+		nowBytes, err := time.Now().MarshalText()
 		if err != nil {
 			return err
 		}
-		sendPostToKeywordDB(username, text, itob(postID), []byte(threadID))
-
-		return nil
+		return addPostTx(tx, thread, username, threadID, text, nowBytes, match)
 	}); err != nil {
 		return gemini.TemporaryFailure.Error(err)
 	}
+	if holdReason != "" {
+		return HeldPostResponse(fmt.Sprintf("/thread/%s/", threadID))
+	}
+	go SendNotifications(username, threadID, text)
 	return gemini.RedirectTemporary.Response(fmt.Sprintf("/thread/%s/", threadID))
 }
 
+func addPostTx(tx *bolt.Tx, thread *bolt.Bucket, username, threadID, text string, nowBytes []byte, match *FilterMatch) error {
+	/*
+		Write a reply into the thread and the
+		keyword index. Also used when a held
+		post is approved.
+	*/
+
+	// change LastModified time
+	thread.Put([]byte("lastmodified"), nowBytes)
+
+	err, postID := AddNewPostToDatabase(tx, text, username, nowBytes, []byte(threadID), thread)
+	if err != nil {
+		return err
+	}
+	if err := flagPostTx(tx, itob(postID), match); err != nil {
+		return err
+	}
+	sendPostToKeywordDB(username, text, itob(postID), []byte(threadID))
+	return nil
+}
+
 func NewPostHandler(u *url.URL, c *tls.Conn) gemini.Response {
 	fp := GetFingerprint(c)
 	if fp == nil {
 		return CertRequired
 	}
-	username, userPriv, isMuted, _ := GetUsernameFromFP(fp)
+	username, userPriv, isMuted, mStatus := GetUsernameFromFP(fp)
 	if username == "" {
 		return UnauthorizedCert
 	}
 	if isMuted {
-		return CurrentlyMutedResponse
+		return CurrentlyMutedResponse(mStatus)
 	}
 
 	parts := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
@@ -141,7 +185,7 @@ func NewPostHandler(u *url.URL, c *tls.Conn) gemini.Response {
 		return gemini.BadRequest.Error(err)
 	}
 
-	if !userPriv.Is(threadPriv) {
+	if !CanPostInSubforum(username, userPriv, subforumID, threadPriv) {
 		return gemini.BadRequest.Response("User is not priviledged to reply on this subforum.")
 	}
 
@@ -160,7 +204,7 @@ func NewPostHandler(u *url.URL, c *tls.Conn) gemini.Response {
 	if err != nil {
 		return gemini.TemporaryFailure.Error(err)
 	}
-	return OnNewPost(username, id, text, userPriv)
+	return OnNewPost(username, id, text, Authorize(username, userPriv, whichCapCanReplyToLockedThread, subforumID))
 }
 
 func AddNewPostToDatabase(tx *bolt.Tx, text string, username string, nowBytes []byte, threadIDBytes []byte, thread *bolt.Bucket) (err error, postID uint64) {
@@ -262,6 +306,10 @@ func ValidateThreadTitle(t string) error {
 			return TitleIllegalCharacter
 		}
 	}
+	// content filter (see filter.go)
+	if match := CheckFilter(FilterTitle, title); match != nil {
+		return match
+	}
 	return nil
 }
 
@@ -270,7 +318,8 @@ func OnNewThread(subforum, username, title, text string) gemini.Response {
 		Steps:
 		1. In thread bucket, create sub-bucket (key=NextSequence) (now referred to as thread bucket)
 		In this bucket, title=Title, user=Username, lastmodified=time.Now().MarshalText() (for sorting)
-		locked="0" ("1": don't allow new posts) archived="0" ("1": do not show in lists etc.)
+		locked="0" ("1": don't allow new posts) lockreason=reason given when locked
+		archived="0" ("1": do not show in lists etc.)
 		posts=sub-bucket
 
 		2. All referral to thread (by id) in the userthreads bucket for sorting
@@ -299,104 +348,182 @@ func OnNewThread(subforum, username, title, text string) gemini.Response {
 		Validate thread title
 	*/
 	title = strings.TrimSpace(title)
+	var match *FilterMatch
 	if err := ValidateThreadTitle(title); err != nil {
-		return gemini.BadRequest.Error(err)
-	}
-	if err := db.Update(func(tx *bolt.Tx) error {
 		/*
-			1. In subforum bucket, create sub-bucket (key=NextSequence) (now referred to as thread bucket)
-			In this bucket, title=Title, author=Username, lastmodified=time.Now().MarshalText() (for sorting)
-			locked="0" ("1": don't allow new posts) archived="0" ("1": do not show in lists etc.)
-			posts=sub-bucket
+			Titles which are held or flagged by the
+			content filter are handled after the
+			thread is created.
 		*/
-		threads := tx.Bucket(DBALLTHREADS)
-		if threads == nil {
-			return errors.New("threads == nil")
+		if !errors.As(err, &match) || match.Action == FilterReject {
+			return gemini.BadRequest.Error(err)
 		}
+	}
+	match = strongerFilterMatch(match, CheckFilter(FilterText, text))
+	if match != nil && match.Action == FilterReject {
+		return gemini.BadRequest.Error(match)
+	}
 
-		threadID, err := threads.NextSequence()
-		if err != nil {
-			return err
+	if holdReason := HoldReason(username, match); holdReason != "" {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			if tx.Bucket(DBSUBFORUMS).Bucket([]byte(subforum)) == nil {
+				return errors.New("subforumBucketSub == nil")
+			}
+			return holdPostTx(tx, HeldPost{User: username, Subforum: subforum, Title: title, Text: text, Reason: holdReason})
+		}); err != nil {
+			return gemini.TemporaryFailure.Error(err)
 		}
+		return HeldPostResponse(fmt.Sprintf("/f/%s/", subforum))
+	}
 
-		threadIDBytes := itob(threadID)
-		thread, err := threads.CreateBucket(threadIDBytes)
+	var newThreadID string
+	if err := db.Update(func(tx *bolt.Tx) error {
+		nowBytes, err := time.Now().MarshalText()
 		if err != nil {
 			return err
 		}
+		newThreadID, err = addThreadTx(tx, subforum, username, title, text, nowBytes, match)
+		return err
+	}); err != nil {
+		return gemini.TemporaryFailure.Error(err)
+	}
+	go SendNotifications(username, newThreadID, text)
 
-		thread.Put([]byte("title"), []byte(title))
-		thread.Put([]byte("user"), []byte(username))
-		thread.Put([]byte("locked"), []byte("0"))
-		thread.Put([]byte("archived"), []byte("0"))
+	return gemini.RedirectTemporary.Response(fmt.Sprintf("/f/%s/", subforum))
 
-		nowBytes, err := time.Now().MarshalText()
-		if err != nil {
-			return err
-		}
-		thread.Put([]byte("lastmodified"), nowBytes)
+}
 
-		if _, err := thread.CreateBucket([]byte("posts")); err != nil {
-			return err
-		}
+func addThreadTx(tx *bolt.Tx, subforum, username, title, text string, nowBytes []byte, match *FilterMatch) (string, error) {
+	/*
+		Steps 1-7 of OnNewThread. Also used when
+		a held thread is approved.
 
-		/*
-			2. All referral to thread (by id) in the userthreads bucket for sorting
-			user sub-bucket within userthreads bucket, key=NextSequence value=thread id
-		*/
-		userthreads := tx.Bucket(DBUSERTHREADS)
-		if userthreads == nil {
-			return errors.New("userthreads == nil")
-		}
+		1. In subforum bucket, create sub-bucket (key=NextSequence) (now referred to as thread bucket)
+		In this bucket, title=Title, author=Username, lastmodified=time.Now().MarshalText() (for sorting)
+		locked="0" ("1": don't allow new posts) lockreason=reason given when locked
+		archived="0" ("1": do not show in lists etc.)
+		posts=sub-bucket
+	*/
+	threads := tx.Bucket(DBALLTHREADS)
+	if threads == nil {
+		return "", errors.New("threads == nil")
+	}
 
-		userthreadsSub, err := userthreads.CreateBucketIfNotExists([]byte(username))
-		if err != nil {
-			return err
-		}
-		userthreadsSubNext, err := userthreadsSub.NextSequence()
-		if err != nil {
-			return err
-		}
+	threadID, err := threads.NextSequence()
+	if err != nil {
+		return "", err
+	}
 
-		userthreadsSub.Put(itob(userthreadsSubNext), threadIDBytes)
-		err, postID := AddNewPostToDatabase(tx, text, username, nowBytes, threadIDBytes, thread)
-		if err != nil {
-			return err
-		}
+	threadIDBytes := itob(threadID)
+	newThreadID := string(threadIDBytes)
+	thread, err := threads.CreateBucket(threadIDBytes)
+	if err != nil {
+		return "", err
+	}
 
-		sendPostToKeywordDB(username, text, itob(postID), itob(threadID))
+	thread.Put([]byte("title"), []byte(title))
+	thread.Put([]byte("user"), []byte(username))
+	thread.Put([]byte("locked"), []byte("0"))
+	thread.Put([]byte("archived"), []byte("0"))
+	thread.Put([]byte("lastmodified"), nowBytes)
 
-		/*
-			6. Add reference to thread in subforum bucket (key=NextSequence, val=Thread ID)
-		*/
-		subforumBucket := tx.Bucket(DBSUBFORUMS)
-		if subforumBucket == nil {
-			return errors.New("subforumBucket == nil")
-		}
-		subforumBucketSub := subforumBucket.Bucket([]byte(subforum))
-		if subforumBucketSub == nil {
-			return errors.New("subforumBucketSub == nil")
-		}
+	if _, err := thread.CreateBucket([]byte("posts")); err != nil {
+		return "", err
+	}
 
-		sfbsNext, err := subforumBucketSub.NextSequence()
-		if err != nil {
-			return err
-		}
-		subforumBucketSub.Put(itob(sfbsNext), threadIDBytes)
+	/*
+		2. All referral to thread (by id) in the userthreads bucket for sorting
+		user sub-bucket within userthreads bucket, key=NextSequence value=thread id
+	*/
+	userthreads := tx.Bucket(DBUSERTHREADS)
+	if userthreads == nil {
+		return "", errors.New("userthreads == nil")
+	}
 
-		/*
-			7. Add key=threadID val=subforumID pair in DBTHREADTOSF
-		*/
-		threadToSubf := tx.Bucket(DBTHREADTOSF)
-		threadToSubf.Put(threadIDBytes, []byte(subforum))
+	userthreadsSub, err := userthreads.CreateBucketIfNotExists([]byte(username))
+	if err != nil {
+		return "", err
+	}
+	userthreadsSubNext, err := userthreadsSub.NextSequence()
+	if err != nil {
+		return "", err
+	}
 
-		return nil
-	}); err != nil {
-		return gemini.TemporaryFailure.Error(err)
+	userthreadsSub.Put(itob(userthreadsSubNext), threadIDBytes)
+	err, postID := AddNewPostToDatabase(tx, text, username, nowBytes, threadIDBytes, thread)
+	if err != nil {
+		return "", err
+	}
+	if err := flagPostTx(tx, itob(postID), match); err != nil {
+		return "", err
 	}
 
-	return gemini.RedirectTemporary.Response(fmt.Sprintf("/f/%s/", subforum))
+	sendPostToKeywordDB(username, text, itob(postID), itob(threadID))
+
+	/*
+		6. Add reference to thread in subforum bucket (key=NextSequence, val=Thread ID)
+	*/
+	subforumBucket := tx.Bucket(DBSUBFORUMS)
+	if subforumBucket == nil {
+		return "", errors.New("subforumBucket == nil")
+	}
+	subforumBucketSub := subforumBucket.Bucket([]byte(subforum))
+	if subforumBucketSub == nil {
+		return "", errors.New("subforumBucketSub == nil")
+	}
+
+	sfbsNext, err := subforumBucketSub.NextSequence()
+	if err != nil {
+		return "", err
+	}
+	subforumBucketSub.Put(itob(sfbsNext), threadIDBytes)
+
+	/*
+		7. Add key=threadID val=subforumID pair in DBTHREADTOSF
+	*/
+	threadToSubf := tx.Bucket(DBTHREADTOSF)
+	threadToSubf.Put(threadIDBytes, []byte(subforum))
+
+	return newThreadID, nil
+}
 
+var ErrSameSubforum = errors.New("The thread is already in this subforum.")
+
+func MoveThread(id, to string) error {
+	/*
+		Move a thread to another subforum.
+		Listings are sorted by thread ID, so
+		the position of the reference in the
+		new subforum bucket does not matter.
+	*/
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(DBALLTHREADS).Bucket([]byte(id)) == nil {
+			return errors.New("Thread not found.")
+		}
+		subforums := tx.Bucket(DBSUBFORUMS)
+		destination := subforums.Bucket([]byte(to))
+		if destination == nil {
+			return ErrNotFound
+		}
+		threadToSubf := tx.Bucket(DBTHREADTOSF)
+		from := threadToSubf.Get([]byte(id))
+		if string(from) == to {
+			return ErrSameSubforum
+		}
+		if source := subforums.Bucket(from); source != nil {
+			if err := removeValues(source, [][]byte{[]byte(id)}); err != nil {
+				return err
+			}
+		}
+		seq, err := destination.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := destination.Put(itob(seq), []byte(id)); err != nil {
+			return err
+		}
+		return threadToSubf.Put([]byte(id), []byte(to))
+	})
 }
 
 func CreateThreadHandler(u *url.URL, c *tls.Conn) gemini.Response {
@@ -405,12 +532,12 @@ func CreateThreadHandler(u *url.URL, c *tls.Conn) gemini.Response {
 	if fp == nil {
 		return CertRequired
 	}
-	username, userPriv, isMuted, _ := GetUsernameFromFP(fp)
+	username, userPriv, isMuted, mStatus := GetUsernameFromFP(fp)
 	if username == "" {
 		return UnauthorizedCert
 	}
 	if isMuted {
-		return CurrentlyMutedResponse
+		return CurrentlyMutedResponse(mStatus)
 	}
 
 	if err := CheckForPostNudge(username); errors.Is(err, ShouldPostNudge) {
@@ -433,7 +560,7 @@ func CreateThreadHandler(u *url.URL, c *tls.Conn) gemini.Response {
 	if err != nil {
 		return gemini.BadRequest.Error(err)
 	}
-	if !userPriv.Is(threadPriv) {
+	if !CanPostInSubforum(username, userPriv, subforum, threadPriv) {
 		// user is not authorized to make threads in this subforum
 		return gemini.BadRequest.Response("User is not authorized to make a thread in this subforum")
 	}
@@ -75,7 +75,7 @@ func TestCreateThread(t *testing.T) {
 	serv.Check(gemtest.Input{URL: "/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")})
 	serv.Check(gemtest.Input{URL: "/", Cert: 0, Response: []byte("20 text/gemini\r\n# \r\n\r\nCurrently not logged in.\r\n=> /login/ Log in\r\n=>  /register Register an account\r\n=>  /search/ Search\r\n\r\n## first forum\r\n=> /f/firstsub/ first subforum\r\n\r\n# Source code\r\nlarigot is open-source software. You may download the source code from the following link.\r\n=> https://github.com/ObieSource/larigot\r\n")})
 	serv.Check(gemtest.Input{URL: "/", Cert: 2, Response: []byte("20 text/gemini\r\n# \r\n\r\nCurrently not logged in.\r\n=> /login/ Log in\r\n=>  /register Register an account\r\n=>  /search/ Search\r\n\r\n## first forum\r\n=> /f/firstsub/ first subforum\r\n\r\n# Source code\r\nlarigot is open-source software. You may download the source code from the following link.\r\n=> https://github.com/ObieSource/larigot\r\n")})
-	serv.Check(gemtest.Input{URL: "/", Cert: 1, Response: []byte("20 text/gemini\r\n# \r\n\r\nCurrently logged in as alice.\r\n=> /logout/ Log out\r\n=>  /register Register an account\r\n=>  /search/ Search\r\n\r\n## first forum\r\n=> /f/firstsub/ first subforum\r\n\r\n# Source code\r\nlarigot is open-source software. You may download the source code from the following link.\r\n=> https://github.com/ObieSource/larigot\r\n")})
+	serv.Check(gemtest.Input{URL: "/", Cert: 1, Response: []byte("20 text/gemini\r\n# \r\n\r\nCurrently logged in as alice.\r\n=> /logout/ Log out\r\n=> /settings/ Settings\r\n=>  /register Register an account\r\n=>  /search/ Search\r\n\r\n## first forum\r\n=> /f/firstsub/ first subforum\r\n\r\n# Source code\r\nlarigot is open-source software. You may download the source code from the following link.\r\n=> https://github.com/ObieSource/larigot\r\n")})
 	serv.Check(gemtest.Input{URL: "/new/thread/firstsub/", Cert: 0, Response: []byte("60 Client certificate required\r\n")})
 	serv.Check(gemtest.Input{URL: "/new/thread/other/", Cert: 1, Response: PostNudgeHandler(urlParse, nil).Bytes()})
 	serv.Check(gemtest.Input{URL: "/new/thread/other/", Cert: 1, Response: []byte("59 Subforum not found\r\n")})
@@ -142,3 +142,75 @@ var TestValidateThreadTitleCases = map[string]error{
 	strings.Repeat("a", TitleMaxLength+1):  TitleTooLong,
 	strings.Repeat("a", TitleMaxLength):    nil,
 }
+
+func TestMoveThread(t *testing.T) {
+	Configuration = &ConfigStr{
+		Forum: []Forum{Forum{"first forum", []Subforum{Subforum{"first subforum", "firstsub", 0, 0}, Subforum{"second subforum", "secondsub", 0, 0}}}},
+		Roles: map[string][]Capability{
+			"mover": {CapConsole, CapMove},
+		},
+	}
+	var testDBpath string = ".testing/TestMoveThread.db"
+	os.Remove(testDBpath)
+	var err error
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket(DBUSERS).CreateBucket([]byte("carol"))
+		return err
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	OnNewThread("firstsub", "carol", "first thread", "hello")
+	OnNewThread("firstsub", "carol", "second thread", "hello")
+
+	threadIDs := func(subforum string) string {
+		threads, err := GetThreadsForSubforum(subforum, ThreadSortOldest)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		var ids []string
+		for _, thread := range threads {
+			ids = append(ids, string(thread.ID))
+		}
+		return strings.Join(ids, " ")
+	}
+
+	/*
+		The role must be held in both subforums
+	*/
+	if err := AssignRole("carol", "mover", "firstsub"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if response, _ := ConsoleCommand("carol", User, "move 0000000000000001 secondsub"); response != CommandUnauthorized {
+		t.Errorf("Recieved %q", response)
+	}
+	if err := AssignRole("carol", "mover", "secondsub"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if response, _ := ConsoleCommand("carol", User, "move 0000000000000001 secondsub"); response != "Thread has been moved." {
+		t.Errorf("Recieved %q", response)
+	}
+	if ids := threadIDs("firstsub"); ids != "0000000000000002" {
+		t.Errorf("Incorrect threads in firstsub: %s", ids)
+	}
+	if ids := threadIDs("secondsub"); ids != "0000000000000001" {
+		t.Errorf("Incorrect threads in secondsub: %s", ids)
+	}
+	if subforum := GetSubforumOfThread([]byte("0000000000000001")); subforum != "secondsub" {
+		t.Errorf("Recieved %q", subforum)
+	}
+	if err := MoveThread("0000000000000001", "secondsub"); !errors.Is(err, ErrSameSubforum) {
+		t.Errorf("Expected ErrSameSubforum, recieved %v", err)
+	}
+	if err := MoveThread("0000000000000001", "nowhere"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, recieved %v", err)
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Two-factor authentication (TOTP, RFC 6238)
+
+In the user bucket:
+totpsecret=base32 secret (set = 2FA enabled)
+totppending=base32 secret shown during enrolment
+totplast=last time step that was used (no replays)
+recovery=sub-bucket key=sha256 of recovery code val="1"
+totpreset="1" after an administrator reset 2FA
+(the next login only needs the password)
+*/
+
+const (
+	TOTPDigits        = 6
+	TOTPPeriod        = 30 // seconds
+	TOTPSkew          = 1  // accept codes this many periods early or late
+	RecoveryCodeCount = 10
+	TwoFactorAttempts = 5 // wrong codes before the password must be entered again
+)
+
+var TwoFactorLoginTimeout = time.Minute * 5
+
+var (
+	ErrTwoFactorNotEnabled  = errors.New("Two-factor authentication is not enabled.")
+	ErrTwoFactorEnabled     = errors.New("Two-factor authentication is already enabled.")
+	ErrTwoFactorInvalidCode = errors.New("Invalid code.")
+	ErrTwoFactorNoLogin     = errors.New("Please log in with your username and password first.")
+	ErrTwoFactorRequired    = errors.New("Moderators must enable two-factor authentication at /settings/2fa/ before using the console.")
+	ErrTwoFactorLogin       = errors.New("Moderators must enable two-factor authentication before logging in with a new certificate. Enable it at /settings/2fa/ from a certificate which is already logged in, or ask an administrator to reset it.")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+func TOTPCode(secret string, step uint64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], step)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, value%1000000), nil
+}
+
+func TOTPStep(t time.Time) uint64 {
+	return uint64(t.Unix()) / TOTPPeriod
+}
+
+func validateTOTP(secret, code string, lastStep uint64, now time.Time) (uint64, bool) {
+	/*
+		Returns the time step of the code, which
+		must be after the last step used.
+	*/
+	code = strings.TrimSpace(code)
+	current := TOTPStep(now)
+	for i := -TOTPSkew; i <= TOTPSkew; i++ {
+		step := current + uint64(i)
+		if step <= lastStep {
+			continue
+		}
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func TOTPURI(username, secret string) string {
+	issuer := Configuration.ForumName
+	if issuer == "" {
+		issuer = "larigot"
+	}
+	label := url.PathEscape(fmt.Sprintf("%s:%s", issuer, username))
+	return fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s&digits=%d&period=%d", label, secret, url.QueryEscape(issuer), TOTPDigits, TOTPPeriod)
+}
+
+func hashRecoveryCode(code string) []byte {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+func newRecoveryCodesTx(user *bolt.Bucket) ([]string, error) {
+	if user.Bucket([]byte("recovery")) != nil {
+		if err := user.DeleteBucket([]byte("recovery")); err != nil {
+			return nil, err
+		}
+	}
+	recovery, err := user.CreateBucket([]byte("recovery"))
+	if err != nil {
+		return nil, err
+	}
+	var codes []string
+	for i := 0; i < RecoveryCodeCount; i++ {
+		random := make([]byte, 5)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(random))
+		if err := recovery.Put(hashRecoveryCode(code), []byte("1")); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func HasTwoFactorTx(user *bolt.Bucket) bool {
+	return len(user.Get([]byte("totpsecret"))) != 0
+}
+
+func HasTwoFactor(username string) (enabled bool) {
+	db.View(func(tx *bolt.Tx) error {
+		if user := tx.Bucket(DBUSERS).Bucket([]byte(username)); user != nil {
+			enabled = HasTwoFactorTx(user)
+		}
+		return nil
+	})
+	return
+}
+
+func CheckTwoFactorCode(username, code string) error {
+	/*
+		Accepts a TOTP code or an unused
+		recovery code (which is then removed).
+	*/
+	return db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil {
+			return UserNotFound
+		}
+		if !HasTwoFactorTx(user) {
+			return ErrTwoFactorNotEnabled
+		}
+		lastStep, _ := strconv.ParseUint(string(user.Get([]byte("totplast"))), 10, 64)
+		if step, ok := validateTOTP(string(user.Get([]byte("totpsecret"))), code, lastStep, time.Now()); ok {
+			return user.Put([]byte("totplast"), []byte(strconv.FormatUint(step, 10)))
+		}
+		if recovery := user.Bucket([]byte("recovery")); recovery != nil {
+			hash := hashRecoveryCode(code)
+			if recovery.Get(hash) != nil {
+				return recovery.Delete(hash)
+			}
+		}
+		return ErrTwoFactorInvalidCode
+	})
+}
+
+func BeginTwoFactorEnrolment(username string) (secret string, err error) {
+	/*
+		Returns the secret which is waiting to
+		be confirmed, creating it if necessary.
+	*/
+	err = db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil {
+			return UserNotFound
+		}
+		if HasTwoFactorTx(user) {
+			return ErrTwoFactorEnabled
+		}
+		if pending := user.Get([]byte("totppending")); len(pending) != 0 {
+			secret = string(pending)
+			return nil
+		}
+		var err error
+		if secret, err = NewTOTPSecret(); err != nil {
+			return err
+		}
+		return user.Put([]byte("totppending"), []byte(secret))
+	})
+	return
+}
+
+func ConfirmTwoFactorEnrolment(username, code string) (recoveryCodes []string, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil {
+			return UserNotFound
+		}
+		if HasTwoFactorTx(user) {
+			return ErrTwoFactorEnabled
+		}
+		secret := string(user.Get([]byte("totppending")))
+		step, ok := validateTOTP(secret, code, 0, time.Now())
+		if secret == "" || !ok {
+			return ErrTwoFactorInvalidCode
+		}
+		user.Put([]byte("totpsecret"), []byte(secret))
+		user.Put([]byte("totppending"), []byte(""))
+		user.Put([]byte("totplast"), []byte(strconv.FormatUint(step, 10)))
+		var err error
+		recoveryCodes, err = newRecoveryCodesTx(user)
+		return err
+	})
+	return
+}
+
+func DisableTwoFactor(username, code string) error {
+	if err := CheckTwoFactorCode(username, code); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		user.Put([]byte("totpsecret"), []byte(""))
+		user.Put([]byte("totplast"), []byte(""))
+		if user.Bucket([]byte("recovery")) != nil {
+			return user.DeleteBucket([]byte("recovery"))
+		}
+		return nil
+	})
+}
+
+func ResetTwoFactor(username string) error {
+	/*
+		For users who lost both their
+		authenticator and recovery codes. They
+		may then log in once with only the
+		password, and enrol again.
+	*/
+	return db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil {
+			return ErrUserNotFound
+		}
+		for _, k := range []string{"totpsecret", "totppending", "totplast"} {
+			if err := user.Put([]byte(k), []byte("")); err != nil {
+				return err
+			}
+		}
+		if user.Bucket([]byte("recovery")) != nil {
+			if err := user.DeleteBucket([]byte("recovery")); err != nil {
+				return err
+			}
+		}
+		return user.Put([]byte("totpreset"), []byte("1"))
+	})
+}
+
+func useTwoFactorReset(username string) (reset bool) {
+	/*
+		Whether an administrator reset 2FA since
+		the last login. The reset is only used
+		once.
+	*/
+	if err := db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil || string(user.Get([]byte("totpreset"))) != "1" {
+			return nil
+		}
+		reset = true
+		return user.Delete([]byte("totpreset"))
+	}); err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+func RegenerateRecoveryCodes(username, code string) (codes []string, err error) {
+	if err = CheckTwoFactorCode(username, code); err != nil {
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		var err error
+		codes, err = newRecoveryCodesTx(tx.Bucket(DBUSERS).Bucket([]byte(username)))
+		return err
+	})
+	return
+}
+
+func TwoFactorRequired(username string, priv UserPriviledge) bool {
+	/*
+		Every user who may use the console,
+		including users given the "console"
+		capability by a role.
+	*/
+	return Configuration.TwoFactor.RequireForMods && AuthorizeAnyScope(username, priv, CapConsole)
+}
+
+/*
+Logins waiting for a two-factor code, by
+certificate fingerprint. The certificate is
+only bound to the user once the code is
+entered.
+*/
+type pendingTwoFactorLogin struct {
+	Username string
+	Expires  time.Time
+	Attempts int
+}
+
+var (
+	pendingTwoFactorLogins      = map[string]*pendingTwoFactorLogin{}
+	pendingTwoFactorLoginsMutex sync.Mutex
+)
+
+func beginTwoFactorLogin(fp []byte, username string) {
+	pendingTwoFactorLoginsMutex.Lock()
+	defer pendingTwoFactorLoginsMutex.Unlock()
+	// forget logins which were never finished
+	now := time.Now()
+	for key, pending := range pendingTwoFactorLogins {
+		if now.After(pending.Expires) {
+			delete(pendingTwoFactorLogins, key)
+		}
+	}
+	pendingTwoFactorLogins[string(fp)] = &pendingTwoFactorLogin{
+		Username: username,
+		Expires:  time.Now().Add(TwoFactorLoginTimeout),
+	}
+}
+
+func finishTwoFactorLogin(fp []byte, username, code string) error {
+	pendingTwoFactorLoginsMutex.Lock()
+	defer pendingTwoFactorLoginsMutex.Unlock()
+	pending, ok := pendingTwoFactorLogins[string(fp)]
+	if !ok || pending.Username != username || time.Now().After(pending.Expires) {
+		delete(pendingTwoFactorLogins, string(fp))
+		return ErrTwoFactorNoLogin
+	}
+	if err := CheckTwoFactorCode(username, code); err != nil {
+		pending.Attempts++
+		if pending.Attempts >= TwoFactorAttempts {
+			delete(pendingTwoFactorLogins, string(fp))
+		}
+		return err
+	}
+	delete(pendingTwoFactorLogins, string(fp))
+	return db.Update(func(tx *bolt.Tx) error {
+		if ban, banned := IsUserBannedTx(tx, username); banned {
+			// banned after the password was entered
+			return errors.New(ban.Message(getUserSettingsTx(tx, username)))
+		}
+		return tx.Bucket(DBFP).Put(fp, []byte(username))
+	})
+}
+
+func TwoFactorSettingsHandler(u *url.URL, username string, parts []string) gemini.Response {
+	/*
+		/settings/2fa/
+		/settings/2fa/confirm/?code
+		/settings/2fa/disable/?code
+		/settings/2fa/recovery/?code
+	*/
+	if len(parts) == 2 {
+		return TwoFactorSettingsPage(username)
+	}
+	if len(parts) != 3 {
+		return gemini.BadRequest.Response("Bad request")
+	}
+	if u.RawQuery == "" {
+		return gemini.SensitiveInput.Response("Code from your authenticator app")
+	}
+	code, err := url.QueryUnescape(u.RawQuery)
+	if err != nil {
+		return gemini.BadRequest.Error(err)
+	}
+
+	var recoveryCodes []string
+	switch parts[2] {
+	case "confirm":
+		recoveryCodes, err = ConfirmTwoFactorEnrolment(username, code)
+	case "recovery":
+		recoveryCodes, err = RegenerateRecoveryCodes(username, code)
+	case "disable":
+		if err := DisableTwoFactor(username, code); err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		return gemini.RedirectTemporary.Response("/settings/2fa/")
+	default:
+		return NotFound
+	}
+	if err != nil {
+		return gemini.BadRequest.Error(err)
+	}
+
+	lines := gemini.Lines{}
+	lines.Header(1, "Recovery codes")
+	lines.Line("Each of these codes may be used once instead of a code from your authenticator app. Keep them somewhere safe. They will not be shown again.")
+	lines.Pre(recoveryCodes...)
+	lines.LinkDesc("/settings/2fa/", "Back")
+	return gemini.ResponseFormat{
+		Status: gemini.Success,
+		Mime:   "text/gemini",
+		Lines:  lines,
+	}
+}
+
+func TwoFactorSettingsPage(username string) gemini.Response {
+	lines := gemini.Lines{}
+	lines.Header(1, "Two-factor authentication")
+	if HasTwoFactor(username) {
+		lines.Line("Two-factor authentication is enabled.")
+		lines.LinkDesc("/settings/2fa/recovery/", "Create new recovery codes")
+		lines.LinkDesc("/settings/2fa/disable/", "Disable two-factor authentication")
+	} else {
+		secret, err := BeginTwoFactorEnrolment(username)
+		if err != nil {
+			return gemini.TemporaryFailure.Error(err)
+		}
+		lines.Line("Add this account to your authenticator app, then confirm with a code from the app.")
+		lines.LinkDesc(TOTPURI(username, secret), "Add to authenticator app")
+		lines.Line(fmt.Sprintf("Secret: %s", secret))
+		lines.LinkDesc("/settings/2fa/confirm/", "Confirm")
+	}
+	lines.Line("")
+	lines.LinkDesc("/settings/", "Back to settings")
+	return gemini.ResponseFormat{
+		Status: gemini.Success,
+		Mime:   "text/gemini",
+		Lines:  lines,
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestTOTPCode(t *testing.T) {
+	/*
+		Test vectors from RFC 6238 appendix B
+		(SHA1, last 6 of the 8 digits)
+	*/
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if code != expected {
+			t.Errorf("Time %d: expected %s, recieved %s", unix, expected, code)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	step := TOTPStep(now)
+	code, _ := TOTPCode(secret, step)
+	if _, ok := validateTOTP(secret, code, 0, now.Add(time.Second*TOTPPeriod)); !ok {
+		t.Error("Code from the previous period was not accepted")
+	}
+	if _, ok := validateTOTP(secret, code, step, now); ok {
+		t.Error("Code was accepted twice")
+	}
+	if _, ok := validateTOTP(secret, code, 0, now.Add(time.Minute*5)); ok {
+		t.Error("Old code was accepted")
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	Configuration = &ConfigStr{
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestTwoFactorLogin.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 3)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 1, Response: []byte("30 /\r\n")},
+	)
+	Configuration.TwoFactor.RequireForMods = true
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 1, Response: []byte("61 Moderators must enable two-factor authentication at /settings/2fa/ before using the console.\r\n")},
+		// a new certificate can not be logged in with only the password
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 2, Response: []byte("61 " + ErrTwoFactorLogin.Error() + "\r\n")},
+		gemtest.Input{URL: "gemini://localhost/settings/", Cert: 2, Response: []byte("61 Unauthorized\r\n")},
+	)
+
+	secret, err := BeginTwoFactorEnrolment("alice")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	code := func(offset int) string {
+		c, err := TOTPCode(secret, TOTPStep(time.Now())+uint64(offset))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return c
+	}
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/settings/2fa/", Cert: 1, Response: []byte("20 text/gemini\r\n# Two-factor authentication\r\nAdd this account to your authenticator app, then confirm with a code from the app.\r\n=> otpauth://totp/larigot:alice?secret=" + secret + "&issuer=larigot&digits=6&period=30 Add to authenticator app\r\nSecret: " + secret + "\r\n=> /settings/2fa/confirm/ Confirm\r\n\r\n=> /settings/ Back to settings\r\n")},
+		gemtest.Input{URL: "gemini://localhost/settings/2fa/confirm/?000000x", Cert: 1, Response: []byte("59 Invalid code.\r\n")},
+	)
+	if _, err := ConfirmTwoFactorEnrolment("alice", code(0)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 1, Response: []byte("10 Enter command\r\n")},
+
+		/*
+			The certificate is only bound after the code is entered
+		*/
+		gemtest.Input{URL: "gemini://localhost/login/alice/2fa/?" + code(1), Cert: 2, Response: []byte("59 Please log in with your username and password first.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 2, Response: []byte("30 /login/alice/2fa/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/2fa/", Cert: 2, Response: []byte("11 Two-factor code (or recovery code)\r\n")},
+		gemtest.Input{URL: "gemini://localhost/settings/", Cert: 2, Response: []byte("61 Unauthorized\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/2fa/?000000x", Cert: 2, Response: []byte("59 Invalid code.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/2fa/?" + code(1), Cert: 2, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 2, Response: []byte("10 Enter command\r\n")},
+	)
+
+	/*
+		After an administrator resets 2FA, the
+		next login only needs the password, so
+		that the user can enrol again.
+	*/
+	if response, status := ConsoleCommand("alice", Admin, "reset2fa nobody"); status != gemini.BadRequest || response != ErrUserNotFound.Error() {
+		t.Errorf("Recieved %d %q", status, response)
+	}
+	if response, status := ConsoleCommand("alice", Admin, "reset2fa alice"); status != gemini.Success || response != "Two-factor authentication has been reset." {
+		t.Errorf("Recieved %d %q", status, response)
+	}
+	if HasTwoFactor("alice") {
+		t.Error("Two-factor authentication was not reset")
+	}
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 3, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/console/", Cert: 3, Response: []byte("61 Moderators must enable two-factor authentication at /settings/2fa/ before using the console.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/alice/?password", Cert: 3, Response: []byte("61 " + ErrTwoFactorLogin.Error() + "\r\n")},
+	)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	Configuration = &ConfigStr{}
+	var err error
+	var testDBpath string = ".testing/TestRecoveryCodes.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var codes []string
+	if err := db.Update(func(tx *bolt.Tx) error {
+		user, err := tx.Bucket(DBUSERS).CreateBucket([]byte("alice"))
+		if err != nil {
+			return err
+		}
+		user.Put([]byte("totpsecret"), []byte(totpEncoding.EncodeToString([]byte("12345678901234567890"))))
+		codes, err = newRecoveryCodesTx(user)
+		return err
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, recieved %d", RecoveryCodeCount, len(codes))
+	}
+
+	if err := CheckTwoFactorCode("alice", codes[0]); err != nil {
+		t.Errorf("Recovery code was not accepted: %v", err)
+	}
+	if err := CheckTwoFactorCode("alice", codes[0]); !errors.Is(err, ErrTwoFactorInvalidCode) {
+		t.Errorf("Recovery code was accepted twice: %v", err)
+	}
+	if err := DisableTwoFactor("alice", codes[1]); err != nil {
+		t.Fatal(err.Error())
+	}
+	if HasTwoFactor("alice") {
+		t.Error("Two-factor authentication was not disabled")
+	}
+	if err := CheckTwoFactorCode("alice", codes[2]); !errors.Is(err, ErrTwoFactorNotEnabled) {
+		t.Errorf("Expected ErrTwoFactorNotEnabled, recieved %v", err)
+	}
+}
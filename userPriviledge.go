@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 type UserPriviledge uint8
@@ -48,3 +53,91 @@ func GetUserPriviledge(u []byte) UserPriviledge {
 		return UserPriviledge(ui)
 	}
 }
+
+var ErrInvalidPriviledge = errors.New("Invalid priviledge level. Allowed values: user/mod/admin")
+
+func ParseUserPriviledge(s string) (UserPriviledge, error) {
+	/*
+		Accepts either the name of the level
+		("user", "mod", "admin") or its number.
+	*/
+	for _, p := range []UserPriviledge{User, Mod, Admin} {
+		if strings.EqualFold(s, p.String()) || s == string(p.Write()) {
+			return p, nil
+		}
+	}
+	return User, ErrInvalidPriviledge
+}
+
+/*
+Key in the user bucket which holds
+the priviledge level written with
+UserPriviledge.Write()
+*/
+var DBUSERPRIV = []byte("priviledge")
+
+func GetUserPriviledgeTx(tx *bolt.Tx, username string) UserPriviledge {
+	/*
+		Entries in Configuration.Priviledges
+		always override the value in the
+		database.
+	*/
+	if priv, ok := Configuration.Priviledges[username]; ok {
+		return priv
+	}
+	users := tx.Bucket(DBUSERS)
+	if users == nil {
+		return User
+	}
+	user := users.Bucket([]byte(username))
+	if user == nil {
+		return User
+	}
+	stored := user.Get(DBUSERPRIV)
+	if stored == nil {
+		return User
+	}
+	return GetUserPriviledge(stored)
+}
+
+func LookupUserPriviledge(username string) (priv UserPriviledge) {
+	if db == nil {
+		return Configuration.Priviledges[username]
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		priv = GetUserPriviledgeTx(tx, username)
+		return nil
+	}); err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+var (
+	ErrPromoteNotHigher = errors.New("User already has this priviledge level or higher")
+	ErrDemoteNotLower   = errors.New("User already has this priviledge level or lower")
+)
+
+func ChangeUserPriviledge(username string, level UserPriviledge, promote bool) error {
+	/*
+		promote: the new level must be higher than the current one.
+		!promote: the new level must be lower than the current one.
+	*/
+	return db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil {
+			return ErrUserNotFound
+		}
+		var current UserPriviledge
+		if stored := user.Get(DBUSERPRIV); stored != nil {
+			current = GetUserPriviledge(stored)
+		}
+		if promote && current.Is(level) {
+			return ErrPromoteNotHigher
+		}
+		if !promote && level.Is(current) {
+			return ErrDemoteNotLower
+		}
+		return user.Put(DBUSERPRIV, level.Write())
+	})
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"os"
 	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 func TestUserPriviledgeWriteRead(t *testing.T) {
@@ -46,3 +50,72 @@ func TestUserPriviledge(t *testing.T) {
 		t.Error("Admin.Is(Admin) != false")
 	}
 }
+
+func TestParseUserPriviledge(t *testing.T) {
+	for in, expected := range TestParseUserPriviledgeCases {
+		result, err := ParseUserPriviledge(in)
+		if expected == nil {
+			if err == nil {
+				t.Errorf("For input %q, expected an error but recieved %s", in, result)
+			}
+			continue
+		}
+		if err != nil || result != *expected {
+			t.Errorf("For input %q, expected %s but recieved %s (%v)", in, *expected, result, err)
+		}
+	}
+}
+
+var (
+	testUser  = User
+	testMod   = Mod
+	testAdmin = Admin
+)
+
+var TestParseUserPriviledgeCases = map[string]*UserPriviledge{
+	"user":   &testUser,
+	"Mod":    &testMod,
+	"ADMIN":  &testAdmin,
+	"0":      &testUser,
+	"2":      &testAdmin,
+	"3":      nil,
+	"wizard": nil,
+	"":       nil,
+}
+
+func TestConfigPriviledgeNotStored(t *testing.T) {
+	Configuration = &ConfigStr{
+		Priviledges: map[string]UserPriviledge{
+			"alice": Admin,
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestConfigPriviledgeNotStored.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := OnRegister("alice", "alice@example.net", "password", ""); err != nil {
+		t.Fatal(err.Error())
+	}
+	if priv := LookupUserPriviledge("alice"); priv != Admin {
+		t.Errorf("Expected Admin, recieved %s", priv)
+	}
+
+	/*
+		Removing the name from the configuration
+		file takes the priviledge away.
+	*/
+	Configuration.Priviledges = nil
+	if priv := LookupUserPriviledge("alice"); priv != User {
+		t.Errorf("Expected User, recieved %s", priv)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Case-insensitive username index
+
+DBUSERNAMES key=CanonicalUsername(username)
+val=username as it was registered
+
+Confusable username index (see confusables.go)
+
+DBSKELETONS key=Skeleton(username)
+val=username as it was registered
+*/
+
+var (
+	ErrUsernameReserved   = errors.New("This username is reserved.")
+	ErrUsernameConfusable = errors.New("This username looks too similar to an existing user.")
+	ErrUsernameRenamed    = errors.New("This username previously belonged to another user.")
+)
+
+func CanonicalUsername(username string) string {
+	return strings.ToLower(username)
+}
+
+func LookupUsernameTx(tx *bolt.Tx, username string) (string, bool) {
+	/*
+		Returns the registered spelling of this
+		username, ignoring case. An exact match
+		is always preferred.
+	*/
+	if tx.Bucket(DBUSERS).Bucket([]byte(username)) != nil {
+		return username, true
+	}
+	index := tx.Bucket(DBUSERNAMES)
+	if index == nil {
+		// database was created before the index
+		return lookupUsernameScanTx(tx, username)
+	}
+	registered := index.Get([]byte(CanonicalUsername(username)))
+	if registered == nil {
+		return "", false
+	}
+	return string(registered), true
+}
+
+func lookupUsernameScanTx(tx *bolt.Tx, username string) (found string, ok bool) {
+	users := tx.Bucket(DBUSERS)
+	users.ForEach(func(k, v []byte) error {
+		if !ok && strings.EqualFold(string(k), username) {
+			found, ok = string(k), true
+		}
+		return nil
+	})
+	return
+}
+
+func LookupUsername(username string) (found string, ok bool) {
+	if err := db.View(func(tx *bolt.Tx) error {
+		found, ok = LookupUsernameTx(tx, username)
+		return nil
+	}); err != nil {
+		log.Println(err.Error())
+	}
+	return
+}
+
+func IsUsernameReserved(username string) bool {
+	/*
+		Names given a priviledge in the
+		configuration file may still be
+		registered with that exact spelling.
+	*/
+	if Configuration == nil {
+		return false
+	}
+	if _, ok := Configuration.Priviledges[username]; ok {
+		return false
+	}
+	skeleton := Skeleton(username)
+	for _, reserved := range Configuration.Registration.Reserved {
+		if Skeleton(reserved) == skeleton {
+			return true
+		}
+	}
+	return false
+}
+
+func LookupConfusableTx(tx *bolt.Tx, username string) (string, bool) {
+	/*
+		Returns an existing user whose name looks
+		like this username.
+	*/
+	skeleton := Skeleton(username)
+	index := tx.Bucket(DBSKELETONS)
+	if index == nil {
+		// database was created before the index
+		var found string
+		tx.Bucket(DBUSERS).ForEach(func(k, v []byte) error {
+			if found == "" && Skeleton(string(k)) == skeleton {
+				found = string(k)
+			}
+			return nil
+		})
+		return found, found != ""
+	}
+	existing := index.Get([]byte(skeleton))
+	return string(existing), existing != nil
+}
+
+func LookupPreviousUsernameTx(tx *bolt.Tx, username string) (string, bool) {
+	/*
+		Returns the current name of a user who was
+		renamed from this username, or from a name
+		that looks like it. Previous names are never
+		given to another user.
+	*/
+	renames := tx.Bucket(DBRENAMES)
+	if renames == nil {
+		return "", false
+	}
+	canonical := CanonicalUsername(username)
+	skeleton := Skeleton(username)
+	var found string
+	renames.ForEach(func(k, v []byte) error {
+		if found == "" && (CanonicalUsername(string(k)) == canonical || Skeleton(string(k)) == skeleton) {
+			found = string(v)
+		}
+		return nil
+	})
+	return found, found != ""
+}
+
+func checkUsernameAvailableTx(tx *bolt.Tx, username string) error {
+	if _, exists := LookupUsernameTx(tx, username); exists {
+		return ErrUserAlreadyExists
+	}
+	if IsUsernameReserved(username) {
+		return ErrUsernameReserved
+	}
+	if _, exists := LookupConfusableTx(tx, username); exists {
+		return ErrUsernameConfusable
+	}
+	if _, exists := LookupPreviousUsernameTx(tx, username); exists {
+		return ErrUsernameRenamed
+	}
+	return nil
+}
+
+func addUsernameIndexTx(tx *bolt.Tx, username string) error {
+	if err := tx.Bucket(DBUSERNAMES).Put([]byte(CanonicalUsername(username)), []byte(username)); err != nil {
+		return err
+	}
+	return tx.Bucket(DBSKELETONS).Put([]byte(Skeleton(username)), []byte(username))
+}
+
+func removeUsernameIndexTx(tx *bolt.Tx, username string) error {
+	for _, index := range []struct {
+		bucket *bolt.Bucket
+		key    string
+	}{
+		{tx.Bucket(DBUSERNAMES), CanonicalUsername(username)},
+		{tx.Bucket(DBSKELETONS), Skeleton(username)},
+	} {
+		if string(index.bucket.Get([]byte(index.key))) != username {
+			continue
+		}
+		if err := index.bucket.Delete([]byte(index.key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildUsernameIndex() error {
+	/*
+		Add every existing user to the indexes. If
+		two existing accounts only differ by case,
+		the first one is indexed and a warning is
+		printed.
+	*/
+	return db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(DBUSERNAMES)
+		skeletons := tx.Bucket(DBSKELETONS)
+		users := tx.Bucket(DBUSERS)
+		return users.ForEach(func(k, v []byte) error {
+			if users.Bucket(k) == nil {
+				return nil
+			}
+			skeleton := []byte(Skeleton(string(k)))
+			if existing := skeletons.Get(skeleton); existing == nil {
+				if err := skeletons.Put(skeleton, k); err != nil {
+					return err
+				}
+			} else if string(existing) != string(k) {
+				log.Printf("Warning: usernames %q and %q look alike.\n", existing, k)
+			}
+
+			canonical := []byte(CanonicalUsername(string(k)))
+			if existing := index.Get(canonical); existing != nil {
+				if string(existing) != string(k) {
+					log.Printf("Warning: usernames %q and %q only differ by case. Only %q can log in without exact case.\n", existing, k, existing)
+				}
+				return nil
+			}
+			return index.Put(canonical, k)
+		})
+	})
+}
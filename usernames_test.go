@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini/gemtest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestCaseInsensitiveUsernames(t *testing.T) {
+	Configuration = &ConfigStr{}
+
+	var err error
+	var testDBpath string = ".testing/TestCaseInsensitiveUsernames.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 2)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/Alice/alice%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/ALICE/alice2%40example.net/?password", Cert: 2, Response: []byte("59 User with this name already exists\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/?alice", Cert: 1, Response: []byte("30 /login/Alice/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/aLiCe/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/search/?%40alice", Cert: 1, Response: []byte("20 text/gemini\r\n# Search by user alice\r\n\r\n## Created threads\r\n## Replies\r\n")},
+	)
+}
+
+func TestBuildUsernameIndex(t *testing.T) {
+	var err error
+	var testDBpath string = ".testing/TestBuildUsernameIndex.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	/*
+		Database from before the index existed
+	*/
+	if err := db.Update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucket(DBUSERS)
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"Bob", "carol"} {
+			if _, err := users.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if found, ok := LookupUsername("bob"); !ok || found != "Bob" {
+		t.Errorf("Lookup without index: recieved %q %v", found, ok)
+	}
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := buildUsernameIndex(); err != nil {
+		t.Fatal(err.Error())
+	}
+	for query, expected := range map[string]string{"bob": "Bob", "BOB": "Bob", "Carol": "carol"} {
+		if found, ok := LookupUsername(query); !ok || found != expected {
+			t.Errorf("Lookup %q: recieved %q %v", query, found, ok)
+		}
+	}
+	if found, ok := LookupUsername("dave"); ok {
+		t.Errorf("Lookup of missing user recieved %q", found)
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	for _, c := range []struct {
+		A, B string
+		Same bool
+	}{
+		{"admin", "аdmin", true},       // Cyrillic a
+		{"Investor", "lnvestor", true}, // capital I
+		{"admin", "ａｄｍｉｎ", true},       // fullwidth
+		{"admin", "adrnin", true},      // rn
+		{"paypal", "pаypаl", true},     // Cyrillic a
+		{"bill", "bi11", true},         // digits
+		{"oscar", "0scar", true},       // digits
+		{"alice", "Αlice", true},       // Greek Alpha
+		{"alice", "alicе", true},       // Cyrillic e
+		{"alice", "𝐚𝐥𝐢𝐜𝐞", true},       // mathematical bold
+		{"alice", "alise", false},      // different letter
+		{"alice", "alice_", false},     // extra character
+		{"ünicode", "unicode", false},  // accents are different letters
+	} {
+		if same := Skeleton(c.A) == Skeleton(c.B); same != c.Same {
+			t.Errorf("%q and %q: expected same skeleton = %v (%q, %q)", c.A, c.B, c.Same, Skeleton(c.A), Skeleton(c.B))
+		}
+	}
+}
+
+func TestConfusableUsernames(t *testing.T) {
+	Configuration = &ConfigStr{
+		Priviledges: map[string]UserPriviledge{
+			"admin": Admin,
+		},
+		Registration: ConfigRegistration{
+			Reserved: []string{"admin", "mod"},
+		},
+	}
+
+	var err error
+	var testDBpath string = ".testing/TestConfusableUsernames.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	serv := gemtest.Testd(t, handler, 1)
+	defer serv.Stop()
+
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/?m%D0%BEd", Cert: 1, Response: []byte("59 This username is reserved.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/?admin", Cert: 1, Response: []byte("30 /register/admin/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/admin/admin%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/?%D0%B0dmin", Cert: 1, Response: []byte("59 This username is reserved.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/?bob", Cert: 1, Response: []byte("30 /register/bob/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 1, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/b%D0%BEb/bob2%40example.net/?password", Cert: 1, Response: []byte("59 This username looks too similar to an existing user.\r\n")},
+	)
+}
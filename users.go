@@ -5,18 +5,19 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"math/rand"
+	"log"
+	"net/mail"
 	"net/url"
 	"strings"
+	"time"
 	"unicode"
 
 	"codeberg.org/FiskFan1999/gemini"
 	bolt "go.etcd.io/bbolt"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func DisplayUsernameAuto(username string) string {
-	priv := Configuration.Priviledges[username]
+	priv := LookupUserPriviledge(username)
 	return DisplayUsername(username, priv)
 }
 
@@ -54,10 +55,18 @@ func VerifyUserHandler(u *url.URL, c *tls.Conn) gemini.ResponseFormat {
 		if user == nil {
 			return UserNotFound
 		}
-		user.Put([]byte("verified"), []byte("1")) // verify user
+		if IsValidationCodeExpired(user) {
+			return ErrVerificationExpired
+		}
+		user.Put([]byte("emailverified"), []byte("1"))
+		if err := updateVerified(user); err != nil { // verify user
+			return err
+		}
 
 		// delete validation code from bucket
 		validateBucket.Delete([]byte(code))
+		user.Delete([]byte("validation"))
+		user.Delete([]byte("validationtime"))
 
 		return nil
 	}); err != nil {
@@ -111,28 +120,11 @@ func LoginUserHandler(u *url.URL, c *tls.Conn) gemini.Response {
 				return gemini.BadRequest.Error(err)
 			}
 
-			// search database for this username
-			userFound := false
-			if err := db.View(func(tx *bolt.Tx) error {
-				users := tx.Bucket(DBUSERS)
-				if users.Bucket([]byte(username)) != nil {
-					userFound = true
-				}
-				/*
-					users.ForEach(func(k, v []byte) error {
-						if string(k) == username {
-							userFound = true
-						}
-						return nil
-					})
-				*/
-				return nil
-			}); err != nil {
-				return gemini.TemporaryFailure.Error(err)
-			}
+			// search database for this username (ignoring case)
+			registered, userFound := LookupUsername(username)
 			if userFound {
 				// redirect to password
-				return gemini.RedirectTemporary.Response(fmt.Sprintf("/login/%s/", u.RawQuery))
+				return gemini.RedirectTemporary.Response(fmt.Sprintf("/login/%s/", url.PathEscape(registered)))
 			} else {
 				return gemini.BadRequest.Response("Username not found")
 			}
@@ -149,6 +141,9 @@ func LoginUserHandler(u *url.URL, c *tls.Conn) gemini.Response {
 			if err != nil {
 				return gemini.BadRequest.Error(err)
 			}
+			if registered, ok := LookupUsername(user); ok {
+				user = registered
+			}
 
 			// check if the username and password check out
 			// if they do, assign this cert fp to this username. Then redirect to home page.
@@ -161,13 +156,19 @@ func LoginUserHandler(u *url.URL, c *tls.Conn) gemini.Response {
 				if thisUser == nil {
 					return UserNotFound
 				}
+				if ban, banned := IsUserBannedTx(tx, user); banned {
+					return errors.New(ban.Message(getUserSettingsTx(tx, user)))
+				}
 				/*
 					Check if user is verified
 				*/
 				if !bytes.Equal(thisUser.Get([]byte("verified")), []byte("1")) {
+					if IsAwaitingApproval(thisUser) {
+						return ErrAwaitingApproval
+					}
 					return errors.New("User not verified")
 				}
-				loggingInPassword = thisUser.Get([]byte("password"))
+				loggingInPassword = append([]byte{}, thisUser.Get([]byte("password"))...)
 				/*
 					userbytes := userbucket.Get([]byte(user))
 					if userbytes == nil {
@@ -182,9 +183,29 @@ func LoginUserHandler(u *url.URL, c *tls.Conn) gemini.Response {
 				return gemini.BadRequest.Error(err)
 			}
 			// user was found. Check password hash
-			if err := bcrypt.CompareHashAndPassword(loggingInPassword, []byte(pass)); err != nil {
+			if err := CheckPassword(loggingInPassword, pass); err != nil {
 				return gemini.BadRequest.Response("Login unsuccessful")
 			}
+			if PasswordNeedsRehash(loggingInPassword) {
+				// hashed with an older policy
+				if err := rehashPassword(user, pass); err != nil {
+					log.Printf("Error while rehashing password of %s: %s\n", user, err.Error())
+				}
+			}
+
+			if !HasTwoFactor(user) && TwoFactorRequired(user, LookupUserPriviledge(user)) && !useTwoFactorReset(user) {
+				/*
+					Otherwise someone who knows the
+					password could log in and enable
+					two-factor authentication themselves.
+				*/
+				return gemini.CertificateNotAuthorised.Error(ErrTwoFactorLogin)
+			}
+			if HasTwoFactor(user) {
+				// ask for the code before binding the certificate
+				beginTwoFactorLogin(fp, user)
+				return gemini.RedirectTemporary.Response(fmt.Sprintf("/login/%s/2fa/", url.PathEscape(user)))
+			}
 
 			// login successful.
 			// add fingerprint->username to database
@@ -197,31 +218,76 @@ func LoginUserHandler(u *url.URL, c *tls.Conn) gemini.Response {
 			// login successful. redirect to homepage.
 			return gemini.RedirectTemporary.Response("/")
 		}
-
+	case 3:
+		/*
+			/login/<username>/2fa/?code
+		*/
+		if parts[2] != "2fa" {
+			return gemini.BadRequest.Response("illegal path")
+		}
+		if u.RawQuery == "" {
+			return gemini.SensitiveInput.Response("Two-factor code (or recovery code)")
+		}
+		user, err := url.QueryUnescape(parts[1])
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		code, err := url.QueryUnescape(u.RawQuery)
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		if err := finishTwoFactorLogin(fp, user, code); err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		return gemini.RedirectTemporary.Response("/")
 	}
 
 	return gemini.Success.Response("text/gemini")
 }
 
-const BcryptStrength = 12
+func rehashPassword(username, password string) error {
+	phash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil {
+			return UserNotFound
+		}
+		return user.Put([]byte("password"), phash)
+	})
+}
 
 var ErrUserAlreadyExists = errors.New("User with this name already exists")
 
-func OnRegister(username, email, password string) error {
-	phash, err := bcrypt.GenerateFromPassword([]byte(password), BcryptStrength)
+func OnRegister(username, email, password, invite string) error {
+	/*
+		invite is the invite code used to register,
+		or "" if Configuration.Registration.Mode is
+		not "invite".
+	*/
+	phash, err := HashPassword(password)
 	if err != nil {
 		return err
 	}
 
-	// random seed for validation
-	validation := itob(rand.Uint64())
+	var validation []byte
 
 	// write to database
 	if err := db.Update(func(tx *bolt.Tx) error {
 		usersbucket := tx.Bucket(DBUSERS)
-		if alreadyExists := usersbucket.Bucket([]byte(username)); alreadyExists != nil {
-			// username already exists
-			return ErrUserAlreadyExists
+		if err := checkUsernameAvailableTx(tx, username); err != nil {
+			// username already exists (ignoring case or look-alike characters)
+			return err
+		}
+		if err := checkEmailNotUsed(tx, email); err != nil {
+			return err
+		}
+		if RegistrationMode() == RegistrationInvite {
+			if err := useInviteTx(tx, invite, username); err != nil {
+				return err
+			}
 		}
 		// otherwise, write the user information to the database
 		//return usersbucket.Put([]byte(username), ubytes)
@@ -229,9 +295,13 @@ func OnRegister(username, email, password string) error {
 		if err != nil {
 			return err
 		}
+		if err := addUsernameIndexTx(tx, username); err != nil {
+			return err
+		}
 		thisUser.Put([]byte("verified"), []byte("0"))
+		thisUser.Put([]byte("emailverified"), []byte("0"))
 		if !Configuration.Smtp.Enabled {
-			thisUser.Put([]byte("verified"), []byte("1"))
+			thisUser.Put([]byte("emailverified"), []byte("1"))
 		}
 		thisUser.Put([]byte("password"), phash)
 		thisUser.Put([]byte("postnudge"), []byte("1")) // 1 = privacy nudge not shown yet
@@ -243,11 +313,33 @@ func OnRegister(username, email, password string) error {
 			"permanent": permamently muted
 		*/
 		thisUser.Put([]byte("muted"), []byte(""))
+		// Configuration.Priviledges is applied when the priviledge is read
+		thisUser.Put(DBUSERPRIV, User.Write())
+
+		nowBytes, err := time.Now().MarshalText()
+		if err != nil {
+			return err
+		}
+		thisUser.Put([]byte("registered"), nowBytes)
 
-		valid := tx.Bucket(DBVALIDATION)
-		valid.Put(validation, []byte(username))
+		/*
+			"approved":
+			"0": awaiting moderator approval
+			"1" or not set: approved
+		*/
+		if RegistrationMode() == RegistrationApproval {
+			thisUser.Put([]byte("approved"), []byte("0"))
+			if err := tx.Bucket(DBPENDING).Put([]byte(username), nowBytes); err != nil {
+				return err
+			}
+		}
 
-		return nil
+		if err := updateVerified(thisUser); err != nil {
+			return err
+		}
+
+		validation, err = newValidationCode(tx, thisUser, username)
+		return err
 
 	}); err != nil {
 		return err
@@ -259,13 +351,93 @@ func OnRegister(username, email, password string) error {
 func RegisterUserHandler(u *url.URL, c *tls.Conn) gemini.Response {
 	/*
 		Steps:
+		10 Invite code (invite mode only)
+		10 Proof of work (if enabled)
 		10 Username
 		10 Email
 		11 Password
+
+		In invite mode the code is the first
+		part of the path after /register/,
+		followed by the proof of work solution
+		if it is enabled (see pow.go).
 	*/
 	parts := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
-	switch len(parts) {
-	case 1:
+	if len(parts) == 0 {
+		return gemini.BadRequest.Response("illegal path")
+	}
+
+	mode := RegistrationMode()
+	if mode == RegistrationClosed {
+		return gemini.BadRequest.Error(ErrRegistrationClosed)
+	}
+
+	prefix := "/register"
+	steps := parts[1:]
+	var invite string
+
+	if mode == RegistrationInvite {
+		if len(steps) == 0 {
+			if u.RawQuery == "" {
+				return gemini.Input.Response("Please enter your invite code.")
+			}
+			code, err := url.QueryUnescape(u.RawQuery)
+			if err != nil {
+				return gemini.BadRequest.Error(err)
+			}
+			if err := CheckInvite(strings.TrimSpace(code)); err != nil {
+				return gemini.BadRequest.Error(err)
+			}
+			return gemini.RedirectTemporary.Response(fmt.Sprintf("/register/%s/", u.RawQuery))
+		}
+		var err error
+		invite, err = url.QueryUnescape(steps[0])
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		invite = strings.TrimSpace(invite)
+		if err := CheckInvite(invite); err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		prefix = fmt.Sprintf("%s/%s", prefix, steps[0])
+		steps = steps[1:]
+	}
+
+	var solution string
+	if ProofOfWorkEnabled() {
+		now := time.Now()
+		if len(steps) == 0 {
+			return gemini.RedirectTemporary.Response(fmt.Sprintf("%s/%s/", prefix, NewProofOfWorkChallenge(now)))
+		}
+		if strings.Count(steps[0], ".") == 3 {
+			// challenge without a solution
+			if u.RawQuery == "" {
+				return gemini.Input.Response(ProofOfWorkPrompt(steps[0]))
+			}
+			nonce, err := url.QueryUnescape(u.RawQuery)
+			if err != nil {
+				return gemini.BadRequest.Error(err)
+			}
+			solution = fmt.Sprintf("%s.%s", steps[0], strings.TrimSpace(nonce))
+			if _, err := CheckProofOfWork(solution, now); err != nil {
+				return gemini.BadRequest.Error(err)
+			}
+			return gemini.RedirectTemporary.Response(fmt.Sprintf("%s/%s/", prefix, url.PathEscape(solution)))
+		}
+		var err error
+		solution, err = url.PathUnescape(steps[0])
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		if _, err := CheckProofOfWork(solution, now); err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		prefix = fmt.Sprintf("%s/%s", prefix, steps[0])
+		steps = steps[1:]
+	}
+
+	switch len(steps) {
+	case 0:
 		// first time at this page
 		if u.RawQuery == "" {
 			return gemini.Input.Response("Please enter your username.")
@@ -279,17 +451,23 @@ func RegisterUserHandler(u *url.URL, c *tls.Conn) gemini.Response {
 				return gemini.BadRequest.Error(err)
 			} else {
 				// username is ok. Redirect to email address.
-				return gemini.RedirectTemporary.Response(fmt.Sprintf("/register/%s/", u.RawQuery))
+				return gemini.RedirectTemporary.Response(fmt.Sprintf("%s/%s/", prefix, u.RawQuery))
 			}
 		}
-	case 2:
+	case 1:
 		if u.RawQuery == "" {
 			return gemini.Input.Response("Please enter your email address.")
 		} else {
-			// TODO: validate email?
-			return gemini.RedirectTemporary.Response(fmt.Sprintf("/%s/%s/", strings.Join(parts, "/"), u.RawQuery))
+			e, err := url.QueryUnescape(u.RawQuery)
+			if err != nil {
+				return gemini.BadRequest.Error(err)
+			}
+			if err := validateEmail(e); err != nil {
+				return gemini.BadRequest.Error(err)
+			}
+			return gemini.RedirectTemporary.Response(fmt.Sprintf("%s/%s/%s/", prefix, steps[0], u.RawQuery))
 		}
-	case 3:
+	case 2:
 		if u.RawQuery == "" {
 			return gemini.SensitiveInput.Response("Please enter your password.")
 		} else {
@@ -301,22 +479,52 @@ func RegisterUserHandler(u *url.URL, c *tls.Conn) gemini.Response {
 				return gemini.BadRequest.Error(err)
 			}
 
-			u, err := url.QueryUnescape(parts[1])
+			u, err := url.QueryUnescape(steps[0])
 			if err != nil {
 				return gemini.BadRequest.Error(err)
 			}
 			if err := validateUsername(u); err != nil {
 				return gemini.BadRequest.Error(err)
 			}
-			e, err := url.QueryUnescape(parts[2])
+			e, err := url.QueryUnescape(steps[1])
 			if err != nil {
 				return gemini.BadRequest.Error(err)
 			}
+			if err := validateEmail(e); err != nil {
+				return gemini.BadRequest.Error(err)
+			}
 
-			if err := OnRegister(strings.TrimSpace(u), strings.TrimSpace(e), strings.TrimSpace(p)); err != nil {
+			/*
+				The solution is reserved before
+				registering, so that it can not be used
+				twice at once, and released again if
+				the registration fails.
+			*/
+			used := time.Now()
+			if solution != "" {
+				if err := UseProofOfWork(solution, used); err != nil {
+					return gemini.BadRequest.Error(err)
+				}
+			}
+
+			if err := OnRegister(strings.TrimSpace(u), strings.TrimSpace(e), strings.TrimSpace(p), invite); err != nil {
+				if solution != "" {
+					ReleaseProofOfWork(solution, used)
+				}
 				return gemini.BadRequest.Error(err)
 			}
 
+			if mode == RegistrationApproval {
+				return gemini.ResponseFormat{
+					Status: gemini.Success,
+					Mime:   "text/gemini",
+					Lines: gemini.Lines{
+						"Thank you for registering. You will be able to log in once a moderator has approved your account.",
+						fmt.Sprintf("%s/ Go to home.", gemini.Link),
+					},
+				}
+			}
+
 			// send on successful registration
 			return gemini.RedirectTemporary.Response("/")
 		}
@@ -344,6 +552,40 @@ func validatePassword(password string) error {
 	return nil
 }
 
+var (
+	ErrInvalidEmail     = errors.New("Invalid email address.")
+	ErrEmailAlreadyUsed = errors.New("This email address is already used by another account.")
+)
+
+func validateEmail(email string) error {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		// display names such as "Alice <alice@example.net>" are not allowed
+		return ErrInvalidEmail
+	}
+	if db == nil {
+		return nil
+	}
+	return db.View(func(tx *bolt.Tx) error {
+		return checkEmailNotUsed(tx, email)
+	})
+}
+
+func checkEmailNotUsed(tx *bolt.Tx, email string) error {
+	usersbucket := tx.Bucket(DBUSERS)
+	return usersbucket.ForEach(func(k, v []byte) error {
+		user := usersbucket.Bucket(k)
+		if user == nil {
+			return nil
+		}
+		if strings.EqualFold(string(user.Get([]byte("email"))), strings.TrimSpace(email)) {
+			return ErrEmailAlreadyUsed
+		}
+		return nil
+	})
+}
+
 const (
 	UsernameMaxLength = 24
 )
@@ -356,6 +598,22 @@ var (
 
 func validateUsername(username string) error {
 	username = strings.TrimSpace(username)
+	if err := validateUsernameFormat(username); err != nil {
+		return err
+	}
+	if db == nil {
+		return nil
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		return checkUsernameAvailableTx(tx, username)
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateUsernameFormat(username string) error {
 	if len(username) == 0 {
 		return ErrUsernameTooShort
 	}
@@ -365,25 +623,20 @@ func validateUsername(username string) error {
 	}
 	// check for unallowed chars
 	for _, c := range username {
-		// allowed characters are letters, numbers, and underscore
-		if !(unicode.IsLetter(c) || unicode.IsNumber(c) || c == '_') {
+		if !IsUsernameChar(c) {
 			return ErrUnallowedChar
 		}
 	}
-	if db == nil {
-		return nil
-	}
-
-	if err := db.View(func(tx *bolt.Tx) error {
-		usersbucket := tx.Bucket(DBUSERS)
-		return usersbucket.ForEach(func(k, v []byte) error {
-			if strings.EqualFold(string(k), username) {
-				return ErrUserAlreadyExists
-			}
-			return nil
-		})
-	}); err != nil {
-		return err
-	}
 	return nil
 }
+
+/*
+Allowed characters in usernames are letters,
+numbers, and underscore. usernameCharClass is
+the same rule as a regular expression.
+*/
+const usernameCharClass = `\p{L}\p{N}_`
+
+func IsUsernameChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsNumber(c) || c == '_'
+}
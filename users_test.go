@@ -14,6 +14,7 @@ import (
 
 	"codeberg.org/FiskFan1999/gemini"
 	"codeberg.org/FiskFan1999/gemini/gemtest"
+	"github.com/coinpaprika/ratelimiter"
 	smtptest "github.com/davrux/go-smtptester"
 	"github.com/google/go-cmp/cmp"
 	// "github.com/jordan-wright/email"
@@ -102,6 +103,68 @@ func TestUserRegistration(t *testing.T) {
 	)
 	defer serv.Stop()
 
+	/*
+		Email addresses must be valid and unique
+	*/
+	serv.Check(
+		gemtest.Input{URL: "gemini://localhost/register/bob/?notanemail", Cert: 0, Response: []byte("59 Invalid email address.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/?ALICE%40example.net", Cert: 0, Response: []byte("59 This email address is already used by another account.\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/?bob%40example.net", Cert: 0, Response: []byte("30 /register/bob/bob%40example.net/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/register/bob/bob%40example.net/?password", Cert: 0, Response: []byte("30 /\r\n")},
+	)
+
+	/*
+		Expired verification code, then request a new one
+	*/
+	message2, ok := smtpbe.Load("from@example.net", []string{"bob@example.net"})
+	if !ok {
+		t.Fatal("email message not found after registration")
+	}
+	link2 := getVerificationLink(t, message2.Data)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(DBUSERS).Bucket([]byte("bob")).Put([]byte("validationtime"), []byte("2000-01-01T00:00:00Z"))
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	emailResendLimiter = ratelimiter.New(&BoltLimitStore{expirationTime: RateLimitExpiry}, 1, time.Minute)
+	defer func() { emailResendLimiter = nil }()
+	serv.Check(
+		gemtest.Input{URL: string(link2), Cert: 0, Response: []byte("59 Verification code has expired. Please request a new one at /resend/\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 1, Response: []byte("59 User not verified\r\n")},
+		gemtest.Input{URL: "gemini://localhost/resend/", Cert: 0, Response: []byte("10 Username\r\n")},
+		gemtest.Input{URL: "gemini://localhost/resend/?bob", Cert: 0, Response: []byte("30 /resend/bob/\r\n")},
+		// wrong passwords do not count towards the limit
+		gemtest.Input{URL: "gemini://localhost/resend/bob/?wrong", Cert: 0, Response: []byte("59 Login unsuccessful\r\n")},
+		gemtest.Input{URL: "gemini://localhost/resend/bob/?wrong", Cert: 0, Response: []byte("59 Login unsuccessful\r\n")},
+		gemtest.Input{URL: "gemini://localhost/resend/alice/?password", Cert: 0, Response: []byte("59 User is already verified\r\n")},
+		gemtest.Input{URL: "gemini://localhost/resend/bob/?password", Cert: 0, Response: []byte("20 text/gemini\r\nA new verification email has been sent.\r\n=> / Go to home.\r\n")},
+	)
+	message3, ok := smtpbe.Load("from@example.net", []string{"bob@example.net"})
+	if !ok {
+		t.Fatal("email message not found after resending")
+	}
+	link3 := getVerificationLink(t, message3.Data)
+	if bytes.Equal(link2, link3) {
+		t.Fatal("resent verification link is the same as the expired link")
+	}
+	serv.Check(
+		gemtest.Input{URL: string(link2), Cert: 0, Response: []byte("59 User does not exist.\r\n")},
+		gemtest.Input{URL: string(link3), Cert: 0, Response: []byte("30 /\r\n")},
+		gemtest.Input{URL: "gemini://localhost/login/bob/?password", Cert: 1, Response: []byte("30 /\r\n")},
+	)
+}
+
+func getVerificationLink(t *testing.T, data []byte) []byte {
+	message, err := mail.ReadMessage(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, err := io.ReadAll(message.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return bytes.Split(body, []byte("\n"))[2] // link is on this line in the email message
+
 }
 
 func DontTestUserRegistration(t *testing.T) {
@@ -269,6 +332,29 @@ func TestValidateUsername(t *testing.T) {
 	}
 }
 
+func TestValidateEmail(t *testing.T) {
+	var err error
+	db, err = bolt.Open(".testing/userstest1.db", 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer db.Close()
+	for in, expected := range TestValidateEmailCases {
+		if output := validateEmail(in); output != expected {
+			t.Errorf("For input \"%s\", expected result \"%s\", but recieved \"%s\"", in, expected, output)
+		}
+	}
+}
+
+var TestValidateEmailCases = map[string]error{
+	"alice@example.net":         nil,
+	" alice@example.net ":       nil,
+	"alice":                     ErrInvalidEmail,
+	"alice@":                    ErrInvalidEmail,
+	"Alice <alice@example.net>": ErrInvalidEmail,
+	"":                          ErrInvalidEmail,
+}
+
 var TestValidateUsernameCases = map[string]error{
 	"":                       ErrUsernameTooShort,
 	"fiskfan1999":            nil,
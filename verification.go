@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"codeberg.org/FiskFan1999/gemini"
+	"github.com/coinpaprika/ratelimiter"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+Default amount of time that a verification
+code may be used for, if not set in the
+configuration file.
+*/
+const DefaultVerificationCodeExpiry = time.Hour * 48
+
+var PurgeUnverifiedInterval = time.Hour
+
+var (
+	ErrVerificationExpired = errors.New("Verification code has expired. Please request a new one at /resend/")
+	ErrAlreadyVerified     = errors.New("User is already verified")
+	ErrSmtpNotEnabled      = errors.New("SMTP is not enabled on this instance.")
+)
+
+var emailResendLimiter *ratelimiter.RateLimiter
+
+func VerificationCodeExpiry() time.Duration {
+	if Configuration.Verification.CodeExpiry <= 0 {
+		return DefaultVerificationCodeExpiry
+	}
+	return Configuration.Verification.CodeExpiry * time.Hour
+}
+
+func newValidationCode(tx *bolt.Tx, user *bolt.Bucket, username string) ([]byte, error) {
+	/*
+		Write a new validation code for this user,
+		replacing the previous one if it exists.
+
+		The user bucket holds the current code
+		("validation") and the time at which
+		it was created ("validationtime").
+	*/
+	valid := tx.Bucket(DBVALIDATION)
+	if old := user.Get([]byte("validation")); old != nil {
+		if err := valid.Delete(old); err != nil {
+			return nil, err
+		}
+	}
+
+	// unguessable, so crypto/rand (same length as itob)
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	validation := []byte(hex.EncodeToString(random))
+	nowBytes, err := time.Now().MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := valid.Put(validation, []byte(username)); err != nil {
+		return nil, err
+	}
+	user.Put([]byte("validation"), validation)
+	user.Put([]byte("validationtime"), nowBytes)
+	return validation, nil
+}
+
+func IsValidationCodeExpired(user *bolt.Bucket) bool {
+	/*
+		Codes written before creation times were
+		stored do not expire.
+	*/
+	created := user.Get([]byte("validationtime"))
+	if created == nil {
+		return false
+	}
+	var t time.Time
+	if err := t.UnmarshalText(created); err != nil {
+		log.Printf("programming error: value %q in \"validationtime\" is not a parsable time\n", created)
+		return false
+	}
+	return time.Since(t) > VerificationCodeExpiry()
+}
+
+func ResendVerification(username, password string) error {
+	if !Configuration.Smtp.Enabled {
+		return ErrSmtpNotEnabled
+	}
+
+	var email []byte
+	var validation []byte
+	if err := db.Update(func(tx *bolt.Tx) error {
+		user := tx.Bucket(DBUSERS).Bucket([]byte(username))
+		if user == nil {
+			return UserNotFound
+		}
+		if bytes.Equal(user.Get([]byte("verified")), []byte("1")) {
+			return ErrAlreadyVerified
+		}
+		if err := CheckPassword(user.Get([]byte("password")), password); err != nil {
+			return err
+		}
+		email = make([]byte, len(user.Get([]byte("email"))))
+		copy(email, user.Get([]byte("email")))
+
+		var err error
+		validation, err = newValidationCode(tx, user, username)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return SendEmailOnRegistration(username, string(email), validation)
+}
+
+func ResendVerificationHandler(u *url.URL, c *tls.Conn) gemini.Response {
+	/*
+		Steps:
+		10 Username
+		11 Password
+	*/
+	parts := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
+	switch len(parts) {
+	case 1:
+		if u.RawQuery == "" {
+			return gemini.Input.Response("Username")
+		}
+		return gemini.RedirectTemporary.Response(fmt.Sprintf("/resend/%s/", u.RawQuery))
+	case 2:
+		if u.RawQuery == "" {
+			return gemini.SensitiveInput.Response("Password")
+		}
+		username, err := url.QueryUnescape(parts[1])
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		password, err := url.QueryUnescape(u.RawQuery)
+		if err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+
+		if emailResendLimiter != nil {
+			stat, err := emailResendLimiter.Check(username)
+			if err != nil {
+				return gemini.TemporaryFailure.Error(err)
+			}
+			if stat.IsLimited {
+				return gemini.SlowDown.Response(fmt.Sprintf("%d", int(stat.LimitDuration.Seconds())+1))
+			}
+		}
+
+		if err := ResendVerification(username, password); err != nil {
+			return gemini.BadRequest.Error(err)
+		}
+		/*
+			Only counted once the password was
+			accepted, so that others can not keep
+			this user from getting an email.
+		*/
+		if emailResendLimiter != nil {
+			if err := emailResendLimiter.Inc(username); err != nil {
+				log.Println(err.Error())
+			}
+		}
+		return gemini.ResponseFormat{
+			Status: gemini.Success,
+			Mime:   "text/gemini",
+			Lines: gemini.Lines{
+				"A new verification email has been sent.",
+				fmt.Sprintf("%s/ Go to home.", gemini.Link),
+			},
+		}
+	default:
+		return gemini.BadRequest.Response("illegal path")
+	}
+}
+
+func PurgeUnverifiedAccounts(olderThan time.Duration) (purged []string, err error) {
+	/*
+		Delete every account that is still not
+		verified and was registered more than
+		olderThan ago. Accounts registered
+		before registration times were stored
+		are kept.
+	*/
+	err = db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(DBUSERS)
+		if err := users.ForEach(func(k, v []byte) error {
+			user := users.Bucket(k)
+			if user == nil {
+				return nil
+			}
+			if bytes.Equal(user.Get([]byte("verified")), []byte("1")) || IsAwaitingApproval(user) {
+				// accounts awaiting approval are left for moderators
+				return nil
+			}
+			registered := user.Get([]byte("registered"))
+			if registered == nil {
+				return nil
+			}
+			var t time.Time
+			if err := t.UnmarshalText(registered); err != nil {
+				return err
+			}
+			if time.Since(t) > olderThan {
+				purged = append(purged, string(k))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, username := range purged {
+			if err := deleteUnverifiedUserTx(tx, username); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func deleteUnverifiedUserTx(tx *bolt.Tx, username string) error {
+	/*
+		Remove an account which has never been
+		verified, and so has not written any threads
+		or posts.
+	*/
+	users := tx.Bucket(DBUSERS)
+	user := users.Bucket([]byte(username))
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if code := user.Get([]byte("validation")); code != nil {
+		if err := tx.Bucket(DBVALIDATION).Delete(code); err != nil {
+			return err
+		}
+	}
+	if err := users.DeleteBucket([]byte(username)); err != nil {
+		return err
+	}
+	if err := removeUsernameIndexTx(tx, username); err != nil {
+		return err
+	}
+	if roles := tx.Bucket(DBROLES); roles.Bucket([]byte(username)) != nil {
+		if err := roles.DeleteBucket([]byte(username)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runPurgeUnverified() {
+	if Configuration.Verification.PurgeUnverified <= 0 {
+		return
+	}
+	purged, err := PurgeUnverifiedAccounts(Configuration.Verification.PurgeUnverified * time.Hour)
+	if err != nil {
+		log.Printf("Error while purging unverified accounts: %s\n", err.Error())
+		return
+	}
+	if len(purged) != 0 {
+		log.Printf("Purged %d unverified accounts: %s\n", len(purged), strings.Join(purged, " "))
+	}
+}
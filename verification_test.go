@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestPurgeUnverifiedAccounts(t *testing.T) {
+	Configuration = &ConfigStr{}
+	var err error
+	var testDBpath string = ".testing/TestPurgeUnverifiedAccounts.db"
+	os.Remove(testDBpath)
+	db, err = bolt.Open(testDBpath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(testDBpath)
+	defer db.Close()
+
+	if err := dbCreateBuckets(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	/*
+		old: unverified, registered long ago (purged)
+		new: unverified, registered now
+		verified: verified, registered long ago
+		legacy: unverified, no registration time
+	*/
+	old, _ := time.Now().Add(-time.Hour * 24 * 30).MarshalText()
+	now, _ := time.Now().MarshalText()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(DBUSERS)
+		for name, values := range map[string][2][]byte{
+			"old":      {[]byte("0"), old},
+			"new":      {[]byte("0"), now},
+			"verified": {[]byte("1"), old},
+			"legacy":   {[]byte("0"), nil},
+		} {
+			user, err := users.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			user.Put([]byte("verified"), values[0])
+			if values[1] != nil {
+				user.Put([]byte("registered"), values[1])
+			}
+			if _, err := newValidationCode(tx, user, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	purged, err := PurgeUnverifiedAccounts(time.Hour * 24 * 7)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !cmp.Equal(purged, []string{"old"}) {
+		t.Errorf("Expected only \"old\" to be purged, recieved %q", purged)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(DBUSERS).Bucket([]byte("old")) != nil {
+			t.Error("Purged user bucket still exists")
+		}
+		if n := tx.Bucket(DBVALIDATION).Stats().KeyN; n != 3 {
+			t.Errorf("Expected 3 validation codes to remain, found %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+}
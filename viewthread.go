@@ -7,12 +7,11 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"codeberg.org/FiskFan1999/gemini"
-	"github.com/hako/durafmt"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -25,10 +24,11 @@ func ThreadViewHandler(u *url.URL, c *tls.Conn) gemini.Response {
 	/*
 		Get user priviledge, because if the thread is locked mods and admins can still reply.
 	*/
+	var username string
 	var userPriv UserPriviledge
 	fp := GetFingerprint(c)
 	if fp != nil {
-		_, userPriv, _, _ = GetUsernameFromFP(fp)
+		username, userPriv, _, _ = GetUsernameFromFP(fp)
 	}
 
 	pathspl := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
@@ -36,11 +36,33 @@ func ThreadViewHandler(u *url.URL, c *tls.Conn) gemini.Response {
 		return gemini.BadRequest.Response("Bad input")
 	}
 	id := pathspl[1]
+	settings := GetUserSettings(username)
+	ignored := GetIgnoredUsers(username)
+
+	/*
+		Page number (starting at 1) is optional:
+		/thread/<id>/<page>/
+	*/
+	page := 1
+	if len(pathspl) > 2 {
+		var err error
+		page, err = strconv.Atoi(pathspl[2])
+		if err != nil || page < 1 {
+			return gemini.BadRequest.Response("Bad page number")
+		}
+	}
+
 	var title string
 
 	var posts []Post
 
 	var isLocked bool = false
+	var lockReason string
+	var subforumID string
+
+	// post ID -> number of reports, and posts hidden pending review
+	reportCounts := map[string]int{}
+	hidden := map[string]bool{}
 
 	if err := db.View(func(tx *bolt.Tx) error {
 		/*
@@ -51,14 +73,18 @@ func ThreadViewHandler(u *url.URL, c *tls.Conn) gemini.Response {
 			return errors.New("allThreads == nil")
 		}
 		thread := allThreads.Bucket([]byte(id))
-		if thread == nil {
+		if thread == nil || IsThreadArchived(thread) {
 			return ThreadNotFound
 		}
 		title = string(thread.Get([]byte("title")))
+		if threadToSubf := tx.Bucket(DBTHREADTOSF); threadToSubf != nil {
+			subforumID = string(threadToSubf.Get([]byte(id)))
+		}
 
 		if bytes.Equal(thread.Get([]byte("locked")), []byte("1")) {
 			// currently locked
 			isLocked = true
+			lockReason = string(thread.Get([]byte("lockreason")))
 		}
 
 		/*
@@ -76,6 +102,11 @@ func ThreadViewHandler(u *url.URL, c *tls.Conn) gemini.Response {
 				log.Println(currentPost, "== nil")
 				continue
 			}
+			if bytes.Equal(currentPost.Get([]byte("archived")), []byte("1")) {
+				continue
+			}
+			reportCounts[string(postId)] = PostReportCount(currentPost)
+			hidden[string(postId)] = IsPostHidden(currentPost)
 			currentPostStr := Post{}
 			currentPostStr.ID = postId
 			currentPostStr.Text = string(currentPost.Get([]byte("text")))
@@ -97,31 +128,86 @@ func ThreadViewHandler(u *url.URL, c *tls.Conn) gemini.Response {
 	var writeReplyLines []string
 	if isLocked {
 		writeReplyLines = []string{"This thread is locked and not accepting new comments."}
+		if lockReason != "" {
+			writeReplyLines = append(writeReplyLines, fmt.Sprintf("Reason: %s", lockReason))
+		}
 	}
-	if !isLocked || userPriv.Is(whichPrivCanReplyToLockedThread) { // see threads.go
+	if !isLocked || Authorize(username, userPriv, whichCapCanReplyToLockedThread, subforumID) { // see threads.go
 		writeReplyLines = append(writeReplyLines, fmt.Sprintf("%s/new/post/%s/ Write comment", gemini.Link, id))
 	}
 
+	/*
+		Only show the posts on this page
+	*/
+	var pageLinks []string
+	if settings.PostsPerPage > 0 {
+		pages := (len(posts) + settings.PostsPerPage - 1) / settings.PostsPerPage
+		if pages == 0 {
+			pages = 1
+		}
+		if page > pages {
+			return NotFound
+		}
+		start := (page - 1) * settings.PostsPerPage
+		end := start + settings.PostsPerPage
+		if end > len(posts) {
+			end = len(posts)
+		}
+		posts = posts[start:end]
+		if page > 1 {
+			pageLinks = append(pageLinks, fmt.Sprintf("%s/thread/%s/%d/ Previous page", gemini.Link, id, page-1))
+		}
+		if page < pages {
+			pageLinks = append(pageLinks, fmt.Sprintf("%s/thread/%s/%d/ Next page", gemini.Link, id, page+1))
+		}
+		if pages > 1 {
+			pageLinks = append(pageLinks, fmt.Sprintf("Page %d of %d", page, pages))
+		}
+	} else if page != 1 {
+		return NotFound
+	}
+
 	lines := gemini.Lines{}
 	lines = append(lines, fmt.Sprintf("%s%s", gemini.Header, title))
 	lines = append(lines, writeReplyLines...)
 	lines = append(lines, "")
 
+	/*
+		Moderators see hidden posts and
+		the number of reports.
+	*/
+	canReview := Authorize(username, userPriv, CapReadReports, subforumID)
+
 	/*
 		Add posts to page
 	*/
 	var postReportOnce sync.Once
 	for _, p := range posts {
+		if ignored[p.Author] {
+			lines = append(lines, fmt.Sprintf("%s/user/%s/ Post by %s hidden (ignored user)", gemini.Link, url.PathEscape(p.Author), p.Author), "")
+			continue
+		}
+		if hidden[string(p.ID)] && !canReview {
+			lines = append(lines, fmt.Sprintf("Post by %s hidden pending review", p.Author), "")
+			continue
+		}
 		// lines = append(lines, fmt.Sprintf("<%s> %s", p.Author, p.Text))
 		lines = append(lines,
 			fmt.Sprintf("%s%s", gemini.Header3, DisplayUsernameAuto(p.Author)),
 		)
-		var dateLine string = fmt.Sprintf("%s/report/%s/ %s", gemini.Link, p.ID, TimeFormatForPost(p.Time))
+		var dateLine string = fmt.Sprintf("%s/report/%s/ %s", gemini.Link, p.ID, settings.FormatPostTime(p.Time))
 		/*
 			Add note about reporting, only on first post to not clutter too much
 		*/
 		postReportOnce.Do(func() { dateLine = dateLine + " (click to report)" })
 		lines = append(lines, dateLine)
+		if n := reportCounts[string(p.ID)]; canReview && (n > 0 || hidden[string(p.ID)]) {
+			var note string
+			if hidden[string(p.ID)] {
+				note = ", hidden pending review"
+			}
+			lines = append(lines, fmt.Sprintf("%s/console/reports/ %d reports%s", gemini.Link, n, note))
+		}
 		for _, textLine := range GetLinesOfPost(p.Text) {
 			lines = append(lines, fmt.Sprintf("%s%s", gemini.Quote, textLine))
 		}
@@ -134,6 +220,10 @@ func ThreadViewHandler(u *url.URL, c *tls.Conn) gemini.Response {
 		)
 	}
 
+	if len(pageLinks) != 0 {
+		lines = append(lines, pageLinks...)
+		lines = append(lines, "")
+	}
 	lines = append(lines, writeReplyLines...)
 
 	return gemini.ResponseFormat{
@@ -152,15 +242,3 @@ func GetLinesOfPost(text string) (out []string) {
 	}
 	return
 }
-
-func TimeFormatForPost(t time.Time) string {
-	/*
-		If time is less than 24 hours old, print
-
-		Otherwise, print UTC in some format
-	*/
-	if time.Since(t).Hours() < float64(24) {
-		return fmt.Sprintf("%s", durafmt.ParseShort(time.Since(t)))
-	}
-	return t.UTC().Format(time.RFC1123)
-}